@@ -2,15 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/charmbracelet/bubbles/timer"
 	"gophkeeper/cmd/cli/client"
 	"gophkeeper/cmd/cli/ui/authui"
 	"gophkeeper/cmd/cli/ui/creditcardui"
 	"gophkeeper/cmd/cli/ui/credsui"
 	"gophkeeper/cmd/cli/ui/textui"
 	"gophkeeper/internal/domain"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/charmbracelet/bubbles/table"
@@ -25,6 +27,13 @@ const (
 	cred
 )
 
+// material types of the tables
+var tableMaterials = []domain.MaterialType{
+	text: domain.MaterialText,
+	card: domain.MaterialCard,
+	cred: domain.MaterialCred,
+}
+
 type Mode int
 
 const (
@@ -32,8 +41,20 @@ const (
 	ModeBrowse
 	ModeEdit
 	ModeAdd
+	ModeSessions
+	ModeDelete // waits for confirmation to delete the selected row
+	ModeTrash
+	ModeHistory
+	ModeConflict // the update was rejected, because the item was changed on the server
+	ModeSearch   // the search query is typed
 )
 
+// a warning is shown when so few recovery codes are left
+const lowRecoveryCodes = 3
+
+// rows of a table shown at once, large vaults are paged
+const pageSize = 100
+
 var (
 	modelStyle = lipgloss.NewStyle().
 		//	Width(40).
@@ -46,7 +67,8 @@ var (
 		Align(lipgloss.Center, lipgloss.Center).
 		BorderStyle(lipgloss.NormalBorder()).
 		BorderForeground(lipgloss.Color("69"))
-	helpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	helpStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
 	//tableStyle = lipgloss.NewStyle().
 	//	BorderStyle(lipgloss.NormalBorder()).
 	//	BorderForeground(lipgloss.Color("240"))
@@ -55,15 +77,66 @@ var (
 
 type mainModel struct {
 	//widgets
-	auth     authui.Model
-	tables   []table.Model
-	editWgts []tea.Model
+	auth          authui.Model
+	tables        []table.Model
+	editWgts      []tea.Model
+	sessionsTable table.Model
+	trashTable    table.Model
+	historyTable  table.Model
+	searchInput   textinput.Model
+
+	//active sessions shown in sessionsTable
+	sessions []domain.SessionInfo
+
+	//deleted items shown in trashTable
+	trash []domain.TrashInfo
+
+	//previous values of the item shown in historyTable
+	history       []domain.VersionInfo
+	historyType   domain.MaterialType
+	historyItemID int
+
+	//credentials kept until the second factor is confirmed
+	pendingCreds authui.Creds
+
+	//credentials to sign in with, when the server is back, the local copy of the vault is shown until then
+	offlineCreds *authui.Creds
+
+	//recovery codes left, shown if the second factor is enabled
+	recovery domain.RecoveryCodesStatus
+
+	//revisions of the loaded items by id for every table
+	revisions []map[int]int
+
+	//the page shown and the number of pages for every table
+	pages      []int
+	pageCounts []int
+
+	//ids of all rows in the order they are shown for every table
+	rowIDs [][]int
+
+	//items found by the last search and the one shown
+	searchResults []domain.SearchResult
+	searchAt      int
+
+	//the item being edited, the row and its revision the edit is based on
+	editID       int
+	editBase     table.Row
+	editRevision int
+
+	//the rejected update waiting to be overwritten or merged
+	conflict     *client.ConflictError
+	conflictMine table.Row
+	overwrite    func(revision int) error
+
+	//rejected changes made offline, they are shown one by one
+	conflicts []*client.ConflictError
 
 	//variables
-	currentTable  int
-	status        string
-	mode          Mode
-	syncDataTimer timer.Model // used without view
+	currentTable int
+	status       string
+	mode         Mode
+	events       <-chan domain.MaterialEvent // changes pushed by the server
 
 	//client
 	client *client.GKClient
@@ -74,12 +147,36 @@ type mainModel struct {
 	cancel context.CancelFunc
 }
 
+// eventMsg is a change of the vault made on any device, including this one
+type eventMsg domain.MaterialEvent
+
+// reconnectMsg is sent to retry sign in, while the local copy of the vault is shown
+type reconnectMsg struct{}
+
+const reconnectInterval = 10 * time.Second
+
+func reconnectLater() tea.Cmd {
+	return tea.Tick(reconnectInterval, func(time.Time) tea.Msg {
+		return reconnectMsg{}
+	})
+}
+
+// waitForEvent makes a command delivering the next event, it should be run again after each one.
+func waitForEvent(events <-chan domain.MaterialEvent) tea.Cmd {
+	return func() tea.Msg {
+		event, ok := <-events
+		if !ok {
+			return nil
+		}
+		return eventMsg(event)
+	}
+}
+
 func newModel() mainModel {
 	//init variables
 	m := mainModel{
-		currentTable:  0,
-		mode:          ModeAuth,
-		syncDataTimer: timer.NewWithInterval(3*time.Second, 3*time.Second),
+		currentTable: 0,
+		mode:         ModeAuth,
 	}
 
 	//init widgets
@@ -90,11 +187,23 @@ func newModel() mainModel {
 	m.tables[card] = createCardDataTable(nil)
 	m.tables[cred] = createCredDataTable(nil)
 
+	m.revisions = make([]map[int]int, 3, 3)
+	m.pages = make([]int, 3, 3)
+	m.pageCounts = make([]int, 3, 3)
+	m.rowIDs = make([][]int, 3, 3)
+
 	m.editWgts = make([]tea.Model, 3, 3)
 	m.editWgts[text] = textui.New()
 	m.editWgts[card] = creditcardui.New()
 	m.editWgts[cred] = credsui.New()
 
+	m.sessionsTable = createSessionsTable(nil)
+	m.searchInput = textinput.New()
+	m.searchInput.Prompt = "/"
+	m.searchInput.Placeholder = "search titles, logins, metadata and notes"
+	m.trashTable = createTrashTable(nil)
+	m.historyTable = createHistoryTable(nil)
+
 	//client
 	m.client = client.NewGKClient("http://localhost:8081")
 
@@ -118,69 +227,112 @@ func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			Login:    msg.Login,
 			Password: msg.Password,
 		})
+		if errors.Is(err, domain.ErrSecondFactorRequired) {
+			m.pendingCreds = authui.Creds(msg)
+			m.auth = m.auth.WithCodeStep()
+			m.status = ""
+			break
+		}
+		if errors.Is(err, client.ErrOffline) {
+			if err := m.client.OpenLocalVault(msg.Login, msg.MasterPassword); err != nil {
+				m.status = client.ErrOffline.Error() + ", " + err.Error()
+				break
+			}
+
+			creds := authui.Creds(msg)
+			m.offlineCreds = &creds
+			m.mode = ModeBrowse
+			m.loadData()
+			cmds = append(cmds, reconnectLater())
+			break
+		}
+		if err == nil {
+			err = m.client.Unlock(m.ctx, msg.Login, msg.MasterPassword)
+		}
 
 		if err != nil {
 			m.status = err.Error()
 		} else {
-			m.mode = ModeBrowse
-			m.errC = m.client.KeepTokensFresh(m.ctx)
-			cmds = append(cmds, m.syncDataTimer.Init())
+			cmds = append(cmds, m.startSession())
+		}
+	case authui.CodeMsg:
+		_, err := m.client.UserSignInWithCode(m.ctx, msg.Code)
+		if err == nil {
+			err = m.client.Unlock(m.ctx, m.pendingCreds.Login, m.pendingCreds.MasterPassword)
+		}
+
+		if err != nil {
+			m.status = err.Error()
+		} else {
+			m.pendingCreds = authui.Creds{}
+			cmds = append(cmds, m.startSession())
 		}
 	case authui.SignUpMsg: //TODO get rid of code duplication
 		_, err := m.client.UserSignUp(context.Background(), client.AuthInput{
 			Login:    msg.Login,
 			Password: msg.Password,
 		})
+		if err == nil {
+			err = m.client.Unlock(m.ctx, msg.Login, msg.MasterPassword)
+		}
 
 		if err != nil {
 			m.status = err.Error()
 		} else {
-			m.mode = ModeBrowse
-			m.errC = m.client.KeepTokensFresh(m.ctx)
-			cmds = append(cmds, m.syncDataTimer.Init())
+			cmds = append(cmds, m.startSession())
 		}
 	case credsui.ChangedMsg:
 		switch m.mode {
 		case ModeEdit:
-			id, _ := strconv.Atoi(m.tables[cred].SelectedRow()[0])
-			err := m.client.UpdateCredData(m.ctx, domain.CredData{
-				ID:       id,
+			data := domain.CredData{
+				ID:       m.editID,
 				Login:    msg.Login,
 				Password: msg.Password,
 				Metadata: msg.Metadata,
-			})
-			if err != nil {
-				m.status = err.Error()
 			}
+			gk, ctx := m.client, m.ctx
+			m.tryUpdate(func(revision int) error {
+				data.Revision = revision
+				return gk.UpdateCredData(ctx, data)
+			}, m.editRevision, credDataRow(data))
 		case ModeAdd:
-			err := m.client.CreateNewCredData(m.ctx, domain.CredData{
+			data, err := m.client.CreateNewCredData(m.ctx, domain.CredData{
 				Login:    msg.Login,
 				Password: msg.Password,
 				Metadata: msg.Metadata,
 			})
 			if err != nil {
 				m.status = err.Error()
+			} else {
+				m.showCreated(cred, data.ID)
 			}
 		}
-		m.mode = ModeBrowse
+		if m.mode != ModeConflict {
+			m.mode = ModeBrowse
+		}
+		if m.client.PendingChanges() > 0 {
+			// changes kept offline don't come with events
+			m.showData()
+		}
 	case creditcardui.ChangedMsg:
 		switch m.mode {
 		case ModeEdit:
-			id, _ := strconv.Atoi(m.tables[card].SelectedRow()[0])
-			err := m.client.UpdateCardData(m.ctx, domain.CardData{
-				ID:         id,
+			data := domain.CardData{
+				ID:         m.editID,
 				CardNumber: msg.Number,
 				ExpDate:    msg.ExpDate,
 				CVV:        msg.CVV,
 				Name:       msg.Name,
 				Surname:    msg.Surname,
 				Metadata:   msg.Metadata,
-			})
-			if err != nil {
-				m.status = err.Error()
 			}
+			gk, ctx := m.client, m.ctx
+			m.tryUpdate(func(revision int) error {
+				data.Revision = revision
+				return gk.UpdateCardData(ctx, data)
+			}, m.editRevision, cardDataRow(data))
 		case ModeAdd:
-			err := m.client.CreateNewCardData(m.ctx, domain.CardData{
+			data, err := m.client.CreateNewCardData(m.ctx, domain.CardData{
 				CardNumber: msg.Number,
 				ExpDate:    msg.ExpDate,
 				CVV:        msg.CVV,
@@ -190,78 +342,252 @@ func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			})
 			if err != nil {
 				m.status = err.Error()
+			} else {
+				m.showCreated(card, data.ID)
 			}
 		}
-		m.mode = ModeBrowse
+		if m.mode != ModeConflict {
+			m.mode = ModeBrowse
+		}
+		if m.client.PendingChanges() > 0 {
+			// changes kept offline don't come with events
+			m.showData()
+		}
 	case textui.ChangedMsg:
 		switch m.mode {
 		case ModeEdit:
-			id, _ := strconv.Atoi(m.tables[text].SelectedRow()[0])
-			err := m.client.UpdateTextData(m.ctx, domain.TextData{
-				ID:       id,
+			data := domain.TextData{
+				ID:       m.editID,
 				Text:     msg.Text,
 				Metadata: msg.Metadata,
-			})
-			if err != nil {
-				m.status = err.Error()
 			}
+			gk, ctx := m.client, m.ctx
+			m.tryUpdate(func(revision int) error {
+				data.Revision = revision
+				return gk.UpdateTextData(ctx, data)
+			}, m.editRevision, textDataRow(data))
 		case ModeAdd:
-			err := m.client.CreateNewTextData(m.ctx, domain.TextData{
+			data, err := m.client.CreateNewTextData(m.ctx, domain.TextData{
 				Text:     msg.Text,
 				Metadata: msg.Metadata,
 			})
 			if err != nil {
 				m.status = err.Error()
+			} else {
+				m.showCreated(text, data.ID)
 			}
 		}
-		m.mode = ModeBrowse
-	case timer.TickMsg, timer.StartStopMsg:
-		m.syncDataTimer, cmd = m.syncDataTimer.Update(msg)
-		cmds = append(cmds, m.syncDataTimer.Init())
+		if m.mode != ModeConflict {
+			m.mode = ModeBrowse
+		}
+		if m.client.PendingChanges() > 0 {
+			// changes kept offline don't come with events
+			m.showData()
+		}
+	case reconnectMsg:
+		if m.offlineCreds == nil {
+			break
+		}
 
-		textRows, err := m.client.GetAllTextData(m.ctx)
-		if err != nil {
+		_, err := m.client.UserSignIn(m.ctx, client.AuthInput{
+			Login:    m.offlineCreds.Login,
+			Password: m.offlineCreds.Password,
+		})
+		switch {
+		case errors.Is(err, client.ErrOffline):
+			cmds = append(cmds, reconnectLater())
+		case errors.Is(err, domain.ErrSecondFactorRequired):
+			m.pendingCreds, m.offlineCreds = *m.offlineCreds, nil
+			m.auth = m.auth.WithCodeStep()
+			m.mode = ModeAuth
+			m.status = "server is back, enter the code to send the changes"
+		case err != nil:
+			m.offlineCreds = nil
+			m.mode = ModeAuth
 			m.status = err.Error()
-		} else {
-			cursor := m.tables[text].Cursor()
-			m.tables[text] = createTextDataTable(textRows)
-			if cursor != -1 {
-				m.tables[text].SetCursor(cursor)
+		default:
+			// the vault is unlocked already
+			m.offlineCreds = nil
+			m.status = ""
+			cmds = append(cmds, m.startSession())
+		}
+	case eventMsg:
+		m.loadData()
+		switch m.mode {
+		case ModeTrash:
+			m.loadTrash()
+		case ModeHistory:
+			m.loadHistory()
+		}
+		cmds = append(cmds, waitForEvent(m.events))
+	case tea.KeyMsg:
+		if m.mode == ModeConflict {
+			switch msg.String() {
+			case "o":
+				m.mode = ModeBrowse
+				m.tryUpdate(m.overwrite, m.conflict.Revision, m.conflictMine)
+				m.loadData()
+			case "m":
+				theirs := m.conflictRow()
+				m.startEdit(mergeRows(m.editBase, m.conflictMine, theirs))
+				m.editBase, m.editRevision = theirs, m.conflict.Revision
+				m.mode = ModeEdit
+			case "esc":
+				m.mode = ModeBrowse
+				m.showNextConflict()
 			}
+			break
 		}
 
-		cardRows, err := m.client.GetAllCardData(m.ctx)
-		if err != nil {
-			m.status = err.Error()
-		} else {
-			cursor := m.tables[card].Cursor()
-			m.tables[card] = createCardDataTable(cardRows)
-			if cursor != -1 {
-				m.tables[card].SetCursor(cursor)
+		if m.mode == ModeDelete {
+			if msg.String() == "y" {
+				m.deleteSelectedRow()
+			} else {
+				m.status = ""
 			}
+			m.mode = ModeBrowse
+			break
 		}
 
-		credsRows, err := m.client.GetAllCredsData(m.ctx)
-		if err != nil {
-			m.status = err.Error()
-		} else {
-			cursor := m.tables[cred].Cursor()
-			m.tables[cred] = createCredDataTable(credsRows)
-			if cursor != -1 {
-				m.tables[cred].SetCursor(cursor)
+		if m.mode == ModeSearch {
+			switch msg.String() {
+			case "enter":
+				m.mode = ModeBrowse
+				m.search(m.searchInput.Value())
+			case "esc":
+				m.mode = ModeBrowse
+			default:
+				m.searchInput, cmd = m.searchInput.Update(msg)
+				cmds = append(cmds, cmd)
 			}
+			break
 		}
-	case tea.KeyMsg:
+
 		switch msg.String() {
 		case "ctrl+c", "q":
 			if m.mode != ModeEdit && m.mode != ModeAdd {
+				if m.mode == ModeBrowse {
+					_ = m.client.UserLogout(m.ctx)
+				}
 				m.cancel()
 				return m, tea.Quit
 			}
 		case "esc":
-			if m.mode == ModeEdit || m.mode == ModeAdd {
+			if m.mode == ModeEdit || m.mode == ModeAdd || m.mode == ModeSessions || m.mode == ModeTrash || m.mode == ModeHistory {
 				m.mode = ModeBrowse
 			}
+		case "s":
+			if m.mode != ModeBrowse {
+				break
+			}
+			m.loadSessions()
+			m.mode = ModeSessions
+		case "t":
+			if m.mode != ModeBrowse {
+				break
+			}
+			m.loadTrash()
+			m.mode = ModeTrash
+		case "h":
+			if m.mode != ModeBrowse || m.tables[m.currentTable].Cursor() < 0 || len(m.tables[m.currentTable].SelectedRow()) == 0 {
+				break
+			}
+
+			id, err := strconv.Atoi(m.tables[m.currentTable].SelectedRow()[0])
+			if err != nil {
+				m.status = err.Error()
+				break
+			}
+
+			m.historyType, m.historyItemID = tableMaterials[m.currentTable], id
+			m.history = nil
+			m.historyTable = createHistoryTable(nil)
+			m.loadHistory()
+			m.mode = ModeHistory
+		case "r":
+			if m.mode == ModeHistory {
+				cursor := m.historyTable.Cursor()
+				if cursor < 0 || cursor >= len(m.history) {
+					break
+				}
+
+				if err := m.client.RestoreVersion(m.ctx, m.historyType, m.historyItemID, m.history[cursor].ID); err != nil {
+					m.status = err.Error()
+				}
+				m.loadHistory()
+				m.loadData()
+				break
+			}
+			if m.mode != ModeTrash {
+				break
+			}
+
+			cursor := m.trashTable.Cursor()
+			if cursor < 0 || cursor >= len(m.trash) {
+				break
+			}
+
+			if err := m.client.RestoreFromTrash(m.ctx, m.trash[cursor].ID); err != nil {
+				m.status = err.Error()
+			}
+			m.loadTrash()
+			m.loadData()
+		case "d":
+			if m.mode == ModeBrowse && m.tables[m.currentTable].Cursor() >= 0 && len(m.tables[m.currentTable].SelectedRow()) > 0 {
+				m.mode = ModeDelete
+				m.status = "delete the selected row? y: delete • any other key: cancel\n"
+				break
+			}
+			if m.mode != ModeSessions {
+				break
+			}
+
+			cursor := m.sessionsTable.Cursor()
+			if cursor < 0 || cursor >= len(m.sessions) {
+				break
+			}
+
+			if m.sessions[cursor].Current {
+				m.status = "it's the current session, press q to log out"
+				break
+			}
+
+			if err := m.client.TerminateSession(m.ctx, m.sessions[cursor].ID); err != nil {
+				m.status = err.Error()
+			}
+			m.loadSessions()
+		case "/":
+			if m.mode != ModeBrowse {
+				break
+			}
+
+			m.searchInput.Reset()
+			m.searchInput.Focus()
+			m.mode = ModeSearch
+			cmds = append(cmds, textinput.Blink)
+		case "ctrl+n":
+			if m.mode != ModeBrowse || len(m.searchResults) == 0 {
+				break
+			}
+
+			m.searchAt = (m.searchAt + 1) % len(m.searchResults)
+			m.showMatch()
+		case "n", "p":
+			if m.mode != ModeBrowse {
+				break
+			}
+
+			page := m.pages[m.currentTable] + 1
+			if msg.String() == "p" {
+				page = m.pages[m.currentTable] - 1
+			}
+			if page < 0 || page >= m.pageCounts[m.currentTable] {
+				break
+			}
+
+			m.pages[m.currentTable] = page
+			m.showData()
+			m.tables[m.currentTable].GotoTop()
 		case "tab":
 			if m.mode == ModeBrowse {
 				m.currentTable = (m.currentTable + 1) % len(m.tables)
@@ -289,47 +615,22 @@ func (m mainModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				break
 			}
 
-			switch editWgt := m.editWgts[m.currentTable].(type) {
-			case textui.Model:
-				//0 - data id
-				//1 - text id
-				//2 - metadata
-				editWgt.SetData(textui.ChangedMsg{
-					Text:     cells[1],
-					Metadata: cells[2],
-				})
-			case creditcardui.Model:
-				//0 - data id
-				//1 - card number
-				//2 - exp date
-				//3 - cvv
-				//4 - name
-				//5 - surname
-				//6 - metadata
-				date, _ := time.Parse("01/06", cells[2])
-				editWgt.SetData(creditcardui.ChangedMsg{
-					Number:   cells[1],
-					ExpDate:  date,
-					CVV:      cells[3],
-					Name:     cells[4],
-					Surname:  cells[5],
-					Metadata: cells[6],
-				})
-			case credsui.Model:
-				//0 - data id
-				//1 - login
-				//2 - password
-				//3 - metadata
-				editWgt.SetData(credsui.ChangedMsg{
-					Login:    cells[1],
-					Password: cells[2],
-					Metadata: cells[3],
-				})
-			}
+			id, _ := strconv.Atoi(cells[0])
+			m.editID, m.editBase, m.editRevision = id, cells, m.revisions[m.currentTable][id]
+			m.startEdit(cells)
 			m.mode = ModeEdit
 		}
 
-		if m.mode != ModeAuth {
+		if m.mode == ModeSessions {
+			m.sessionsTable, cmd = m.sessionsTable.Update(msg)
+			cmds = append(cmds, cmd)
+		} else if m.mode == ModeTrash {
+			m.trashTable, cmd = m.trashTable.Update(msg)
+			cmds = append(cmds, cmd)
+		} else if m.mode == ModeHistory {
+			m.historyTable, cmd = m.historyTable.Update(msg)
+			cmds = append(cmds, cmd)
+		} else if m.mode != ModeAuth {
 			m.tables[m.currentTable], cmd = m.tables[m.currentTable].Update(msg)
 			cmds = append(cmds, cmd)
 		}
@@ -366,7 +667,13 @@ func (m mainModel) View() string {
 	var s string
 
 	//var status string
-	if m.mode != ModeAuth {
+	if m.mode == ModeSessions {
+		s += focusedModelStyle.Render(m.sessionsTable.View())
+	} else if m.mode == ModeTrash {
+		s += focusedModelStyle.Render(m.trashTable.View())
+	} else if m.mode == ModeHistory {
+		s += focusedModelStyle.Render(m.historyTable.View())
+	} else if m.mode != ModeAuth {
 		var line []string
 		for i, tbl := range m.tables {
 			if i == m.currentTable {
@@ -391,9 +698,43 @@ func (m mainModel) View() string {
 	}
 
 	if m.mode == ModeBrowse {
-		help := "\ntab: focus next • q: exit • a: add new row • e: edit selected row\n"
+		if m.pageCounts[m.currentTable] > 1 {
+			s += helpStyle.Render(fmt.Sprintf("\npage %d/%d", m.pages[m.currentTable]+1, m.pageCounts[m.currentTable]))
+		}
+		help := "\ntab: focus next • q: exit • a: add new row • e: edit selected row • d: delete selected row • h: history of selected row • t: trash • s: sessions • n/p: next/previous page • /: search\n"
+		s += helpStyle.Render(fmt.Sprintf(help))
+	}
+	if m.mode == ModeSearch {
+		s += "\n" + m.searchInput.View()
+		help := "\nenter: find • esc: back\n"
+		s += helpStyle.Render(fmt.Sprintf(help))
+	}
+	if m.mode == ModeSessions {
+		help := "\nesc: back • d: terminate selected session\n"
+		s += helpStyle.Render(fmt.Sprintf(help))
+	}
+	if m.mode == ModeTrash {
+		help := "\nesc: back • r: restore selected item\n"
 		s += helpStyle.Render(fmt.Sprintf(help))
 	}
+	if m.mode == ModeConflict {
+		s += warningStyle.Render(fmt.Sprintf("\nthe item was changed on another device\nserver: %s\nyours:  %s\n",
+			strings.Join(m.conflictRow()[1:], " | "), strings.Join(m.conflictMine[1:], " | ")))
+		help := "o: overwrite with yours • m: merge and edit • esc: discard yours\n"
+		s += helpStyle.Render(fmt.Sprintf(help))
+	}
+	if m.mode == ModeHistory {
+		help := "\nesc: back • r: restore selected version\n"
+		s += helpStyle.Render(fmt.Sprintf(help))
+	}
+	if m.mode == ModeBrowse && m.recovery.Enabled {
+		msg := fmt.Sprintf("recovery codes left: %d\n", m.recovery.Remaining)
+		if m.recovery.Remaining <= lowRecoveryCodes {
+			s += warningStyle.Render(msg + "you are running out of recovery codes, regenerate them\n")
+		} else {
+			s += helpStyle.Render(msg)
+		}
+	}
 	s += helpStyle.Render(fmt.Sprintf(m.status))
 
 	return s
@@ -435,9 +776,7 @@ func createTextDataTable(data []domain.TextData) table.Model {
 
 	rows := make([]table.Row, 0, len(data))
 	for _, row := range data {
-		rows = append(rows, table.Row{
-			strconv.Itoa(row.ID), row.Text, row.Metadata,
-		})
+		rows = append(rows, textDataRow(row))
 	}
 
 	return createTable(columns, rows)
@@ -456,9 +795,7 @@ func createCardDataTable(data []domain.CardData) table.Model {
 
 	rows := make([]table.Row, 0, len(data))
 	for _, row := range data {
-		rows = append(rows, table.Row{
-			strconv.Itoa(row.ID), row.CardNumber, row.ExpDate.Format("01/06"), row.CVV, row.Name, row.Surname, row.Metadata,
-		})
+		rows = append(rows, cardDataRow(row))
 	}
 
 	return createTable(columns, rows)
@@ -472,16 +809,463 @@ func createCredDataTable(data []domain.CredData) table.Model {
 		{Title: "Metadata", Width: 20},
 	}
 
+	rows := make([]table.Row, 0, len(data))
+	for _, row := range data {
+		rows = append(rows, credDataRow(row))
+	}
+
+	return createTable(columns, rows)
+}
+
+func textDataRow(data domain.TextData) table.Row {
+	return table.Row{strconv.Itoa(data.ID), data.Text, data.Metadata}
+}
+
+func cardDataRow(data domain.CardData) table.Row {
+	return table.Row{
+		strconv.Itoa(data.ID), data.CardNumber, data.ExpDate.Format("01/06"), data.CVV, data.Name, data.Surname, data.Metadata,
+	}
+}
+
+func credDataRow(data domain.CredData) table.Row {
+	return table.Row{strconv.Itoa(data.ID), data.Login, data.Password, data.Metadata}
+}
+
+func createSessionsTable(data []domain.SessionInfo) table.Model {
+	columns := []table.Column{
+		{Title: "Device", Width: 15},
+		{Title: "User agent", Width: 20},
+		{Title: "IP", Width: 15},
+		{Title: "Signed in", Width: 16},
+		{Title: "Last used", Width: 16},
+		{Title: "", Width: 7},
+	}
+
+	rows := make([]table.Row, 0, len(data))
+	for _, row := range data {
+		current := ""
+		if row.Current {
+			current = "current"
+		}
+		rows = append(rows, table.Row{
+			row.DeviceName, row.UserAgent, row.IP, row.CreatedAt.Format("2006-01-02 15:04"), row.LastUsedAt.Format("2006-01-02 15:04"), current,
+		})
+	}
+
+	return createTable(columns, rows)
+}
+
+func createTrashTable(data []domain.TrashInfo) table.Model {
+	columns := []table.Column{
+		{Title: "Type", Width: 5},
+		{Title: "id", Width: 4},
+		{Title: "Title", Width: 20},
+		{Title: "Deleted", Width: 16},
+	}
+
+	rows := make([]table.Row, 0, len(data))
+	for _, row := range data {
+		rows = append(rows, table.Row{
+			row.Type.String(), strconv.Itoa(row.ItemID), row.Title, row.DeletedAt.Format("2006-01-02 15:04"),
+		})
+	}
+
+	return createTable(columns, rows)
+}
+
+func createHistoryTable(data []domain.VersionInfo) table.Model {
+	columns := []table.Column{
+		{Title: "Version", Width: 7},
+		{Title: "Value", Width: 30},
+		{Title: "Replaced", Width: 16},
+	}
+
 	rows := make([]table.Row, 0, len(data))
 	for _, row := range data {
 		rows = append(rows, table.Row{
-			strconv.Itoa(row.ID), row.Login, row.Password, row.Metadata,
+			strconv.Itoa(row.ID), row.Title, row.ReplacedAt.Format("2006-01-02 15:04"),
 		})
 	}
 
 	return createTable(columns, rows)
 }
 
+// startEdit fills the edit widget of the current table with the cells of a row.
+func (m *mainModel) startEdit(cells []string) {
+	switch editWgt := m.editWgts[m.currentTable].(type) {
+	case textui.Model:
+		//0 - data id
+		//1 - text id
+		//2 - metadata
+		editWgt.SetData(textui.ChangedMsg{
+			Text:     cells[1],
+			Metadata: cells[2],
+		})
+	case creditcardui.Model:
+		//0 - data id
+		//1 - card number
+		//2 - exp date
+		//3 - cvv
+		//4 - name
+		//5 - surname
+		//6 - metadata
+		date, _ := time.Parse("01/06", cells[2])
+		editWgt.SetData(creditcardui.ChangedMsg{
+			Number:   cells[1],
+			ExpDate:  date,
+			CVV:      cells[3],
+			Name:     cells[4],
+			Surname:  cells[5],
+			Metadata: cells[6],
+		})
+	case credsui.Model:
+		//0 - data id
+		//1 - login
+		//2 - password
+		//3 - metadata
+		editWgt.SetData(credsui.ChangedMsg{
+			Login:    cells[1],
+			Password: cells[2],
+			Metadata: cells[3],
+		})
+	}
+}
+
+// tryUpdate sends the update based on the revision. If the item was changed since then,
+// the conflict is kept to let the user overwrite the server version or merge the changes.
+func (m *mainModel) tryUpdate(update func(revision int) error, revision int, mine table.Row) {
+	err := update(revision)
+
+	var conflict *client.ConflictError
+	if errors.As(err, &conflict) {
+		m.conflict, m.conflictMine, m.overwrite = conflict, mine, update
+		m.mode = ModeConflict
+		return
+	}
+
+	if err != nil {
+		m.status = err.Error()
+	}
+}
+
+// conflictRow returns the server version of the conflicting item as a table row.
+func (m *mainModel) conflictRow() table.Row {
+	return dataRow(m.conflict.Current)
+}
+
+// dataRow returns text, card or cred data as a table row.
+func dataRow(v interface{}) table.Row {
+	switch data := v.(type) {
+	case domain.TextData:
+		return textDataRow(data)
+	case domain.CardData:
+		return cardDataRow(data)
+	case domain.CredData:
+		return credDataRow(data)
+	default:
+		return nil
+	}
+}
+
+// showNextConflict shows a rejected change made offline, unless the user is busy with something else.
+func (m *mainModel) showNextConflict() {
+	if m.mode != ModeBrowse || len(m.conflicts) == 0 {
+		return
+	}
+
+	conflict := m.conflicts[0]
+	m.conflicts = m.conflicts[1:]
+
+	for i, t := range tableMaterials {
+		if t == conflict.Type {
+			m.currentTable = i
+		}
+	}
+
+	m.conflict, m.conflictMine, m.overwrite = conflict, dataRow(conflict.Mine), m.updateFunc(conflict.Mine)
+	m.editID, _ = strconv.Atoi(m.conflictMine[0])
+	m.editBase = dataRow(conflict.Base)
+	m.mode = ModeConflict
+}
+
+// updateFunc makes the update of the item based on a revision, it's used to overwrite the server version.
+func (m *mainModel) updateFunc(v interface{}) func(revision int) error {
+	gk, ctx := m.client, m.ctx
+	return func(revision int) error {
+		switch data := v.(type) {
+		case domain.TextData:
+			data.Revision = revision
+			return gk.UpdateTextData(ctx, data)
+		case domain.CardData:
+			data.Revision = revision
+			return gk.UpdateCardData(ctx, data)
+		case domain.CredData:
+			data.Revision = revision
+			return gk.UpdateCredData(ctx, data)
+		default:
+			return domain.ErrUnknownMaterialType
+		}
+	}
+}
+
+// mergeRows takes the fields changed by the user from mine and the rest from theirs.
+func mergeRows(base, mine, theirs table.Row) table.Row {
+	merged := make(table.Row, len(theirs))
+	for i := range theirs {
+		if i < len(mine) && i < len(base) && mine[i] != base[i] {
+			merged[i] = mine[i]
+		} else {
+			merged[i] = theirs[i]
+		}
+	}
+	return merged
+}
+
+// loadData syncs the vault items and rebuilds the tables, if anything was changed.
+func (m *mainModel) loadData() {
+	changed, err := m.client.Sync(m.ctx)
+	switch {
+	case errors.Is(err, client.ErrOffline):
+		m.status = fmt.Sprintf("offline, %d changes are kept locally", m.client.PendingChanges())
+	case err != nil:
+		m.status = err.Error()
+	}
+
+	m.conflicts = append(m.conflicts, m.client.Conflicts()...)
+	m.showNextConflict()
+
+	if changed {
+		m.showData()
+	}
+}
+
+// showCreated shows the item created on the server or kept offline and selects it.
+func (m *mainModel) showCreated(tbl int, id int) {
+	// rows are ordered by id, ids given by the server grow and local ones are negative and decrease
+	if id > 0 {
+		m.pages[tbl] = math.MaxInt32
+		m.showData()
+		m.tables[tbl].GotoBottom()
+	} else {
+		m.pages[tbl] = 0
+		m.showData()
+		m.tables[tbl].GotoTop()
+	}
+}
+
+// pageBounds keeps the page of the table within the rows and returns the rows shown on it.
+func (m *mainModel) pageBounds(tbl int, total int) (int, int) {
+	m.pageCounts[tbl] = (total + pageSize - 1) / pageSize
+	if m.pages[tbl] >= m.pageCounts[tbl] {
+		m.pages[tbl] = m.pageCounts[tbl] - 1
+	}
+	if m.pages[tbl] < 0 {
+		m.pages[tbl] = 0
+	}
+
+	from, to := m.pages[tbl]*pageSize, (m.pages[tbl]+1)*pageSize
+	if to > total {
+		to = total
+	}
+	return from, to
+}
+
+// showData rebuilds the tables from the local replica. Pages and cursor positions are kept.
+func (m *mainModel) showData() {
+	textRows, err := m.client.LocalTextData()
+	if err != nil {
+		m.status = err.Error()
+	} else {
+		cursor := m.tables[text].Cursor()
+		from, to := m.pageBounds(text, len(textRows))
+		m.tables[text] = createTextDataTable(textRows[from:to])
+		m.revisions[text] = make(map[int]int, len(textRows))
+		m.rowIDs[text] = make([]int, 0, len(textRows))
+		for _, row := range textRows {
+			m.revisions[text][row.ID] = row.Revision
+			m.rowIDs[text] = append(m.rowIDs[text], row.ID)
+		}
+		if cursor != -1 {
+			m.tables[text].SetCursor(cursor)
+		}
+	}
+
+	cardRows, err := m.client.LocalCardData()
+	if err != nil {
+		m.status = err.Error()
+	} else {
+		cursor := m.tables[card].Cursor()
+		from, to := m.pageBounds(card, len(cardRows))
+		m.tables[card] = createCardDataTable(cardRows[from:to])
+		m.revisions[card] = make(map[int]int, len(cardRows))
+		m.rowIDs[card] = make([]int, 0, len(cardRows))
+		for _, row := range cardRows {
+			m.revisions[card][row.ID] = row.Revision
+			m.rowIDs[card] = append(m.rowIDs[card], row.ID)
+		}
+		if cursor != -1 {
+			m.tables[card].SetCursor(cursor)
+		}
+	}
+
+	credsRows, err := m.client.LocalCredData()
+	if err != nil {
+		m.status = err.Error()
+	} else {
+		cursor := m.tables[cred].Cursor()
+		from, to := m.pageBounds(cred, len(credsRows))
+		m.tables[cred] = createCredDataTable(credsRows[from:to])
+		m.revisions[cred] = make(map[int]int, len(credsRows))
+		m.rowIDs[cred] = make([]int, 0, len(credsRows))
+		for _, row := range credsRows {
+			m.revisions[cred][row.ID] = row.Revision
+			m.rowIDs[cred] = append(m.rowIDs[cred], row.ID)
+		}
+		if cursor != -1 {
+			m.tables[cred].SetCursor(cursor)
+		}
+	}
+}
+
+// search finds the items matching the query and shows the best one.
+func (m *mainModel) search(query string) {
+	results, err := m.client.Search(m.ctx, query)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	if len(results) == 0 {
+		m.searchResults = nil
+		m.status = fmt.Sprintf("nothing found for %q", query)
+		return
+	}
+
+	m.searchResults, m.searchAt = results, 0
+	m.showMatch()
+}
+
+// showMatch selects the found item in its table, the page with it is shown. Files aren't shown in the tables,
+// so only their names are told.
+func (m *mainModel) showMatch() {
+	match := m.searchResults[m.searchAt]
+	m.status = fmt.Sprintf("match %d/%d: %s %q • ctrl+n: next match", m.searchAt+1, len(m.searchResults), match.Type, match.Title)
+
+	for tbl, t := range tableMaterials {
+		if t != match.Type {
+			continue
+		}
+
+		for i, id := range m.rowIDs[tbl] {
+			if id == match.ID {
+				m.currentTable, m.pages[tbl] = tbl, i/pageSize
+				m.showData()
+				m.tables[tbl].SetCursor(i % pageSize)
+				return
+			}
+		}
+		m.status = fmt.Sprintf("match %d/%d was deleted", m.searchAt+1, len(m.searchResults))
+	}
+}
+
+// deleteSelectedRow deletes the item selected in the current table.
+func (m *mainModel) deleteSelectedRow() {
+	id, err := strconv.Atoi(m.tables[m.currentTable].SelectedRow()[0])
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+
+	switch m.currentTable {
+	case text:
+		err = m.client.DeleteTextData(m.ctx, id)
+	case card:
+		err = m.client.DeleteCardData(m.ctx, id)
+	case cred:
+		err = m.client.DeleteCredData(m.ctx, id)
+	}
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+
+	m.status = ""
+	m.loadData()
+}
+
+// startSession starts the background work of the signed in user and returns the command to receive events.
+func (m *mainModel) startSession() tea.Cmd {
+	m.mode = ModeBrowse
+	m.errC = m.client.KeepTokensFresh(m.ctx)
+	m.loadRecoveryStatus()
+	m.events = m.client.SubscribeEvents(m.ctx)
+	return waitForEvent(m.events)
+}
+
+// loadRecoveryStatus fetches the number of unused recovery codes.
+func (m *mainModel) loadRecoveryStatus() {
+	status, err := m.client.GetRecoveryCodesStatus(m.ctx)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	m.recovery = status
+}
+
+// loadSessions fetches active sessions and keeps the cursor position.
+func (m *mainModel) loadSessions() {
+	sessions, err := m.client.GetSessions(m.ctx)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+
+	cursor := m.sessionsTable.Cursor()
+	m.sessions = sessions
+	m.sessionsTable = createSessionsTable(sessions)
+	if cursor >= len(sessions) {
+		cursor = len(sessions) - 1
+	}
+	if cursor != -1 {
+		m.sessionsTable.SetCursor(cursor)
+	}
+}
+
+func (m *mainModel) loadTrash() {
+	trash, err := m.client.GetTrash(m.ctx)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+
+	cursor := m.trashTable.Cursor()
+	m.trash = trash
+	m.trashTable = createTrashTable(trash)
+	if cursor >= len(trash) {
+		cursor = len(trash) - 1
+	}
+	if cursor != -1 {
+		m.trashTable.SetCursor(cursor)
+	}
+}
+
+func (m *mainModel) loadHistory() {
+	history, err := m.client.GetHistory(m.ctx, m.historyType, m.historyItemID)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+
+	cursor := m.historyTable.Cursor()
+	m.history = history
+	m.historyTable = createHistoryTable(history)
+	if cursor >= len(history) {
+		cursor = len(history) - 1
+	}
+	if cursor != -1 {
+		m.historyTable.SetCursor(cursor)
+	}
+}
+
 func main() {
 	//tea.NewProgram(creditcardui.New()).Start()
 	//tea.NewProgram(textui.New()).Start()
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/seal"
+	"io"
+	"mime/multipart"
+	"net/http"
+)
+
+const BlobDataEndpoint = "/api/materials/blob"
+
+// file names and notes are sealed apart from the content, so the list of files is fetched without downloads
+const (
+	blobMetadataAD = domain.MaterialBlob + ":metadata"
+	blobContentAD  = domain.MaterialBlob + ":content"
+)
+
+// blobMetadata keeps the random id of the file. The frames of the content are sealed with it,
+// so frames of different files can't be spliced into one.
+type blobMetadata struct {
+	Name     string `json:"name"`
+	Metadata string `json:"metadata"`
+	FileID   string `json:"file_id"`
+}
+
+func blobFileAD(fileID string) []byte {
+	return []byte(string(blobContentAD) + ":" + fileID)
+}
+
+// newFileID makes a random id of a new file.
+func newFileID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+type blobMetadataInput struct {
+	Metadata []byte `json:"metadata"`
+	Nonce    []byte `json:"nonce"`
+}
+
+func (c *GKClient) openBlob(blob domain.BlobData) (domain.BlobInfo, error) {
+	var meta blobMetadata
+	err := c.open(blobMetadataAD, domain.SealedData{ID: blob.ID, Data: blob.Metadata, Nonce: blob.Nonce}, &meta)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	return domain.BlobInfo{
+		ID:        blob.ID,
+		Name:      meta.Name,
+		Metadata:  meta.Metadata,
+		FileID:    meta.FileID,
+		Size:      blob.Size,
+		CreatedAt: blob.CreatedAt,
+	}, nil
+}
+
+func (c *GKClient) GetAllBlobs(ctx context.Context) ([]domain.BlobInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+BlobDataEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var blobs []domain.BlobData
+		if err := json.NewDecoder(response.Body).Decode(&blobs); err != nil {
+			return nil, err
+		}
+
+		result := make([]domain.BlobInfo, 0, len(blobs))
+		for _, blob := range blobs {
+			info, err := c.openBlob(blob)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, info)
+		}
+		return result, nil
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserNotFound
+	case http.StatusNoContent:
+		return nil, domain.ErrDataNotFound
+	case http.StatusInternalServerError:
+		return nil, domain.ErrInternalServerError
+	default:
+		return nil, errors.New(response.Status)
+	}
+}
+
+// UploadBlob seals content on the fly and streams it to the server, so files of any size
+// are uploaded with constant memory.
+func (c *GKClient) UploadBlob(ctx context.Context, name, metadata string, content io.Reader) (domain.BlobInfo, error) {
+	if c.sealer == nil {
+		return domain.BlobInfo{}, ErrVaultLocked
+	}
+
+	fileID, err := newFileID()
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	sealedMeta, err := c.seal(blobMetadataAD, 0, blobMetadata{Name: name, Metadata: metadata, FileID: fileID})
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(c.writeBlobForm(mw, sealedMeta, fileID, content))
+	}()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+BlobDataEndpoint, pr)
+	if err != nil {
+		pr.Close()
+		return domain.BlobInfo{}, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+	request.Header.Set("Content-Type", mw.FormDataContentType())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		var blob domain.BlobData
+		if err := json.NewDecoder(response.Body).Decode(&blob); err != nil {
+			return domain.BlobInfo{}, err
+		}
+		return c.openBlob(blob)
+	case http.StatusBadRequest:
+		return domain.BlobInfo{}, errors.New("bad request")
+	case http.StatusUnauthorized:
+		return domain.BlobInfo{}, domain.ErrUserNotFound
+	case http.StatusRequestEntityTooLarge:
+		return domain.BlobInfo{}, domain.ErrBlobTooLarge
+	case http.StatusInternalServerError:
+		return domain.BlobInfo{}, domain.ErrInternalServerError
+	default:
+		return domain.BlobInfo{}, errors.New(response.Status)
+	}
+}
+
+// writeBlobForm writes the parts in the order the server reads them: metadata, then the sealed file.
+func (c *GKClient) writeBlobForm(mw *multipart.Writer, sealedMeta domain.SealedData, fileID string, content io.Reader) error {
+	metaPart, err := mw.CreateFormField("metadata")
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(metaPart).Encode(blobMetadataInput{Metadata: sealedMeta.Data, Nonce: sealedMeta.Nonce})
+	if err != nil {
+		return err
+	}
+
+	filePart, err := mw.CreateFormFile("file", "sealed")
+	if err != nil {
+		return err
+	}
+
+	sw := seal.NewStreamWriter(c.sealer, filePart, blobFileAD(fileID))
+	if _, err := io.Copy(sw, content); err != nil {
+		return err
+	}
+	if err := sw.Close(); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+// DownloadBlob streams the file returned by GetAllBlobs into w opening it on the fly. If an error is returned,
+// w may already contain a part of the file.
+func (c *GKClient) DownloadBlob(ctx context.Context, blob domain.BlobInfo, w io.Writer) error {
+	if c.sealer == nil {
+		return ErrVaultLocked
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s/%d", c.addr, BlobDataEndpoint, blob.ID), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		_, err := io.Copy(w, seal.NewStreamReader(c.sealer, response.Body, blobFileAD(blob.FileID)))
+		if errors.Is(err, seal.ErrOpen) {
+			return domain.ErrDataCannotBeOpened
+		}
+		return err
+	case http.StatusUnauthorized:
+		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrDataNotFound
+	case http.StatusInternalServerError:
+		return domain.ErrInternalServerError
+	default:
+		return errors.New(response.Status)
+	}
+}
+
+// UpdateBlobInfo changes the name and notes of a file returned by GetAllBlobs, its content stays the same.
+func (c *GKClient) UpdateBlobInfo(ctx context.Context, info domain.BlobInfo) error {
+	sealedMeta, err := c.seal(blobMetadataAD, info.ID, blobMetadata{Name: info.Name, Metadata: info.Metadata, FileID: info.FileID})
+	if err != nil {
+		return err
+	}
+
+	metaJson, err := json.Marshal(blobMetadataInput{Metadata: sealedMeta.Data, Nonce: sealedMeta.Nonce})
+	if err != nil {
+		return err
+	}
+
+	return c.blobRequest(ctx, http.MethodPost, info.ID, metaJson)
+}
+
+func (c *GKClient) DeleteBlob(ctx context.Context, id int) error {
+	return c.blobRequest(ctx, http.MethodDelete, id, nil)
+}
+
+func (c *GKClient) blobRequest(ctx context.Context, method string, id int, body []byte) error {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s/%d", c.addr, BlobDataEndpoint, id), bodyReader)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest:
+		return errors.New("bad request")
+	case http.StatusUnauthorized:
+		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrDataNotFound
+	case http.StatusInternalServerError:
+		return domain.ErrInternalServerError
+	default:
+		return errors.New(response.Status)
+	}
+}
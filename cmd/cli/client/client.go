@@ -5,16 +5,29 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/seal"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	SignUpEndpoint  = "/api/user/auth/sign-up"
-	SignInEndpoint  = "/api/user/auth/sign-in"
-	RefreshEndpoint = "/api/user/auth/refresh"
+	SignUpEndpoint      = "/api/user/auth/sign-up"
+	SignInEndpoint      = "/api/user/auth/sign-in"
+	SignIn2FAEndpoint   = "/api/user/auth/sign-in/2fa"
+	RefreshEndpoint     = "/api/user/auth/refresh"
+	LogoutEndpoint      = "/api/user/auth/logout"
+	SessionsEndpoint    = "/api/user/sessions"
+	TOTPEnrollEndpoint  = "/api/user/2fa/totp/enroll"
+	TOTPConfirmEndpoint = "/api/user/2fa/totp/confirm"
+	RecoveryEndpoint    = "/api/user/2fa/recovery-codes"
 
 	TextDataEndpoint = "/api/materials/text"
 	CardDataEndpoint = "/api/materials/card"
@@ -26,14 +39,62 @@ type GKClient struct {
 	refreshPeriod time.Duration
 	client        *http.Client
 
-	tokens domain.Tokens
+	// tokens are refreshed in background by KeepTokensFresh, so they are accessed under tokensMu
+	tokensMu sync.RWMutex
+	tokens   domain.Tokens
+	sealer   seal.Sealer
+
+	// challenge of two-step sign in, it's exchanged for tokens by UserSignInWithCode
+	challengeToken string
+
+	// local state, which should survive restarts, e.g. interrupted uploads
+	stateDir string
+
+	// items synced from the server, see Sync
+	replica *replica
+	// conflicts of the changes made offline, see Conflicts
+	conflicts []*ConflictError
+}
+
+var (
+	ErrVaultLocked = errors.New("vault is locked, provide master password")
+	ErrOffline     = errors.New("server is unreachable")
+)
+
+// ConflictError is returned when the item was changed on the server since it was read.
+// To overwrite the server version, send the update again with Revision.
+type ConflictError struct {
+	Type     domain.MaterialType
+	Revision int
+	Current  interface{} // the server version: domain.TextData, domain.CardData or domain.CredData
+
+	// set for the changes made offline: the rejected version and the one it was based on
+	Mine interface{}
+	Base interface{}
+
+	sealed domain.SealedData
+}
+
+func (e *ConflictError) Error() string {
+	return domain.ErrRevisionConflict.Error()
+}
+
+func (e *ConflictError) Unwrap() error {
+	return domain.ErrRevisionConflict
 }
 
 func NewGKClient(addr string) *GKClient {
+	stateDir, err := os.UserConfigDir()
+	if err != nil {
+		stateDir = os.TempDir()
+	}
+
 	return &GKClient{
 		addr:          addr,
-		client:        &http.Client{},
+		client:        &http.Client{Transport: offlineTransport{base: http.DefaultTransport}},
 		refreshPeriod: 25 * time.Second,
+		stateDir:      filepath.Join(stateDir, "gophkeeper"),
+		replica:       newReplica(),
 	}
 }
 
@@ -41,17 +102,41 @@ func NewGKClient(addr string) *GKClient {
 // Auth
 //**********************************************************************************************************************
 type AuthInput struct {
-	Login    string `json:"login" binding:"required,max=64"`
-	Password string `json:"password" binding:"required,min=8,max=64"`
+	Login      string `json:"login" binding:"required,max=64"`
+	Password   string `json:"password" binding:"required,min=8,max=64"`
+	DeviceName string `json:"device_name"`
+}
+
+// deviceName is shown to the user in the list of sessions.
+func deviceName() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "gophkeeper cli"
+	}
+	return hostname
 }
 
+// badRequestError returns field errors, if the server has rejected the input.
+func badRequestError(body []byte) error {
+	var verr domain.ValidationError
+	if err := json.Unmarshal(body, &verr); err != nil || len(verr.Fields) == 0 {
+		return errors.New("bad request")
+	}
+	return &verr
+}
+
+// ErrSignInRequired is returned by UserSignUp, if the account is created but the session is not.
+var ErrSignInRequired = errors.New("the account is created, please sign in")
+
 func (c *GKClient) UserSignUp(ctx context.Context, input AuthInput) (domain.Tokens, error) {
 	var t domain.Tokens
+	if input.DeviceName == "" {
+		input.DeviceName = deviceName()
+	}
 	authJson, err := json.Marshal(input)
 	if err != nil {
 		return t, err
 	}
-	authJson = authJson
 	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+SignUpEndpoint, bytes.NewBufferString(string(authJson)))
 	if err != nil {
 		return t, err
@@ -73,10 +158,20 @@ func (c *GKClient) UserSignUp(ctx context.Context, input AuthInput) (domain.Toke
 		if err = json.Unmarshal(body, &t); err != nil {
 			return t, nil
 		}
-		c.tokens = t
+		c.setTokens(t)
 		return t, nil
+	case http.StatusCreated:
+		return t, ErrSignInRequired
+	case http.StatusAccepted:
+		if err = json.Unmarshal(body, &t); err != nil {
+			return t, err
+		}
+		c.challengeToken = t.ChallengeToken
+		return t, domain.ErrSecondFactorRequired
+	case http.StatusTooManyRequests:
+		return t, fmt.Errorf("%w, the account is created, sign in after %s seconds", domain.ErrTooManySignInAttempts, response.Header.Get("Retry-After"))
 	case http.StatusBadRequest:
-		return t, domain.ErrUserBadPassword
+		return t, badRequestError(body)
 	case http.StatusConflict:
 		return t, domain.ErrUserAlreadyExists
 	case http.StatusInternalServerError:
@@ -88,6 +183,9 @@ func (c *GKClient) UserSignUp(ctx context.Context, input AuthInput) (domain.Toke
 
 func (c *GKClient) UserSignIn(ctx context.Context, input AuthInput) (domain.Tokens, error) {
 	var t domain.Tokens
+	if input.DeviceName == "" {
+		input.DeviceName = deviceName()
+	}
 	authJson, err := json.Marshal(input)
 	if err != nil {
 		return t, err
@@ -114,12 +212,20 @@ func (c *GKClient) UserSignIn(ctx context.Context, input AuthInput) (domain.Toke
 		if err = json.Unmarshal(body, &t); err != nil {
 			return t, err
 		}
-		c.tokens = t
+		c.setTokens(t)
 		return t, nil
+	case http.StatusAccepted:
+		if err = json.Unmarshal(body, &t); err != nil {
+			return t, err
+		}
+		c.challengeToken = t.ChallengeToken
+		return t, domain.ErrSecondFactorRequired
+	case http.StatusTooManyRequests:
+		return t, fmt.Errorf("%w, retry after %s seconds", domain.ErrTooManySignInAttempts, response.Header.Get("Retry-After"))
 	case http.StatusBadRequest:
-		return t, errors.New("bad request")
+		return t, badRequestError(body)
 	case http.StatusUnauthorized:
-		return t, domain.ErrUserNotFound
+		return t, domain.ErrWrongLoginOrPassword
 	case http.StatusInternalServerError:
 		return t, domain.ErrInternalServerError
 	default:
@@ -127,18 +233,25 @@ func (c *GKClient) UserSignIn(ctx context.Context, input AuthInput) (domain.Toke
 	}
 }
 
-type refreshInput struct {
-	RefreshToken string `json:"refresh_token"`
+type signInCodeInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	DeviceName     string `json:"device_name"`
 }
 
-func (c *GKClient) UserRefresh(ctx context.Context, refreshToken string) (domain.Tokens, error) {
+// UserSignInWithCode completes sign in, if UserSignIn returned ErrSecondFactorRequired.
+func (c *GKClient) UserSignInWithCode(ctx context.Context, code string) (domain.Tokens, error) {
 	var t domain.Tokens
-	refreshJson, err := json.Marshal(refreshInput{refreshToken})
+	codeJson, err := json.Marshal(signInCodeInput{
+		ChallengeToken: c.challengeToken,
+		Code:           code,
+		DeviceName:     deviceName(),
+	})
 	if err != nil {
 		return t, err
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+RefreshEndpoint, bytes.NewBufferString(string(refreshJson)))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+SignIn2FAEndpoint, bytes.NewBuffer(codeJson))
 	if err != nil {
 		return t, err
 	}
@@ -159,12 +272,13 @@ func (c *GKClient) UserRefresh(ctx context.Context, refreshToken string) (domain
 		if err = json.Unmarshal(body, &t); err != nil {
 			return t, err
 		}
-		c.tokens = t
+		c.setTokens(t)
+		c.challengeToken = ""
 		return t, nil
 	case http.StatusBadRequest:
-		return t, errors.New("bad request")
+		return t, badRequestError(body)
 	case http.StatusUnauthorized:
-		return t, domain.ErrUserNotFound
+		return t, domain.ErrBadSecondFactorCode
 	case http.StatusInternalServerError:
 		return t, domain.ErrInternalServerError
 	default:
@@ -172,116 +286,215 @@ func (c *GKClient) UserRefresh(ctx context.Context, refreshToken string) (domain
 	}
 }
 
-func (c *GKClient) KeepTokensFresh(ctx context.Context) <-chan error {
-	errc := make(chan error)
-	go func() {
-		defer close(errc)
-		ticker := time.NewTicker(c.refreshPeriod)
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				tokens, err := c.UserRefresh(ctx, c.tokens.RefreshToken)
-				if err != nil {
-					errc <- err
-					return
-				}
-				c.tokens = tokens
-			}
+// EnrollTOTP starts TOTP enrolment. The returned URI should be added to an authenticator app
+// and confirmed with ConfirmTOTP.
+func (c *GKClient) EnrollTOTP(ctx context.Context) (domain.TOTPEnrollment, error) {
+	var e domain.TOTPEnrollment
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+TOTPEnrollEndpoint, nil)
+	if err != nil {
+		return e, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return e, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return e, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		if err = json.Unmarshal(body, &e); err != nil {
+			return e, err
 		}
-	}()
-	return errc
+		return e, nil
+	case http.StatusUnauthorized:
+		return e, domain.ErrUserNotFound
+	case http.StatusConflict:
+		return e, domain.ErrTOTPAlreadyEnabled
+	case http.StatusInternalServerError:
+		return e, domain.ErrInternalServerError
+	default:
+		return e, errors.New(response.Status)
+	}
 }
 
-//**********************************************************************************************************************
-// Text
-//**********************************************************************************************************************
-func (c *GKClient) GetAllTextData(ctx context.Context) ([]domain.TextData, error) {
-	endpoint := c.addr + TextDataEndpoint
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+type confirmTOTPInput struct {
+	Code string `json:"code"`
+}
+
+// ConfirmTOTP enables TOTP and returns the first set of recovery codes.
+func (c *GKClient) ConfirmTOTP(ctx context.Context, code string) (domain.RecoveryCodes, error) {
+	var rc domain.RecoveryCodes
+	codeJson, err := json.Marshal(confirmTOTPInput{Code: code})
 	if err != nil {
-		return nil, err
+		return rc, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+TOTPConfirmEndpoint, bytes.NewBuffer(codeJson))
+	if err != nil {
+		return rc, err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
+	request.Header.Add("Authorization", c.accessToken())
 
 	response, err := c.client.Do(request)
 	if err != nil {
-		return nil, err
+		return rc, err
 	}
 	defer response.Body.Close()
 
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return rc, err
+	}
+
 	switch response.StatusCode {
 	case http.StatusOK:
-		body, err := io.ReadAll(response.Body)
-		if err != nil {
-			return nil, err
+		if err = json.Unmarshal(body, &rc); err != nil {
+			return rc, err
+		}
+		return rc, nil
+	case http.StatusBadRequest:
+		return rc, domain.ErrBadSecondFactorCode
+	case http.StatusUnauthorized:
+		return rc, domain.ErrUserNotFound
+	case http.StatusConflict:
+		return rc, errors.New("totp enrolment wasn't started or totp is already enabled")
+	case http.StatusInternalServerError:
+		return rc, domain.ErrInternalServerError
+	default:
+		return rc, errors.New(response.Status)
+	}
+}
+
+// RegenerateRecoveryCodes replaces recovery codes, the old ones stop working.
+func (c *GKClient) RegenerateRecoveryCodes(ctx context.Context) (domain.RecoveryCodes, error) {
+	var rc domain.RecoveryCodes
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+RecoveryEndpoint, nil)
+	if err != nil {
+		return rc, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return rc, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return rc, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		if err = json.Unmarshal(body, &rc); err != nil {
+			return rc, err
 		}
+		return rc, nil
+	case http.StatusUnauthorized:
+		return rc, domain.ErrUserNotFound
+	case http.StatusConflict:
+		return rc, domain.ErrTOTPNotEnabled
+	case http.StatusInternalServerError:
+		return rc, domain.ErrInternalServerError
+	default:
+		return rc, errors.New(response.Status)
+	}
+}
 
-		var result []domain.TextData
-		if err = json.Unmarshal(body, &result); err != nil {
-			return nil, err
+func (c *GKClient) GetRecoveryCodesStatus(ctx context.Context) (domain.RecoveryCodesStatus, error) {
+	var st domain.RecoveryCodesStatus
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+RecoveryEndpoint, nil)
+	if err != nil {
+		return st, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return st, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return st, err
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		if err = json.Unmarshal(body, &st); err != nil {
+			return st, err
 		}
-		return result, nil
+		return st, nil
 	case http.StatusUnauthorized:
-		return nil, domain.ErrUserNotFound
-	case http.StatusNoContent:
-		return nil, domain.ErrDataNotFound
+		return st, domain.ErrUserNotFound
 	case http.StatusInternalServerError:
-		return nil, domain.ErrInternalServerError
+		return st, domain.ErrInternalServerError
 	default:
-		return nil, errors.New(response.Status)
+		return st, errors.New(response.Status)
 	}
 }
 
-type textDataInput struct {
-	Text     string `json:"text"`
-	Metadata string `json:"metadata"`
+type refreshInput struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
-func (c *GKClient) CreateNewTextData(ctx context.Context, data domain.TextData) error {
-	textDataJson, err := json.Marshal(textDataInput{Text: data.Text, Metadata: data.Text})
+func (c *GKClient) UserRefresh(ctx context.Context, refreshToken string) (domain.Tokens, error) {
+	var t domain.Tokens
+	refreshJson, err := json.Marshal(refreshInput{refreshToken})
 	if err != nil {
-		return err
+		return t, err
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+TextDataEndpoint, bytes.NewBufferString(string(textDataJson)))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+RefreshEndpoint, bytes.NewBufferString(string(refreshJson)))
 	if err != nil {
-		return err
+		return t, err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
 
 	response, err := c.client.Do(request)
 	if err != nil {
-		return err
+		return t, err
 	}
 	defer response.Body.Close()
 
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return t, err
+	}
+
 	switch response.StatusCode {
 	case http.StatusOK:
-		return nil
+		if err = json.Unmarshal(body, &t); err != nil {
+			return t, err
+		}
+		c.setTokens(t)
+		return t, nil
 	case http.StatusBadRequest:
-		return errors.New("bad request")
+		return t, errors.New("bad request")
 	case http.StatusUnauthorized:
-		return domain.ErrUserNotFound
+		return t, domain.ErrUserNotFound
 	case http.StatusInternalServerError:
-		return domain.ErrInternalServerError
+		return t, domain.ErrInternalServerError
 	default:
-		return errors.New(response.Status)
+		return t, errors.New(response.Status)
 	}
 }
 
-func (c *GKClient) UpdateTextData(ctx context.Context, data domain.TextData) error {
-	textDataJson, err := json.Marshal(data)
+// UserLogout ends the session on the server and forgets tokens and the vault key.
+func (c *GKClient) UserLogout(ctx context.Context) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+LogoutEndpoint, nil)
 	if err != nil {
 		return err
 	}
-
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+TextDataEndpoint, bytes.NewBufferString(string(textDataJson)))
-	if err != nil {
-		return err
-	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
+	request.Header.Add("Authorization", c.accessToken())
 
 	response, err := c.client.Do(request)
 	if err != nil {
@@ -289,11 +502,12 @@ func (c *GKClient) UpdateTextData(ctx context.Context, data domain.TextData) err
 	}
 	defer response.Body.Close()
 
+	c.setTokens(domain.Tokens{})
+	c.sealer = nil
+
 	switch response.StatusCode {
 	case http.StatusOK:
 		return nil
-	case http.StatusBadRequest:
-		return errors.New("bad request")
 	case http.StatusUnauthorized:
 		return domain.ErrUserNotFound
 	case http.StatusInternalServerError:
@@ -303,16 +517,12 @@ func (c *GKClient) UpdateTextData(ctx context.Context, data domain.TextData) err
 	}
 }
 
-//**********************************************************************************************************************
-// Credit card
-//**********************************************************************************************************************
-func (c *GKClient) GetAllCardData(ctx context.Context) ([]domain.CardData, error) {
-	endpoint := c.addr + CardDataEndpoint
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+func (c *GKClient) GetSessions(ctx context.Context) ([]domain.SessionInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+SessionsEndpoint, nil)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
+	request.Header.Add("Authorization", c.accessToken())
 
 	response, err := c.client.Do(request)
 	if err != nil {
@@ -327,15 +537,13 @@ func (c *GKClient) GetAllCardData(ctx context.Context) ([]domain.CardData, error
 			return nil, err
 		}
 
-		var result []domain.CardData
+		var result []domain.SessionInfo
 		if err = json.Unmarshal(body, &result); err != nil {
 			return nil, err
 		}
 		return result, nil
 	case http.StatusUnauthorized:
 		return nil, domain.ErrUserNotFound
-	case http.StatusNoContent:
-		return nil, domain.ErrDataNotFound
 	case http.StatusInternalServerError:
 		return nil, domain.ErrInternalServerError
 	default:
@@ -343,33 +551,12 @@ func (c *GKClient) GetAllCardData(ctx context.Context) ([]domain.CardData, error
 	}
 }
 
-type newCardDataInput struct {
-	CardNumber string    `json:"card_number"`
-	ExpDate    time.Time `json:"exp_data"`
-	CVV        string    `json:"cvv"`
-	Name       string    `json:"name"`
-	Surname    string    `json:"surname"`
-	Metadata   string    `json:"metadata"`
-}
-
-func (c *GKClient) CreateNewCardData(ctx context.Context, data domain.CardData) error {
-	cardDataJson, err := json.Marshal(newCardDataInput{
-		CardNumber: data.CardNumber,
-		ExpDate:    data.ExpDate,
-		CVV:        data.CVV,
-		Name:       data.Name,
-		Surname:    data.Surname,
-		Metadata:   data.Metadata,
-	})
-	if err != nil {
-		return err
-	}
-
-	request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+CardDataEndpoint, bytes.NewBufferString(string(cardDataJson)))
+func (c *GKClient) TerminateSession(ctx context.Context, sessionID string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.addr+SessionsEndpoint+"/"+sessionID, nil)
 	if err != nil {
 		return err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
+	request.Header.Add("Authorization", c.accessToken())
 
 	response, err := c.client.Do(request)
 	if err != nil {
@@ -380,10 +567,10 @@ func (c *GKClient) CreateNewCardData(ctx context.Context, data domain.CardData)
 	switch response.StatusCode {
 	case http.StatusOK:
 		return nil
-	case http.StatusBadRequest:
-		return errors.New("bad request")
 	case http.StatusUnauthorized:
 		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrSessionNotFound
 	case http.StatusInternalServerError:
 		return domain.ErrInternalServerError
 	default:
@@ -391,48 +578,190 @@ func (c *GKClient) CreateNewCardData(ctx context.Context, data domain.CardData)
 	}
 }
 
-func (c *GKClient) UpdateCardData(ctx context.Context, data domain.CardData) error {
-	cardDataJson, err := json.Marshal(data)
+func (c *GKClient) accessToken() string {
+	c.tokensMu.RLock()
+	defer c.tokensMu.RUnlock()
+	return c.tokens.AccessToken
+}
+
+func (c *GKClient) refreshToken() string {
+	c.tokensMu.RLock()
+	defer c.tokensMu.RUnlock()
+	return c.tokens.RefreshToken
+}
+
+func (c *GKClient) setTokens(tokens domain.Tokens) {
+	c.tokensMu.Lock()
+	defer c.tokensMu.Unlock()
+	c.tokens = tokens
+}
+
+func (c *GKClient) KeepTokensFresh(ctx context.Context) <-chan error {
+	errc := make(chan error)
+	go func() {
+		defer close(errc)
+		ticker := time.NewTicker(c.refreshPeriod)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				tokens, err := c.UserRefresh(ctx, c.refreshToken())
+				if errors.Is(err, ErrOffline) {
+					// the server may be back before the refresh token expires
+					continue
+				}
+				if err != nil {
+					errc <- err
+					return
+				}
+				c.setTokens(tokens)
+			}
+		}
+	}()
+	return errc
+}
+
+//**********************************************************************************************************************
+// Sealing
+//**********************************************************************************************************************
+
+// Unlock derives the vault key from the master password. The master password and the key never leave the client,
+// the server gets only sealed payloads. ErrWrongMasterPassword is returned, if the key doesn't open the key check
+// value or the local copy of the vault.
+func (c *GKClient) Unlock(ctx context.Context, login, masterPassword string) error {
+	sealer, err := seal.NewAESGCMSealer(seal.DeriveKey(masterPassword, login))
 	if err != nil {
 		return err
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+CardDataEndpoint, bytes.NewBufferString(string(cardDataJson)))
-	if err != nil {
+	// the replica is filled by sync, if the local copy is missing or can't be read
+	r, err := openReplica(c.replicaPath(login), sealer)
+	if errors.Is(err, domain.ErrDataCannotBeOpened) {
+		return ErrWrongMasterPassword
+	}
+
+	if err := c.checkKey(ctx, sealer); err != nil {
 		return err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
 
-	response, err := c.client.Do(request)
+	c.sealer, c.replica = sealer, r
+	c.conflicts = nil
+	return nil
+}
+
+// additionalData binds the payload to the item, so it can't be moved to another table or to another item.
+// File metadata is sealed before the file gets its id, so it's bound to the type only.
+func additionalData(t domain.MaterialType, id int) []byte {
+	if t == blobMetadataAD {
+		return []byte(t)
+	}
+	return []byte(fmt.Sprintf("%s/%d", t, id))
+}
+
+// seal encrypts material v of the item with the id, see additionalData.
+func (c *GKClient) seal(t domain.MaterialType, id int, v interface{}) (domain.SealedData, error) {
+	if c.sealer == nil {
+		return domain.SealedData{}, ErrVaultLocked
+	}
+
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return domain.SealedData{}, err
+	}
+
+	data, nonce, err := c.sealer.Seal(plaintext, additionalData(t, id))
+	if err != nil {
+		return domain.SealedData{}, err
+	}
+
+	return domain.SealedData{ID: id, Data: data, Nonce: nonce}, nil
+}
+
+// open decrypts sealed material into v. The payload opens only with the id it was sealed with,
+// the id inside the payload is meaningless, callers use the server one.
+func (c *GKClient) open(t domain.MaterialType, sealed domain.SealedData, v interface{}) error {
+	if c.sealer == nil {
+		return ErrVaultLocked
+	}
+
+	plaintext, err := c.sealer.Open(sealed.Data, sealed.Nonce, additionalData(t, sealed.ID))
+	if err != nil {
+		return domain.ErrDataCannotBeOpened
+	}
+
+	return json.Unmarshal(plaintext, v)
+}
+
+// openData opens sealed text, card or cred data with the server id and revision.
+func (c *GKClient) openData(t domain.MaterialType, sealed domain.SealedData) (interface{}, error) {
+	switch t {
+	case domain.MaterialText:
+		var data domain.TextData
+		err := c.open(t, sealed, &data)
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		return data, err
+	case domain.MaterialCard:
+		var data domain.CardData
+		err := c.open(t, sealed, &data)
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		return data, err
+	case domain.MaterialCred:
+		var data domain.CredData
+		err := c.open(t, sealed, &data)
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		return data, err
+	default:
+		return nil, domain.ErrUnknownMaterialType
+	}
+}
+
+// openConflict opens the server version of the conflicting item.
+func (c *GKClient) openConflict(t domain.MaterialType, conflict *ConflictError) error {
+	current, err := c.openData(t, conflict.sealed)
 	if err != nil {
 		return err
 	}
-	defer response.Body.Close()
+	conflict.Type, conflict.Current = t, current
+	return nil
+}
 
-	switch response.StatusCode {
-	case http.StatusOK:
-		return nil
-	case http.StatusBadRequest:
-		return errors.New("bad request")
-	case http.StatusUnauthorized:
-		return domain.ErrUserNotFound
-	case http.StatusInternalServerError:
-		return domain.ErrInternalServerError
+// describe opens the item and makes a short title of it: a text, a login with the password,
+// a file name or the last digits of a card number.
+func (c *GKClient) describe(t domain.MaterialType, sealed domain.SealedData) (string, error) {
+	switch t {
+	case domain.MaterialText:
+		var data domain.TextData
+		err := c.open(t, sealed, &data)
+		return data.Text, err
+	case domain.MaterialCard:
+		var data domain.CardData
+		if err := c.open(t, sealed, &data); err != nil {
+			return "", err
+		}
+		if len(data.CardNumber) > 4 {
+			return "*" + data.CardNumber[len(data.CardNumber)-4:], nil
+		}
+		return data.CardNumber, nil
+	case domain.MaterialCred:
+		var data domain.CredData
+		err := c.open(t, sealed, &data)
+		return data.Login + " / " + data.Password, err
+	case domain.MaterialBlob:
+		var meta blobMetadata
+		err := c.open(blobMetadataAD, sealed, &meta)
+		return meta.Name, err
 	default:
-		return errors.New(response.Status)
+		return "", domain.ErrUnknownMaterialType
 	}
 }
 
-//**********************************************************************************************************************
-// Creds
-//**********************************************************************************************************************
-func (c *GKClient) GetAllCredsData(ctx context.Context) ([]domain.CredData, error) {
-	endpoint := c.addr + CredDataEndpoint
-	request, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+func (c *GKClient) getAllSealedData(ctx context.Context, endpoint string) ([]domain.SealedData, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
+	request.Header.Add("Authorization", c.accessToken())
 
 	response, err := c.client.Do(request)
 	if err != nil {
@@ -447,7 +776,7 @@ func (c *GKClient) GetAllCredsData(ctx context.Context) ([]domain.CredData, erro
 			return nil, err
 		}
 
-		var result []domain.CredData
+		var result []domain.SealedData
 		if err = json.Unmarshal(body, &result); err != nil {
 			return nil, err
 		}
@@ -463,59 +792,156 @@ func (c *GKClient) GetAllCredsData(ctx context.Context) ([]domain.CredData, erro
 	}
 }
 
-type newCredDataInput struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	Metadata string `json:"metadata"`
+func (c *GKClient) getSealedByID(ctx context.Context, endpoint string, id int) (domain.SealedData, error) {
+	var sealed domain.SealedData
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s/%d", c.addr, endpoint, id), nil)
+	if err != nil {
+		return sealed, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return sealed, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(response.Body).Decode(&sealed)
+		return sealed, err
+	case http.StatusUnauthorized:
+		return sealed, domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return sealed, domain.ErrDataNotFound
+	case http.StatusInternalServerError:
+		return sealed, domain.ErrInternalServerError
+	default:
+		return sealed, errors.New(response.Status)
+	}
 }
 
-func (c *GKClient) CreateNewCredData(ctx context.Context, data domain.CredData) error {
-	credDataJson, err := json.Marshal(newCredDataInput{
-		Login:    data.Login,
-		Password: data.Password,
-		Metadata: data.Metadata,
-	})
+type newSealedDataInput struct {
+	ID    int    `json:"id"`
+	Data  []byte `json:"data"`
+	Nonce []byte `json:"nonce"`
+}
+
+// sendSealedData creates (PUT) or updates (POST) sealed material. A create is done once for the idempotency key,
+// if it's set, so it may be retried. The created item is returned as stored by the server.
+func (c *GKClient) sendSealedData(ctx context.Context, method, endpoint string, sealed domain.SealedData, idempotencyKey string) (domain.SealedData, error) {
+	var (
+		created    domain.SealedData
+		sealedJson []byte
+		err        error
+	)
+	if method == http.MethodPut {
+		sealedJson, err = json.Marshal(newSealedDataInput{ID: sealed.ID, Data: sealed.Data, Nonce: sealed.Nonce})
+	} else {
+		sealedJson, err = json.Marshal(sealed)
+	}
 	if err != nil {
-		return err
+		return created, err
 	}
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+CredDataEndpoint, bytes.NewBufferString(string(credDataJson)))
+	request, err := http.NewRequestWithContext(ctx, method, c.addr+endpoint, bytes.NewBuffer(sealedJson))
 	if err != nil {
-		return err
+		return created, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+	if method == http.MethodPost {
+		// the update is applied only if nobody has changed the item since it was read
+		request.Header.Set("If-Match", fmt.Sprintf(`"%d"`, sealed.Revision))
+	}
+	if idempotencyKey != "" {
+		request.Header.Set("Idempotency-Key", idempotencyKey)
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
 
 	response, err := c.client.Do(request)
 	if err != nil {
-		return err
+		return created, err
 	}
 	defer response.Body.Close()
 
 	switch response.StatusCode {
 	case http.StatusOK:
-		return nil
+		// the update returns the new revision only
+		created = sealed
+		created.Revision, _ = strconv.Atoi(strings.Trim(response.Header.Get("ETag"), `"`))
+		return created, nil
+	case http.StatusCreated:
+		err = json.NewDecoder(response.Body).Decode(&created)
+		return created, err
+	case http.StatusConflict:
+		if method == http.MethodPut {
+			return created, domain.ErrIDNotReserved
+		}
+		conflict := &ConflictError{}
+		if err := json.NewDecoder(response.Body).Decode(&conflict.sealed); err != nil {
+			return created, err
+		}
+		conflict.Revision = conflict.sealed.Revision
+		return created, conflict
+	case http.StatusNotFound:
+		return created, domain.ErrDataNotFound
 	case http.StatusBadRequest:
-		return errors.New("bad request")
+		return created, errors.New("bad request")
 	case http.StatusUnauthorized:
-		return domain.ErrUserNotFound
+		return created, domain.ErrUserNotFound
+	case http.StatusUnprocessableEntity:
+		return created, domain.ErrIdempotencyKeyReused
+	case http.StatusGone:
+		return created, domain.ErrCreatedItemPurged
 	case http.StatusInternalServerError:
-		return domain.ErrInternalServerError
+		return created, domain.ErrInternalServerError
 	default:
-		return errors.New(response.Status)
+		return created, errors.New(response.Status)
 	}
 }
 
-func (c *GKClient) UpdateCredData(ctx context.Context, data domain.CredData) error {
-	credDataJson, err := json.Marshal(data)
+// reserveID takes an id for a new item from the server, the item is sealed with it.
+func (c *GKClient) reserveID(ctx context.Context, t domain.MaterialType) (int, error) {
+	endpoint, ok := materialEndpoints[t]
+	if !ok {
+		return 0, domain.ErrUnknownMaterialType
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+endpoint+"/ids", nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return 0, err
 	}
+	defer response.Body.Close()
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+CredDataEndpoint, bytes.NewBufferString(string(credDataJson)))
+	switch response.StatusCode {
+	case http.StatusCreated:
+		var reserved struct {
+			ID int `json:"id"`
+		}
+		err = json.NewDecoder(response.Body).Decode(&reserved)
+		return reserved.ID, err
+	case http.StatusUnauthorized:
+		return 0, domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return 0, domain.ErrUnknownMaterialType
+	case http.StatusInternalServerError:
+		return 0, domain.ErrInternalServerError
+	default:
+		return 0, errors.New(response.Status)
+	}
+}
+
+func (c *GKClient) deleteData(ctx context.Context, endpoint string, id int) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s%s/%d", c.addr, endpoint, id), nil)
 	if err != nil {
 		return err
 	}
-	request.Header.Add("Authorization", c.tokens.AccessToken)
+	request.Header.Add("Authorization", c.accessToken())
 
 	response, err := c.client.Do(request)
 	if err != nil {
@@ -526,13 +952,166 @@ func (c *GKClient) UpdateCredData(ctx context.Context, data domain.CredData) err
 	switch response.StatusCode {
 	case http.StatusOK:
 		return nil
-	case http.StatusBadRequest:
-		return errors.New("bad request")
 	case http.StatusUnauthorized:
 		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrDataNotFound
 	case http.StatusInternalServerError:
 		return domain.ErrInternalServerError
 	default:
 		return errors.New(response.Status)
 	}
 }
+
+//**********************************************************************************************************************
+// Text
+//**********************************************************************************************************************
+func (c *GKClient) GetAllTextData(ctx context.Context) ([]domain.TextData, error) {
+	sealedArray, err := c.getAllSealedData(ctx, TextDataEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.TextData, 0, len(sealedArray))
+	for _, sealed := range sealedArray {
+		var data domain.TextData
+		if err = c.open(domain.MaterialText, sealed, &data); err != nil {
+			return nil, err
+		}
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// CreateNewTextData returns the item as stored, its id is negative if the item is kept offline.
+func (c *GKClient) CreateNewTextData(ctx context.Context, data domain.TextData) (domain.TextData, error) {
+	sealed, err := c.create(ctx, domain.MaterialText, data)
+	data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+	return data, err
+}
+
+// UpdateTextData applies the update to data.Revision, ConflictError is returned if the item was changed since then.
+func (c *GKClient) UpdateTextData(ctx context.Context, data domain.TextData) error {
+	sealed, err := c.seal(domain.MaterialText, data.ID, data)
+	if err != nil {
+		return err
+	}
+	sealed.Revision = data.Revision
+
+	_, err = c.send(ctx, outboxUpdate, domain.MaterialText, sealed)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		if err := c.openConflict(domain.MaterialText, conflict); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *GKClient) DeleteTextData(ctx context.Context, id int) error {
+	_, err := c.send(ctx, outboxDelete, domain.MaterialText, domain.SealedData{ID: id})
+	return err
+}
+
+//**********************************************************************************************************************
+// Credit card
+//**********************************************************************************************************************
+func (c *GKClient) GetAllCardData(ctx context.Context) ([]domain.CardData, error) {
+	sealedArray, err := c.getAllSealedData(ctx, CardDataEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.CardData, 0, len(sealedArray))
+	for _, sealed := range sealedArray {
+		var data domain.CardData
+		if err = c.open(domain.MaterialCard, sealed, &data); err != nil {
+			return nil, err
+		}
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// CreateNewCardData returns the item as stored, its id is negative if the item is kept offline.
+func (c *GKClient) CreateNewCardData(ctx context.Context, data domain.CardData) (domain.CardData, error) {
+	sealed, err := c.create(ctx, domain.MaterialCard, data)
+	data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+	return data, err
+}
+
+// UpdateCardData applies the update to data.Revision, ConflictError is returned if the item was changed since then.
+func (c *GKClient) UpdateCardData(ctx context.Context, data domain.CardData) error {
+	sealed, err := c.seal(domain.MaterialCard, data.ID, data)
+	if err != nil {
+		return err
+	}
+	sealed.Revision = data.Revision
+
+	_, err = c.send(ctx, outboxUpdate, domain.MaterialCard, sealed)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		if err := c.openConflict(domain.MaterialCard, conflict); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *GKClient) DeleteCardData(ctx context.Context, id int) error {
+	_, err := c.send(ctx, outboxDelete, domain.MaterialCard, domain.SealedData{ID: id})
+	return err
+}
+
+//**********************************************************************************************************************
+// Creds
+//**********************************************************************************************************************
+func (c *GKClient) GetAllCredsData(ctx context.Context) ([]domain.CredData, error) {
+	sealedArray, err := c.getAllSealedData(ctx, CredDataEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]domain.CredData, 0, len(sealedArray))
+	for _, sealed := range sealedArray {
+		var data domain.CredData
+		if err = c.open(domain.MaterialCred, sealed, &data); err != nil {
+			return nil, err
+		}
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// CreateNewCredData returns the item as stored, its id is negative if the item is kept offline.
+func (c *GKClient) CreateNewCredData(ctx context.Context, data domain.CredData) (domain.CredData, error) {
+	sealed, err := c.create(ctx, domain.MaterialCred, data)
+	data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+	return data, err
+}
+
+// UpdateCredData applies the update to data.Revision, ConflictError is returned if the item was changed since then.
+func (c *GKClient) UpdateCredData(ctx context.Context, data domain.CredData) error {
+	sealed, err := c.seal(domain.MaterialCred, data.ID, data)
+	if err != nil {
+		return err
+	}
+	sealed.Revision = data.Revision
+
+	_, err = c.send(ctx, outboxUpdate, domain.MaterialCred, sealed)
+	var conflict *ConflictError
+	if errors.As(err, &conflict) {
+		if err := c.openConflict(domain.MaterialCred, conflict); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *GKClient) DeleteCredData(ctx context.Context, id int) error {
+	_, err := c.send(ctx, outboxDelete, domain.MaterialCred, domain.SealedData{ID: id})
+	return err
+}
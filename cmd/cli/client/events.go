@@ -0,0 +1,104 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"gophkeeper/internal/domain"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const EventsEndpoint = "/api/events"
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// SubscribeEvents streams the changes of the user's items made on any device. The stream is reconnected,
+// when it breaks or the access token expires, every connection starts with domain.EventReady.
+// The channel is closed, when ctx is done.
+func (c *GKClient) SubscribeEvents(ctx context.Context) <-chan domain.MaterialEvent {
+	events := make(chan domain.MaterialEvent)
+
+	go func() {
+		defer close(events)
+
+		delay := minReconnectDelay
+		for {
+			connected, _ := c.readEvents(ctx, events)
+			if connected {
+				delay = minReconnectDelay
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			if !connected && delay < maxReconnectDelay {
+				delay *= 2
+			}
+		}
+	}()
+
+	return events
+}
+
+// readEvents reads the stream until it ends, it reports whether the connection was established.
+func (c *GKClient) readEvents(ctx context.Context, events chan<- domain.MaterialEvent) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+EventsEndpoint, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+	request.Header.Set("Accept", "text/event-stream")
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return false, domain.ErrUserNotFound
+	default:
+		return false, errors.New(response.Status)
+	}
+
+	reader := bufio.NewReader(response.Body)
+	var data strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return true, nil
+			}
+			return true, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, "data:"):
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		case line == "" && data.Len() > 0:
+			var event domain.MaterialEvent
+			if err := json.Unmarshal([]byte(data.String()), &event); err == nil {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return true, ctx.Err()
+				}
+			}
+			data.Reset()
+		}
+		// the event name repeats the kind from data, comments are heartbeats
+	}
+}
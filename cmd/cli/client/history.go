@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"net/http"
+)
+
+func historyEndpoint(t domain.MaterialType, id int) string {
+	return fmt.Sprintf("/api/materials/%s/%d/history", t, id)
+}
+
+// GetHistory returns previous values of the item, recent ones go first.
+func (c *GKClient) GetHistory(ctx context.Context, t domain.MaterialType, id int) ([]domain.VersionInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+historyEndpoint(t, id), nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var versions []domain.Version
+		if err := json.NewDecoder(response.Body).Decode(&versions); err != nil {
+			return nil, err
+		}
+
+		result := make([]domain.VersionInfo, 0, len(versions))
+		for _, version := range versions {
+			title, err := c.describe(t, domain.SealedData{ID: id, Data: version.Data, Nonce: version.Nonce})
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, domain.VersionInfo{
+				ID:         version.ID,
+				Title:      title,
+				ReplacedAt: version.ReplacedAt,
+			})
+		}
+		return result, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return nil, domain.ErrDataNotFound
+	case http.StatusInternalServerError:
+		return nil, domain.ErrInternalServerError
+	default:
+		return nil, errors.New(response.Status)
+	}
+}
+
+// RestoreVersion makes the version current, the replaced value is kept in the history.
+func (c *GKClient) RestoreVersion(ctx context.Context, t domain.MaterialType, id int, versionID int) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s%s/%d/restore", c.addr, historyEndpoint(t, id), versionID), nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusUnauthorized:
+		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrVersionNotFound
+	case http.StatusInternalServerError:
+		return domain.ErrInternalServerError
+	default:
+		return errors.New(response.Status)
+	}
+}
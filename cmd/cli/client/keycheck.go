@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/seal"
+	"net/http"
+)
+
+const KeyCheckEndpoint = "/api/user/key-check"
+
+// the key check value is the known text sealed with the vault key, it opens only with the right master password
+const (
+	keyCheckAD        = "key-check"
+	keyCheckPlaintext = "gophkeeper vault key"
+)
+
+var ErrWrongMasterPassword = errors.New("wrong master password")
+
+// checkKey opens the key check value saved on the server. The value is saved by the first client unlocking the vault.
+func (c *GKClient) checkKey(ctx context.Context, sealer seal.Sealer) error {
+	keyCheck, found, err := c.getKeyCheck(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		data, nonce, err := sealer.Seal([]byte(keyCheckPlaintext), []byte(keyCheckAD))
+		if err != nil {
+			return err
+		}
+		err = c.setKeyCheck(ctx, domain.SealedData{Data: data, Nonce: nonce})
+		if !errors.Is(err, domain.ErrKeyCheckAlreadyExists) {
+			return err
+		}
+
+		// another client has saved it first
+		if keyCheck, _, err = c.getKeyCheck(ctx); err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := sealer.Open(keyCheck.Data, keyCheck.Nonce, []byte(keyCheckAD))
+	if err != nil || string(plaintext) != keyCheckPlaintext {
+		return ErrWrongMasterPassword
+	}
+	return nil
+}
+
+func (c *GKClient) getKeyCheck(ctx context.Context) (domain.SealedData, bool, error) {
+	var keyCheck domain.SealedData
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+KeyCheckEndpoint, nil)
+	if err != nil {
+		return keyCheck, false, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return keyCheck, false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(response.Body).Decode(&keyCheck)
+		return keyCheck, err == nil, err
+	case http.StatusNoContent:
+		return keyCheck, false, nil
+	case http.StatusUnauthorized:
+		return keyCheck, false, domain.ErrUserNotFound
+	case http.StatusInternalServerError:
+		return keyCheck, false, domain.ErrInternalServerError
+	default:
+		return keyCheck, false, errors.New(response.Status)
+	}
+}
+
+func (c *GKClient) setKeyCheck(ctx context.Context, keyCheck domain.SealedData) error {
+	body, err := json.Marshal(newSealedDataInput{Data: keyCheck.Data, Nonce: keyCheck.Nonce})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+KeyCheckEndpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return domain.ErrKeyCheckAlreadyExists
+	case http.StatusUnauthorized:
+		return domain.ErrUserNotFound
+	case http.StatusInternalServerError:
+		return domain.ErrInternalServerError
+	default:
+		return errors.New(response.Status)
+	}
+}
@@ -0,0 +1,471 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/seal"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// a create is retried at once a few times, before it's queued
+const (
+	createRetries    = 2
+	createRetryDelay = 300 * time.Millisecond
+)
+
+var materialEndpoints = map[domain.MaterialType]string{
+	domain.MaterialText: TextDataEndpoint,
+	domain.MaterialCard: CardDataEndpoint,
+	domain.MaterialCred: CredDataEndpoint,
+}
+
+// offlineTransport marks errors of requests, which haven't reached the server, with ErrOffline.
+type offlineTransport struct {
+	base http.RoundTripper
+}
+
+func (t offlineTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(request)
+	if err != nil && request.Context().Err() == nil {
+		return nil, fmt.Errorf("%w: %v", ErrOffline, err)
+	}
+	return response, err
+}
+
+type outboxOp string
+
+const (
+	outboxCreate outboxOp = "create"
+	outboxUpdate outboxOp = "update"
+	outboxDelete outboxOp = "delete"
+)
+
+// outboxEntry is a change made offline. An update keeps the version it's based on to send it as
+// the expected revision and to let the user merge the changes in case of a conflict.
+type outboxEntry struct {
+	Op     outboxOp            `json:"op"`
+	Type   domain.MaterialType `json:"type"`
+	Sealed domain.SealedData   `json:"sealed"` // id is negative for items created offline, see newLocalID
+	Base   domain.SealedData   `json:"base"`
+	Key    string              `json:"key"` // idempotency key of a create, the same one is sent on every retry
+}
+
+// queue applies the change to the replica and keeps it until it's sent by replay.
+// Changes of items created offline are merged into their creation. The item is returned as it's kept.
+func (r *replica) queue(op outboxOp, t domain.MaterialType, sealed domain.SealedData, key string) (domain.SealedData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.items[t] == nil {
+		r.items[t] = make(map[int]domain.SealedData)
+	}
+
+	// the index of the queued creation or update of the item
+	queued := -1
+	for i, entry := range r.outbox {
+		if entry.Type == t && entry.Sealed.ID == sealed.ID && entry.Op != outboxDelete {
+			queued = i
+		}
+	}
+
+	switch op {
+	case outboxCreate:
+		sealed.Revision = 0
+		r.items[t][sealed.ID] = sealed
+		r.outbox = append(r.outbox, outboxEntry{Op: op, Type: t, Sealed: sealed, Key: key})
+	case outboxUpdate:
+		base, ok := r.items[t][sealed.ID]
+		if !ok {
+			return sealed, domain.ErrDataNotFound
+		}
+		sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt = base.Revision, base.CreatedAt, base.UpdatedAt
+		r.items[t][sealed.ID] = sealed
+
+		if queued >= 0 {
+			r.outbox[queued].Sealed = sealed
+		} else {
+			r.outbox = append(r.outbox, outboxEntry{Op: op, Type: t, Sealed: sealed, Base: base})
+		}
+	case outboxDelete:
+		if _, ok := r.items[t][sealed.ID]; !ok {
+			return sealed, domain.ErrDataNotFound
+		}
+		delete(r.items[t], sealed.ID)
+
+		created := queued >= 0 && r.outbox[queued].Op == outboxCreate
+		if queued >= 0 {
+			r.outbox = append(r.outbox[:queued], r.outbox[queued+1:]...)
+		}
+		// the server hasn't seen the item yet
+		if !created {
+			r.outbox = append(r.outbox, outboxEntry{Op: op, Type: t, Sealed: sealed})
+		}
+	}
+
+	r.touched = true
+	return sealed, r.save()
+}
+
+// newLocalID gives an id to an item created offline. It's replaced with the reserved one by replay.
+func (r *replica) newLocalID() (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.localID--
+	return r.localID, r.save()
+}
+
+// move replaces the oldest queued change with the same change of the item with another id.
+func (r *replica) move(entry, moved outboxEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.items[moved.Type] == nil {
+		r.items[moved.Type] = make(map[int]domain.SealedData)
+	}
+	r.outbox[0] = moved
+	delete(r.items[entry.Type], entry.Sealed.ID)
+	r.items[moved.Type][moved.Sealed.ID] = moved.Sealed
+	r.touched = true
+	return r.save()
+}
+
+// put keeps the item returned by the server, so it's shown before the next sync.
+func (r *replica) put(t domain.MaterialType, sealed domain.SealedData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.items[t] == nil {
+		r.items[t] = make(map[int]domain.SealedData)
+	}
+	r.items[t][sealed.ID] = sealed
+	r.touched = true
+	return r.save()
+}
+
+// next returns the oldest queued change.
+func (r *replica) next() (outboxEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.outbox) == 0 {
+		return outboxEntry{}, false
+	}
+	return r.outbox[0], true
+}
+
+// sent drops the oldest queued change. The local copy of the created item is replaced with
+// the created one, which has the id given by the server.
+func (r *replica) sent(entry outboxEntry, created domain.SealedData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outbox = r.outbox[1:]
+	if entry.Op == outboxCreate {
+		delete(r.items[entry.Type], entry.Sealed.ID)
+		if created.ID > 0 {
+			r.items[entry.Type][created.ID] = created
+		}
+		r.touched = true
+	}
+	return r.save()
+}
+
+func (r *replica) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.outbox)
+}
+
+func (c *GKClient) replicaPath(login string) string {
+	sum := sha256.Sum256([]byte(login))
+	return filepath.Join(c.stateDir, "vaults", hex.EncodeToString(sum[:])+".json")
+}
+
+// OpenLocalVault unlocks the vault and opens its local copy without the server, e.g. when it's unreachable.
+// The changes are sent by Sync after sign in.
+func (c *GKClient) OpenLocalVault(login, masterPassword string) error {
+	sealer, err := seal.NewAESGCMSealer(seal.DeriveKey(masterPassword, login))
+	if err != nil {
+		return err
+	}
+
+	// the replica is sealed with the key, so a wrong master password doesn't open it
+	r, err := openReplica(c.replicaPath(login), sealer)
+	if err != nil {
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			return ErrNoLocalVault
+		case errors.Is(err, domain.ErrDataCannotBeOpened):
+			return ErrWrongMasterPassword
+		default:
+			return err
+		}
+	}
+
+	c.sealer, c.replica = sealer, r
+	return nil
+}
+
+// PendingChanges returns the number of changes made offline and not sent yet.
+func (c *GKClient) PendingChanges() int {
+	return c.replica.pending()
+}
+
+// Conflicts returns the changes made offline, which were rejected because the items were changed
+// by somebody else. They are returned once, Mine and Base of the errors are set.
+func (c *GKClient) Conflicts() []*ConflictError {
+	conflicts := c.conflicts
+	c.conflicts = nil
+	return conflicts
+}
+
+// newIdempotencyKey makes a random key for a create request.
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// create seals the new item with an id reserved on the server. The item is sealed with a local id, if it's kept
+// offline, replay moves it to a reserved one before it's sent.
+func (c *GKClient) create(ctx context.Context, t domain.MaterialType, v interface{}) (domain.SealedData, error) {
+	if c.sealer == nil {
+		return domain.SealedData{}, ErrVaultLocked
+	}
+
+	var (
+		id  int
+		err error
+	)
+	if c.replica.pending() == 0 {
+		id, err = c.reserveID(ctx, t)
+		if err != nil && !errors.Is(err, ErrOffline) {
+			return domain.SealedData{}, err
+		}
+	}
+	if id == 0 {
+		if id, err = c.replica.newLocalID(); err != nil {
+			return domain.SealedData{}, err
+		}
+	}
+
+	sealed, err := c.seal(t, id, v)
+	if err != nil {
+		return sealed, err
+	}
+	return c.send(ctx, outboxCreate, t, sealed)
+}
+
+// reseal makes the queued change a create of a new item with a reserved id. The entry is saved before it's sent,
+// so the retries send the same item with the same key.
+func (c *GKClient) reseal(ctx context.Context, entry outboxEntry) (outboxEntry, error) {
+	id, err := c.reserveID(ctx, entry.Type)
+	if err != nil {
+		return entry, err
+	}
+
+	var v json.RawMessage
+	if err := c.open(entry.Type, entry.Sealed, &v); err != nil {
+		return entry, err
+	}
+
+	sealed, err := c.seal(entry.Type, id, v)
+	if err != nil {
+		return entry, err
+	}
+
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return entry, err
+	}
+
+	moved := outboxEntry{Op: outboxCreate, Type: entry.Type, Sealed: sealed, Key: key}
+	return moved, c.replica.move(entry, moved)
+}
+
+// send sends the change. It's queued, if the server is unreachable or earlier changes are still queued,
+// so the server gets them in order. The created item is returned as stored on the server or locally.
+func (c *GKClient) send(ctx context.Context, op outboxOp, t domain.MaterialType, sealed domain.SealedData) (domain.SealedData, error) {
+	var key string
+	if op == outboxCreate {
+		var err error
+		if key, err = newIdempotencyKey(); err != nil {
+			return sealed, err
+		}
+	}
+
+	// items with local ids are sent by replay
+	if c.replica.pending() == 0 && sealed.ID > 0 {
+		created, err := c.sendOp(ctx, op, t, sealed, key)
+		// the item may be created already, if the response was lost, the key tells the server it's a retry
+		for i := 0; i < createRetries && op == outboxCreate && errors.Is(err, ErrOffline); i++ {
+			select {
+			case <-ctx.Done():
+				return sealed, ctx.Err()
+			case <-time.After(createRetryDelay):
+			}
+			created, err = c.sendOp(ctx, op, t, sealed, key)
+		}
+
+		switch {
+		case errors.Is(err, ErrOffline):
+		case err != nil:
+			return sealed, err
+		case op == outboxCreate:
+			return created, c.replica.put(t, created)
+		default:
+			return created, nil
+		}
+	}
+
+	return c.replica.queue(op, t, sealed, key)
+}
+
+func (c *GKClient) sendOp(ctx context.Context, op outboxOp, t domain.MaterialType, sealed domain.SealedData, key string) (domain.SealedData, error) {
+	endpoint, ok := materialEndpoints[t]
+	if !ok {
+		return sealed, domain.ErrUnknownMaterialType
+	}
+
+	switch op {
+	case outboxCreate:
+		return c.sendSealedData(ctx, http.MethodPut, endpoint, sealed, key)
+	case outboxUpdate:
+		return c.sendSealedData(ctx, http.MethodPost, endpoint, sealed, "")
+	case outboxDelete:
+		return sealed, c.deleteData(ctx, endpoint, sealed.ID)
+	default:
+		return sealed, fmt.Errorf("unknown outbox operation %q", op)
+	}
+}
+
+// replay sends the queued changes in order. It stops, if the server can't take the change now,
+// the change is sent again next time. Updates rejected because of a conflict are kept for Conflicts.
+func (c *GKClient) replay(ctx context.Context) error {
+	for {
+		entry, ok := c.replica.next()
+		if !ok {
+			return nil
+		}
+
+		if entry.Op == outboxCreate && entry.Sealed.ID < 0 {
+			var err error
+			if entry, err = c.reseal(ctx, entry); err != nil {
+				return err
+			}
+		}
+
+		created, err := c.sendOp(ctx, entry.Op, entry.Type, entry.Sealed, entry.Key)
+
+		var conflict *ConflictError
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrOffline), errors.Is(err, domain.ErrUserNotFound), errors.Is(err, domain.ErrInternalServerError):
+			return err
+		case errors.As(err, &conflict):
+			if err := c.keepConflict(entry, conflict); err != nil {
+				return err
+			}
+		case errors.Is(err, domain.ErrDataNotFound) && entry.Op == outboxDelete:
+		case errors.Is(err, domain.ErrIdempotencyKeyReused) && entry.Op == outboxCreate:
+			// the create has reached the server and the item was changed offline after it
+			created, err = c.updateCreated(ctx, entry)
+			if errors.As(err, &conflict) {
+				if err := c.keepConflict(entry, conflict); err != nil {
+					return err
+				}
+				created, err = conflict.sealed, nil
+			}
+			if err != nil {
+				return err
+			}
+		case errors.Is(err, domain.ErrDataNotFound) && entry.Op == outboxUpdate,
+			errors.Is(err, domain.ErrCreatedItemPurged) && entry.Op == outboxCreate,
+			errors.Is(err, domain.ErrIDNotReserved) && entry.Op == outboxCreate:
+			// the item was deleted on another device or the reservation has expired,
+			// the change is sent as a new item to not lose it
+			if created, err = c.sendAsNew(ctx, entry); err != nil {
+				return err
+			}
+		default:
+			// the server would reject the change again
+			if err := c.replica.sent(entry, domain.SealedData{}); err != nil {
+				return err
+			}
+			return fmt.Errorf("change made offline was rejected: %w", err)
+		}
+
+		if err := c.replica.sent(entry, created); err != nil {
+			return err
+		}
+	}
+}
+
+// updateCreated sends the queued create as an update of the item created by its first request. The item is
+// sent as a new one, if it was deleted since then.
+func (c *GKClient) updateCreated(ctx context.Context, entry outboxEntry) (domain.SealedData, error) {
+	current, err := c.getSealedByID(ctx, materialEndpoints[entry.Type], entry.Sealed.ID)
+	if errors.Is(err, domain.ErrDataNotFound) {
+		return c.sendAsNew(ctx, entry)
+	}
+	if err != nil {
+		return current, err
+	}
+
+	// the change is based on the first revision, so the changes made on other devices since then
+	// end in a conflict instead of being overwritten
+	sealed := entry.Sealed
+	sealed.Revision = 1
+	updated, err := c.sendOp(ctx, outboxUpdate, entry.Type, sealed, "")
+	updated.CreatedAt, updated.UpdatedAt = current.CreatedAt, current.UpdatedAt
+	return updated, err
+}
+
+// sendAsNew moves the queued change to a new item and sends it.
+func (c *GKClient) sendAsNew(ctx context.Context, entry outboxEntry) (domain.SealedData, error) {
+	entry, err := c.reseal(ctx, entry)
+	if err != nil {
+		return entry.Sealed, err
+	}
+	return c.sendOp(ctx, outboxCreate, entry.Type, entry.Sealed, entry.Key)
+}
+
+func (c *GKClient) keepConflict(entry outboxEntry, conflict *ConflictError) error {
+	if err := c.openConflict(entry.Type, conflict); err != nil {
+		return err
+	}
+
+	mine, err := c.openData(entry.Type, entry.Sealed)
+	if err != nil {
+		return err
+	}
+
+	conflict.Mine = mine
+	// a create has no base, the server version is taken as it is on merge
+	if entry.Op == outboxCreate {
+		c.conflicts = append(c.conflicts, conflict)
+		return nil
+	}
+
+	base, err := c.openData(entry.Type, entry.Base)
+	if err != nil {
+		return err
+	}
+
+	conflict.Base = base
+	c.conflicts = append(c.conflicts, conflict)
+	return nil
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"gophkeeper/internal/domain"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// weights of the fields a word is found in, names and logins tell more about the item than its notes
+const (
+	weightTitle    = 3
+	weightMetadata = 2
+	weightBody     = 1
+)
+
+// searchField is an opened field of an item with its weight.
+type searchField struct {
+	value  string
+	weight int
+}
+
+// rankFields returns the rank of the item for the words, 0 is returned if any word isn't found.
+// A word counts with the weight of the best field it's found in, doubled if a word of the field starts with it.
+func rankFields(words []string, fields []searchField) int {
+	rank := 0
+	for _, word := range words {
+		best := 0
+		for _, field := range fields {
+			value := strings.ToLower(field.value)
+			if !strings.Contains(value, word) {
+				continue
+			}
+
+			score := field.weight
+			for _, token := range strings.FieldsFunc(value, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+				if strings.HasPrefix(token, word) {
+					score *= 2
+					break
+				}
+			}
+			if score > best {
+				best = score
+			}
+		}
+		if best == 0 {
+			return 0
+		}
+		rank += best
+	}
+	return rank
+}
+
+// Search finds the items containing all words of the query in their titles, logins, metadata or notes.
+// Items are sealed with the key the server doesn't have, so it can't index them. They are opened and searched
+// in the local vault, file names and metadata are fetched from the server and skipped while it's unreachable.
+// Passwords, card numbers and CVV aren't searched.
+func (c *GKClient) Search(ctx context.Context, query string) ([]domain.SearchResult, error) {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	var results []domain.SearchResult
+	add := func(t domain.MaterialType, id int, title string, fields ...searchField) {
+		if rank := rankFields(words, fields); rank > 0 {
+			results = append(results, domain.SearchResult{Type: t, ID: id, Title: title, Rank: rank})
+		}
+	}
+
+	texts, err := c.LocalTextData()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range texts {
+		add(domain.MaterialText, data.ID, data.Text, searchField{data.Metadata, weightMetadata}, searchField{data.Text, weightBody})
+	}
+
+	cards, err := c.LocalCardData()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range cards {
+		add(domain.MaterialCard, data.ID, data.Name+" "+data.Surname,
+			searchField{data.Name + " " + data.Surname, weightTitle}, searchField{data.Metadata, weightMetadata})
+	}
+
+	creds, err := c.LocalCredData()
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range creds {
+		add(domain.MaterialCred, data.ID, data.Login, searchField{data.Login, weightTitle}, searchField{data.Metadata, weightMetadata})
+	}
+
+	blobs, err := c.GetAllBlobs(ctx)
+	if err != nil && !errors.Is(err, ErrOffline) && !errors.Is(err, domain.ErrDataNotFound) {
+		return nil, err
+	}
+	for _, blob := range blobs {
+		add(domain.MaterialBlob, blob.ID, blob.Name, searchField{blob.Name, weightTitle}, searchField{blob.Metadata, weightMetadata})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rank > results[j].Rank
+	})
+	return results, nil
+}
@@ -0,0 +1,267 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/seal"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const SyncEndpoint = "/api/sync"
+
+// additional data of the sealed replica file
+const replicaAD = "replica"
+
+var ErrNoLocalVault = errors.New("there is no local copy of the vault")
+
+// replica is the copy of the user's text, card and cred items kept up to date by Sync.
+// It's saved to disk, so the items can be browsed and changed while the server is unreachable.
+type replica struct {
+	mu     sync.Mutex
+	cursor int64
+	items  map[domain.MaterialType]map[int]domain.SealedData
+
+	// changes made offline, see queue
+	outbox  []outboxEntry
+	localID int // the last id given to an item created offline, they are negative
+	touched bool
+
+	// the replica isn't saved without path
+	path   string
+	sealer seal.Sealer
+}
+
+// replicaFile is the replica on disk. It's sealed as a whole, so ids and the outbox aren't seen without the key too.
+type replicaFile struct {
+	Cursor  int64                                             `json:"cursor"`
+	Items   map[domain.MaterialType]map[int]domain.SealedData `json:"items"`
+	Outbox  []outboxEntry                                     `json:"outbox"`
+	LocalID int                                               `json:"local_id"`
+}
+
+func newReplica() *replica {
+	return &replica{items: make(map[domain.MaterialType]map[int]domain.SealedData)}
+}
+
+// openReplica reads the replica saved at path. An empty replica is returned with an error, if there is
+// no file or it can't be read. It replaces the file when saved, unless the file is sealed with another key.
+func openReplica(path string, sealer seal.Sealer) (*replica, error) {
+	r := newReplica()
+	r.path, r.sealer = path, sealer
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+
+	var sealed domain.SealedData
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return r, err
+	}
+
+	plaintext, err := sealer.Open(sealed.Data, sealed.Nonce, []byte(replicaAD))
+	if err != nil {
+		// a wrong master password, the file is kept for the right one
+		return newReplica(), domain.ErrDataCannotBeOpened
+	}
+
+	var file replicaFile
+	if err := json.Unmarshal(plaintext, &file); err != nil {
+		return r, err
+	}
+
+	r.cursor, r.outbox, r.localID = file.Cursor, file.Outbox, file.LocalID
+	if file.Items != nil {
+		r.items = file.Items
+	}
+	r.touched = true
+	return r, nil
+}
+
+// save writes the replica to disk, r.mu must be held.
+func (r *replica) save() error {
+	if r.path == "" {
+		return nil
+	}
+
+	plaintext, err := json.Marshal(replicaFile{Cursor: r.cursor, Items: r.items, Outbox: r.outbox, LocalID: r.localID})
+	if err != nil {
+		return err
+	}
+
+	data, nonce, err := r.sealer.Seal(plaintext, []byte(replicaAD))
+	if err != nil {
+		return err
+	}
+
+	fileData, err := json.Marshal(domain.SealedData{Data: data, Nonce: nonce})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+
+	// the old file stays whole, if writing is interrupted
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, fileData, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// takeTouched reports whether the items were changed locally since the last call.
+func (r *replica) takeTouched() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	touched := r.touched
+	r.touched = false
+	return touched
+}
+
+func (r *replica) apply(changes domain.SyncChanges) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if changes.Reset {
+		r.items = make(map[domain.MaterialType]map[int]domain.SealedData)
+	}
+
+	for _, item := range changes.Items {
+		if r.items[item.Type] == nil {
+			r.items[item.Type] = make(map[int]domain.SealedData)
+		}
+		r.items[item.Type][item.ID] = item.SealedData
+	}
+	for _, tombstone := range changes.Deleted {
+		delete(r.items[tombstone.Type], tombstone.ID)
+	}
+
+	r.cursor = changes.Cursor
+	if !changes.Reset && len(changes.Items) == 0 && len(changes.Deleted) == 0 {
+		return false, nil
+	}
+	return true, r.save()
+}
+
+// sealed returns the items of the type ordered by id.
+func (r *replica) sealed(t domain.MaterialType) []domain.SealedData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]domain.SealedData, 0, len(r.items[t]))
+	for _, sealed := range r.items[t] {
+		result = append(result, sealed)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func (r *replica) since() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cursor
+}
+
+// Sync sends the changes made offline and then fetches the changes made since the last sync and applies them
+// to the local replica. It reports whether anything was changed, local changes count even if it fails,
+// e.g. with ErrOffline. Conflicts of the changes made offline are returned by Conflicts.
+func (c *GKClient) Sync(ctx context.Context) (bool, error) {
+	// otherwise the server versions would hide the local changes until they are sent
+	if err := c.replay(ctx); err != nil {
+		return c.replica.takeTouched(), err
+	}
+
+	changes, err := c.getChanges(ctx, c.replica.since())
+	if err != nil {
+		return c.replica.takeTouched(), err
+	}
+
+	changed, err := c.replica.apply(changes)
+	return c.replica.takeTouched() || changed, err
+}
+
+func (c *GKClient) getChanges(ctx context.Context, since int64) (domain.SyncChanges, error) {
+	var changes domain.SyncChanges
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s%s?since=%d", c.addr, SyncEndpoint, since), nil)
+	if err != nil {
+		return changes, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return changes, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		err = json.NewDecoder(response.Body).Decode(&changes)
+		return changes, err
+	case http.StatusUnauthorized:
+		return changes, domain.ErrUserNotFound
+	case http.StatusInternalServerError:
+		return changes, domain.ErrInternalServerError
+	default:
+		return changes, errors.New(response.Status)
+	}
+}
+
+// LocalTextData returns text items from the local replica, call Sync to refresh it.
+func (c *GKClient) LocalTextData() ([]domain.TextData, error) {
+	sealedArray := c.replica.sealed(domain.MaterialText)
+
+	result := make([]domain.TextData, 0, len(sealedArray))
+	for _, sealed := range sealedArray {
+		var data domain.TextData
+		if err := c.open(domain.MaterialText, sealed, &data); err != nil {
+			return nil, err
+		}
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// LocalCardData returns card items from the local replica, call Sync to refresh it.
+func (c *GKClient) LocalCardData() ([]domain.CardData, error) {
+	sealedArray := c.replica.sealed(domain.MaterialCard)
+
+	result := make([]domain.CardData, 0, len(sealedArray))
+	for _, sealed := range sealedArray {
+		var data domain.CardData
+		if err := c.open(domain.MaterialCard, sealed, &data); err != nil {
+			return nil, err
+		}
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		result = append(result, data)
+	}
+	return result, nil
+}
+
+// LocalCredData returns cred items from the local replica, call Sync to refresh it.
+func (c *GKClient) LocalCredData() ([]domain.CredData, error) {
+	sealedArray := c.replica.sealed(domain.MaterialCred)
+
+	result := make([]domain.CredData, 0, len(sealedArray))
+	for _, sealed := range sealedArray {
+		var data domain.CredData
+		if err := c.open(domain.MaterialCred, sealed, &data); err != nil {
+			return nil, err
+		}
+		data.ID, data.Revision, data.CreatedAt, data.UpdatedAt = sealed.ID, sealed.Revision, sealed.CreatedAt, sealed.UpdatedAt
+		result = append(result, data)
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gophkeeper/internal/domain"
+	"net/http"
+	"net/url"
+)
+
+const TrashEndpoint = "/api/materials/trash"
+
+// GetTrash returns deleted items with titles to recognize them, see describe.
+func (c *GKClient) GetTrash(ctx context.Context) ([]domain.TrashInfo, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.addr+TrashEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		var items []domain.TrashItem
+		if err := json.NewDecoder(response.Body).Decode(&items); err != nil {
+			return nil, err
+		}
+
+		result := make([]domain.TrashInfo, 0, len(items))
+		for _, item := range items {
+			title, err := c.describe(item.Type, domain.SealedData{ID: item.ItemID, Data: item.Data, Nonce: item.Nonce})
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, domain.TrashInfo{
+				ID:        item.ID,
+				Type:      item.Type,
+				ItemID:    item.ItemID,
+				Title:     title,
+				DeletedAt: item.DeletedAt,
+			})
+		}
+		return result, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusUnauthorized:
+		return nil, domain.ErrUserNotFound
+	case http.StatusInternalServerError:
+		return nil, domain.ErrInternalServerError
+	default:
+		return nil, errors.New(response.Status)
+	}
+}
+
+func (c *GKClient) RestoreFromTrash(ctx context.Context, trashID string) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.addr+TrashEndpoint+"/"+url.PathEscape(trashID)+"/restore", nil)
+	if err != nil {
+		return err
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusBadRequest:
+		return errors.New("bad trash item id")
+	case http.StatusUnauthorized:
+		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrDataNotFound
+	case http.StatusInternalServerError:
+		return domain.ErrInternalServerError
+	default:
+		return errors.New(response.Status)
+	}
+}
@@ -0,0 +1,349 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/seal"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const UploadsEndpoint = BlobDataEndpoint + "/uploads"
+
+// every upload chunk holds whole sealed frames, see uploadChunkSize
+const framesPerUploadChunk = 16
+
+var ErrUploadSourceChanged = errors.New("file was changed since the upload started, start it again")
+
+// UploadState is kept on disk while the upload is in progress, so it can be resumed after restart.
+type UploadState struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"` // size of the source file, the file must not change until the upload ends
+	ModTime   time.Time `json:"mod_time"`
+	ChunkSize int       `json:"chunk_size"`
+	FileID    string    `json:"file_id"` // the file is sealed with it again on resume, see blobMetadata
+}
+
+// uploadChunkSize is a multiple of the sealed frame size. Frames are sealed with random nonces,
+// so the file sealed again on resume differs from the first time, but its frames are interchangeable
+// as long as chunks don't split them.
+func (c *GKClient) uploadChunkSize() int {
+	return framesPerUploadChunk * seal.StreamFrameSize(c.sealer)
+}
+
+func (c *GKClient) uploadsDir() string {
+	return filepath.Join(c.stateDir, "uploads")
+}
+
+func (c *GKClient) saveUploadState(state UploadState) error {
+	if err := os.MkdirAll(c.uploadsDir(), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(c.uploadsDir(), state.ID+".json"), data, 0600)
+}
+
+func (c *GKClient) loadUploadState(uploadID string) (UploadState, error) {
+	var state UploadState
+
+	data, err := os.ReadFile(filepath.Join(c.uploadsDir(), filepath.Base(uploadID)+".json"))
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func (c *GKClient) removeUploadState(uploadID string) error {
+	err := os.Remove(filepath.Join(c.uploadsDir(), filepath.Base(uploadID)+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// PendingUploads lists uploads interrupted before they were finalized.
+func (c *GKClient) PendingUploads() ([]UploadState, error) {
+	entries, err := os.ReadDir(c.uploadsDir())
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	states := make([]UploadState, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		state, err := c.loadUploadState(entry.Name()[:len(entry.Name())-len(".json")])
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// UploadBlobFile uploads the file in chunks. If it's interrupted, it may be continued
+// with ResumeBlobUpload, even after restart.
+func (c *GKClient) UploadBlobFile(ctx context.Context, path, name, metadata string) (domain.BlobInfo, error) {
+	if c.sealer == nil {
+		return domain.BlobInfo{}, ErrVaultLocked
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	fileID, err := newFileID()
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	sealedMeta, err := c.seal(blobMetadataAD, 0, blobMetadata{Name: name, Metadata: metadata, FileID: fileID})
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	var upload domain.BlobUpload
+	err = c.uploadRequest(ctx, http.MethodPost, UploadsEndpoint, nil, domain.BlobUpload{
+		Metadata:  sealedMeta.Data,
+		Nonce:     sealedMeta.Nonce,
+		Size:      seal.SealedStreamSize(c.sealer, stat.Size()),
+		ChunkSize: c.uploadChunkSize(),
+	}, &upload)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	err = c.saveUploadState(UploadState{
+		ID:        upload.ID,
+		Path:      path,
+		Name:      name,
+		Size:      stat.Size(),
+		ModTime:   stat.ModTime(),
+		ChunkSize: upload.ChunkSize,
+		FileID:    fileID,
+	})
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	return c.ResumeBlobUpload(ctx, upload.ID)
+}
+
+// ResumeBlobUpload sends the chunks the server hasn't received yet and finalizes the upload.
+func (c *GKClient) ResumeBlobUpload(ctx context.Context, uploadID string) (domain.BlobInfo, error) {
+	if c.sealer == nil {
+		return domain.BlobInfo{}, ErrVaultLocked
+	}
+
+	state, err := c.loadUploadState(uploadID)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	var upload domain.BlobUpload
+	err = c.uploadRequest(ctx, http.MethodGet, UploadsEndpoint+"/"+uploadID, nil, nil, &upload)
+	if err != nil {
+		if errors.Is(err, domain.ErrUploadNotFound) {
+			_ = c.removeUploadState(uploadID)
+		}
+		return domain.BlobInfo{}, err
+	}
+
+	f, err := os.Open(state.Path)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+	if stat.Size() != state.Size || !stat.ModTime().Equal(state.ModTime) {
+		return domain.BlobInfo{}, ErrUploadSourceChanged
+	}
+
+	received := make(map[int]bool)
+	for _, r := range upload.Received {
+		for seq := r[0]; seq <= r[1]; seq++ {
+			received[seq] = true
+		}
+	}
+
+	// the file is sealed again from the beginning, but only the missing chunks are sent
+	chunks := &chunkWriter{
+		buf: make([]byte, 0, upload.ChunkSize),
+		send: func(seq int, chunk []byte) error {
+			if received[seq] {
+				return nil
+			}
+			return c.putUploadChunk(ctx, uploadID, seq, chunk)
+		},
+	}
+
+	sw := seal.NewStreamWriter(c.sealer, chunks, blobFileAD(state.FileID))
+	if _, err := io.Copy(sw, f); err != nil {
+		return domain.BlobInfo{}, err
+	}
+	if err := sw.Close(); err != nil {
+		return domain.BlobInfo{}, err
+	}
+	if err := chunks.Close(); err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	var blob domain.BlobData
+	err = c.uploadRequest(ctx, http.MethodPost, UploadsEndpoint+"/"+uploadID+"/finalize", nil, nil, &blob)
+	if err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	if err := c.removeUploadState(uploadID); err != nil {
+		return domain.BlobInfo{}, err
+	}
+
+	return c.openBlob(blob)
+}
+
+// AbortBlobUpload drops the upload on the server and forgets it locally.
+func (c *GKClient) AbortBlobUpload(ctx context.Context, uploadID string) error {
+	err := c.uploadRequest(ctx, http.MethodDelete, UploadsEndpoint+"/"+uploadID, nil, nil, nil)
+	if err != nil && !errors.Is(err, domain.ErrUploadNotFound) {
+		return err
+	}
+
+	return c.removeUploadState(uploadID)
+}
+
+func (c *GKClient) putUploadChunk(ctx context.Context, uploadID string, seq int, chunk []byte) error {
+	sum := sha256.Sum256(chunk)
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+
+	return c.uploadRequest(ctx, http.MethodPut, fmt.Sprintf("%s/%s/chunks/%d", UploadsEndpoint, uploadID, seq), header, chunk, nil)
+}
+
+// uploadRequest sends body (raw bytes or a value to marshal) and decodes the response into result, if it's set.
+func (c *GKClient) uploadRequest(ctx context.Context, method, endpoint string, header http.Header, body interface{}, result interface{}) error {
+	var bodyReader io.Reader
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		bodyReader = bytes.NewReader(b)
+	default:
+		bodyJson, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		bodyReader = bytes.NewReader(bodyJson)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.addr+endpoint, bodyReader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	request.Header.Add("Authorization", c.accessToken())
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		if result == nil {
+			return nil
+		}
+		return json.NewDecoder(response.Body).Decode(result)
+	case http.StatusBadRequest:
+		respBody, err := io.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		return badRequestError(respBody)
+	case http.StatusUnauthorized:
+		return domain.ErrUserNotFound
+	case http.StatusNotFound:
+		return domain.ErrUploadNotFound
+	case http.StatusConflict:
+		return domain.ErrUploadIncomplete
+	case http.StatusRequestEntityTooLarge:
+		return domain.ErrBlobTooLarge
+	case http.StatusTooManyRequests:
+		return domain.ErrTooManyUploads
+	case http.StatusInternalServerError:
+		return domain.ErrInternalServerError
+	default:
+		return errors.New(response.Status)
+	}
+}
+
+// chunkWriter cuts the written stream into chunks of cap(buf) bytes, the last one may be shorter.
+type chunkWriter struct {
+	buf  []byte
+	seq  int
+	send func(seq int, chunk []byte) error
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close sends the rest of the stream.
+func (w *chunkWriter) Close() error {
+	if len(w.buf) == 0 && w.seq > 0 {
+		return nil
+	}
+	return w.flush()
+}
+
+func (w *chunkWriter) flush() error {
+	if err := w.send(w.seq, w.buf); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.seq++
+	return nil
+}
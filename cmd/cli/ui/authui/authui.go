@@ -13,13 +13,19 @@ import (
 )
 
 type Creds struct {
-	Login    string
-	Password string
+	Login          string
+	Password       string
+	MasterPassword string // never sent to the server, used to unlock the vault
 }
 
 type SignInMsg Creds
 type SignUpMsg Creds
 
+// CodeMsg carries a one-time code for the second step of sign in.
+type CodeMsg struct {
+	Code string
+}
+
 func SignUp(c Creds, fn func(c Creds) tea.Msg) tea.Cmd {
 	return func() tea.Msg {
 		return fn(c)
@@ -47,11 +53,15 @@ var (
 type Model struct {
 	focusIndex int
 	inputs     []textinput.Model
+
+	// set when the server asked for a second factor
+	codeMode bool
+	code     textinput.Model
 }
 
 func New() Model {
 	m := Model{
-		inputs: make([]textinput.Model, 2),
+		inputs: make([]textinput.Model, 3),
 	}
 
 	var t textinput.Model
@@ -72,19 +82,63 @@ func New() Model {
 			t.EchoMode = textinput.EchoPassword
 			t.EchoCharacter = '•'
 			t.SetCursorMode(textinput.CursorBlink)
+		case 2:
+			t.Placeholder = "Master password"
+			t.EchoMode = textinput.EchoPassword
+			t.EchoCharacter = '•'
+			t.SetCursorMode(textinput.CursorBlink)
 		}
 
 		m.inputs[i] = t
 	}
 
+	m.code = textinput.New()
+	m.code.Placeholder = "Authentication code"
+	m.code.CursorStyle = cursorStyle
+	m.code.CharLimit = 32
+	m.code.PromptStyle = focusedStyle
+	m.code.TextStyle = focusedStyle
+
 	return m
 }
 
+// WithCodeStep switches the form to the one-time code input.
+func (m Model) WithCodeStep() Model {
+	m.codeMode = true
+	m.code.Reset()
+	m.code.Focus()
+	return m
+}
+
+func (m Model) updateCode(msg tea.Msg) (Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "ctrl+c":
+			return m, tea.Quit
+		case "esc":
+			m.codeMode = false
+			m.code.Blur()
+			return m, nil
+		case "enter":
+			code := CodeMsg{Code: strings.TrimSpace(m.code.Value())}
+			return m, func() tea.Msg { return code }
+		}
+	}
+
+	var cmd tea.Cmd
+	m.code, cmd = m.code.Update(msg)
+	return m, cmd
+}
+
 func (m Model) Init() tea.Cmd {
 	return textinput.Blink
 }
 
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	if m.codeMode {
+		return m.updateCode(msg)
+	}
+
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
@@ -99,8 +153,9 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			// If so, exit.
 			if s == "enter" && m.focusIndex >= len(m.inputs) {
 				creds := Creds{
-					Login:    m.inputs[0].Value(),
-					Password: m.inputs[1].Value(),
+					Login:          m.inputs[0].Value(),
+					Password:       m.inputs[1].Value(),
+					MasterPassword: m.inputs[2].Value(),
 				}
 
 				var cmd tea.Cmd
@@ -165,6 +220,12 @@ func (m *Model) updateInputs(msg tea.Msg) tea.Cmd {
 func (m Model) View() string {
 	var b strings.Builder
 
+	if m.codeMode {
+		fmt.Fprintf(&b, "%s\n\n%s\n\n", m.code.View(),
+			blurredStyle.Render("enter the code from your authenticator app or esc to go back"))
+		return b.String()
+	}
+
 	for i := range m.inputs {
 		b.WriteString(m.inputs[i].View())
 		if i < len(m.inputs)-1 {
@@ -4,6 +4,8 @@ package app
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"gophkeeper/internal/config"
 	"gophkeeper/internal/delivery/http"
 	"gophkeeper/internal/server"
@@ -12,9 +14,11 @@ import (
 	"gophkeeper/pkg/auth"
 	"gophkeeper/pkg/hash"
 	"log"
+	"net"
 	nethttp "net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -32,9 +36,21 @@ func Run() {
 	}
 
 	storages := storage.NewStorages(db)
+	if cfg.RevokedTokensInMemory {
+		storages.RevokedTokens = storage.NewRevokedTokensMemStorage()
+	}
+	if cfg.LoginAttemptsInMemory {
+		storages.LoginAttempts = storage.NewLoginAttemptsMemStorage()
+	}
+
+	hasher := hash.NewArgon2Hasher()
+	legacyHasher := hash.NewSHA1Hasher(cfg.PasswordSalt)
+	tokenManager, err := newTokenManager(&cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	hasher := hash.NewSHA1Hasher(cfg.PasswordSalt)
-	tokenManager, err := auth.NewManager(cfg.PasswordSalt)
+	passwordPolicy, err := service.NewPasswordPolicy(cfg.PasswordMinLength, cfg.PasswordMinClasses, cfg.PasswordDenyListFile)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -42,15 +58,28 @@ func Run() {
 	deps := service.Deps{
 		Storages:        storages,
 		Hasher:          hasher,
+		LegacyHasher:    legacyHasher,
 		TokenManager:    tokenManager,
 		AccessTokenTTL:  10 * time.Minute,
 		RefreshTokenTTL: 40 * 24 * time.Hour,
+		PasswordPolicy:  passwordPolicy,
+		MaxBlobSize:     cfg.MaxBlobSize,
+		TrashRetention:  cfg.TrashRetention,
+		PurgeInterval:   cfg.TrashPurgeInterval,
+		IdempotencyTTL:  cfg.IdempotencyKeyTTL,
+		MaxVersions:     cfg.HistoryMaxVersions,
 	}
 
 	services := service.NewServices(deps)
+	services.Updater.Start()
 
 	// HTTP server
-	handlers := http.NewHandler(services, tokenManager)
+	trustedProxies, err := parseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	handlers := http.NewHandler(services, tokenManager, trustedProxies)
 	httpSrv := server.NewServer(&cfg, handlers.InitEcho())
 
 	connectionsClosed := make(chan struct{})
@@ -59,10 +88,14 @@ func Run() {
 
 	go func() {
 		<-interrupt
+		// event streams never end by themselves
+		services.Events.Close()
 		if err := httpSrv.Stop(context.Background()); err != nil {
 			log.Printf("HTTP server shutdown: %v", err)
 		}
 
+		services.Updater.Stop()
+
 		if err := storages.Users.Close(); err != nil {
 			log.Printf("Storage shutdown error: %v", err)
 		}
@@ -80,6 +113,62 @@ func Run() {
 	log.Println("Server shutdown gracefully")
 }
 
+func newTokenManager(cfg *config.Config) (auth.TokenManager, error) {
+	if cfg.JWTPrivateKeyFile != "" {
+		return newRSATokenManager(cfg)
+	}
+
+	if cfg.JWTSigningKey == "" {
+		return nil, errors.New("signing key is required: set -k or JWT_SIGNING_KEY, or -j or JWT_PRIVATE_KEY_FILE for RS256")
+	}
+
+	activeKey, err := auth.ParseKey(cfg.JWTSigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	retiredKeys := make([]auth.Key, 0, len(cfg.JWTRetiredKeys))
+	for _, s := range cfg.JWTRetiredKeys {
+		key, err := auth.ParseKey(s)
+		if err != nil {
+			return nil, err
+		}
+		retiredKeys = append(retiredKeys, key)
+	}
+
+	return auth.NewManager(activeKey, retiredKeys...)
+}
+
+func newRSATokenManager(cfg *config.Config) (auth.TokenManager, error) {
+	activeKey, err := auth.LoadRSAKey(cfg.JWTPrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	retiredKeys := make([]auth.RSAKey, 0, len(cfg.JWTRetiredKeyFiles))
+	for _, path := range cfg.JWTRetiredKeyFiles {
+		key, err := auth.LoadRSAPublicKey(path)
+		if err != nil {
+			return nil, err
+		}
+		retiredKeys = append(retiredKeys, key)
+	}
+
+	return auth.NewRSAManager(activeKey, retiredKeys...)
+}
+
+func parseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	proxies := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %q: %w", cidr, err)
+		}
+		proxies = append(proxies, ipRange)
+	}
+	return proxies, nil
+}
+
 func newInPSQL(databaseDSN string) (*sql.DB, error) {
 	db, err := sql.Open("postgres", databaseDSN)
 	if err != nil {
@@ -90,52 +179,168 @@ func newInPSQL(databaseDSN string) (*sql.DB, error) {
 		log.Fatal(err)
 	}
 
-	if err = createTable(db); err != nil {
+	if err = migrate(db); err != nil {
 		log.Fatal(err)
 	}
 	return db, nil
 }
 
-func createTable(db *sql.DB) error {
+// createTable creates the current schema in a fresh database, existing ones are upgraded by migrations.
+func createTable(tx *sql.Tx) error {
 	query := `CREATE TABLE IF NOT EXISTS users (
 		id serial primary key,
 		login text not null unique,
-		password text not null
+		password text not null,
+		totp_secret text not null default '',
+		totp_enabled boolean not null default false,
+		totp_last_step bigint not null default 0,
+		change_seq bigint not null default 0, -- last change of materials, the sync cursor
+		purged_seq bigint not null default 0, -- last change purged from the trash, older cursors can't be synced
+		key_check bytea, -- known value sealed with the vault key, it tells a wrong master password
+		key_check_nonce bytea
+    );
+    CREATE TABLE IF NOT EXISTS sign_in_challenges (
+		token text primary key, -- sha256 digest of the token
+		user_id int not null references users(id),
+		expired_at timestamp not null,
+		attempts int not null default 0
+    );
+    CREATE TABLE IF NOT EXISTS recovery_codes (
+		user_id int not null references users(id),
+		code text not null, -- sha256 digest of the code
+		primary key (user_id, code)
     );
     CREATE TABLE IF NOT EXISTS sessions (
-    	refresh_token text primary key unique,
+    	refresh_token text primary key unique, -- sha256 digest of the token
+    	family_id text not null,
 		user_id int not null references users(id),
-		expired_at timestamp
+		expired_at timestamp,
+		rotated_at timestamp,
+		device_name text not null default '',
+		user_agent text not null default '',
+		ip text not null default '',
+		created_at timestamp not null default now(),
+		last_used_at timestamp not null default now(),
+		access_token_id text not null default '',
+		access_expired_at timestamp not null default now()
+    );
+    CREATE INDEX IF NOT EXISTS sessions_family_id_idx ON sessions (family_id);
+    CREATE INDEX IF NOT EXISTS sessions_expired_at_idx ON sessions (expired_at);
+    CREATE TABLE IF NOT EXISTS revoked_tokens (
+		token_id text primary key,
+		expired_at timestamp not null
+    );
+    CREATE TABLE IF NOT EXISTS login_attempts (
+		key text primary key, -- "login:<login>" or "ip:<ip>"
+		failures int not null,
+		last_failed_at timestamptz not null
+    );
+    CREATE TABLE IF NOT EXISTS security_events (
+		id serial primary key,
+		user_id int not null references users(id),
+		kind text not null,
+		details text,
+		created_at timestamp not null
     );
 	CREATE TABLE IF NOT EXISTS auth_data (
 		id serial primary key,
 		user_id int not null references users(id),
-		login text not null,
-		password text not null,
-		metadata text
+		"data" bytea not null,
+		nonce bytea not null,
+		revision int not null default 1, -- incremented on every update
+		change_seq bigint not null default 0, -- users.change_seq of the last change
+		created_at timestamptz not null default now(),
+		updated_at timestamptz not null default now(),
+		deleted_at timestamptz -- set when the item is in the trash
 	);
+	CREATE INDEX IF NOT EXISTS auth_data_change_seq_idx ON auth_data (user_id, change_seq);
+	CREATE INDEX IF NOT EXISTS auth_data_created_at_idx ON auth_data (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS auth_data_updated_at_idx ON auth_data (user_id, updated_at, id);
 	CREATE TABLE IF NOT EXISTS text_data (
 		id serial primary key,
 		user_id int not null references users(id),
-		"data" text not null,
-		metadata text
+		"data" bytea not null,
+		nonce bytea not null,
+		revision int not null default 1, -- incremented on every update
+		change_seq bigint not null default 0, -- users.change_seq of the last change
+		created_at timestamptz not null default now(),
+		updated_at timestamptz not null default now(),
+		deleted_at timestamptz -- set when the item is in the trash
+	);
+	CREATE INDEX IF NOT EXISTS text_data_change_seq_idx ON text_data (user_id, change_seq);
+	CREATE INDEX IF NOT EXISTS text_data_created_at_idx ON text_data (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS text_data_updated_at_idx ON text_data (user_id, updated_at, id);
+	CREATE TABLE IF NOT EXISTS material_history (
+		id serial primary key,
+		material_type text not null,
+		item_id int not null, -- id in the table of the type
+		user_id int not null references users(id),
+		"data" bytea not null,
+		nonce bytea not null,
+		replaced_at timestamptz not null default now()
+	);
+	CREATE INDEX IF NOT EXISTS material_history_item_idx ON material_history (material_type, item_id);
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id int not null references users(id),
+		key text not null, -- Idempotency-Key header of a create request
+		fingerprint text not null, -- sha256 of the request
+		material_type text not null,
+		item_id int not null, -- the item created by the first request
+		created_at timestamptz not null default now(),
+		primary key (user_id, key)
+	);
+	CREATE TABLE IF NOT EXISTS reserved_ids (
+		user_id int not null references users(id),
+		material_type text not null,
+		item_id int not null, -- taken from the sequence of the table of the type, the client seals the new item with it
+		created_at timestamptz not null default now(),
+		primary key (material_type, item_id)
 	);
 	CREATE TABLE IF NOT EXISTS blob_data (
 		id serial primary key,
 		user_id int not null references users(id),
+		metadata bytea not null,
+		nonce bytea not null,
+		size bigint not null default 0,
+		created_at timestamptz not null default now(),
+		deleted_at timestamptz -- set when the file is in the trash
+	);
+	CREATE TABLE IF NOT EXISTS blob_chunks (
+		blob_id int not null references blob_data(id) on delete cascade,
+		seq int not null,
 		"data" bytea not null,
-		metadata text
+		primary key (blob_id, seq)
+	);
+	CREATE TABLE IF NOT EXISTS blob_uploads (
+		id text primary key,
+		user_id int not null references users(id),
+		metadata bytea not null,
+		nonce bytea not null,
+		size bigint not null,
+		chunk_size int not null,
+		expires_at timestamptz not null
+	);
+	CREATE INDEX IF NOT EXISTS blob_uploads_user_id_idx ON blob_uploads (user_id);
+	CREATE TABLE IF NOT EXISTS upload_chunks (
+		upload_id text not null references blob_uploads(id) on delete cascade,
+		seq int not null,
+		"data" bytea not null,
+		primary key (upload_id, seq)
 	);
 	CREATE TABLE IF NOT EXISTS card_data (
 		id serial primary key,
 		user_id int not null references users(id),
-		card_number text not null unique,
-		exp_date timestamp not null, 
-		cvv text not null,
-		"name" text,
-		surname text,
-		metadata text
-	);`
-	_, err := db.Exec(query)
+		"data" bytea not null,
+		nonce bytea not null,
+		revision int not null default 1, -- incremented on every update
+		change_seq bigint not null default 0, -- users.change_seq of the last change
+		created_at timestamptz not null default now(),
+		updated_at timestamptz not null default now(),
+		deleted_at timestamptz -- set when the item is in the trash
+	);
+	CREATE INDEX IF NOT EXISTS card_data_change_seq_idx ON card_data (user_id, change_seq);
+	CREATE INDEX IF NOT EXISTS card_data_created_at_idx ON card_data (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS card_data_updated_at_idx ON card_data (user_id, updated_at, id);`
+	_, err := tx.Exec(query)
 	return err
 }
@@ -0,0 +1,308 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schemaLockID keeps concurrent server instances from migrating the database at the same time
+const schemaLockID = 7209432
+
+// migration upgrades the schema of an existing database by one version. A fresh database gets the current
+// schema from createTable at once, so every change of createTable needs a migration too.
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{version: 1, name: "seal materials on the client", up: sealMaterials},
+	// refresh tokens were kept as they are, the sessions are dropped instead of keeping live tokens
+	{version: 2, name: "keep digests of refresh tokens", up: execSQL(`DELETE FROM sessions;`)},
+	{version: 3, name: "refresh token families", up: execSQL(`
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS family_id text not null default '',
+		ADD COLUMN IF NOT EXISTS rotated_at timestamp;
+	ALTER TABLE sessions ALTER COLUMN family_id DROP DEFAULT;
+	CREATE INDEX IF NOT EXISTS sessions_family_id_idx ON sessions (family_id);
+	CREATE TABLE IF NOT EXISTS security_events (
+		id serial primary key,
+		user_id int not null references users(id),
+		kind text not null,
+		details text,
+		created_at timestamp not null
+	);`)},
+	{version: 4, name: "revoked access tokens", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS revoked_tokens (
+		token_id text primary key,
+		expired_at timestamp not null
+	);`)},
+	{version: 5, name: "session devices", up: execSQL(`
+	ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_name text not null default '',
+		ADD COLUMN IF NOT EXISTS user_agent text not null default '',
+		ADD COLUMN IF NOT EXISTS ip text not null default '',
+		ADD COLUMN IF NOT EXISTS created_at timestamp not null default now(),
+		ADD COLUMN IF NOT EXISTS last_used_at timestamp not null default now(),
+		ADD COLUMN IF NOT EXISTS access_token_id text not null default '',
+		ADD COLUMN IF NOT EXISTS access_expired_at timestamp not null default now();`)},
+	{version: 6, name: "totp second factor", up: execSQL(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text not null default '',
+		ADD COLUMN IF NOT EXISTS totp_enabled boolean not null default false,
+		ADD COLUMN IF NOT EXISTS totp_last_step bigint not null default 0;
+	CREATE TABLE IF NOT EXISTS sign_in_challenges (
+		token text primary key,
+		user_id int not null references users(id),
+		expired_at timestamp not null,
+		attempts int not null default 0
+	);`)},
+	{version: 7, name: "recovery codes", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		code text primary key,
+		user_id int not null references users(id)
+	);
+	CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes (user_id);`)},
+	{version: 8, name: "sign in throttling", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS login_attempts (
+		key text primary key,
+		failures int not null,
+		last_failed_at timestamptz not null
+	);`)},
+	{version: 9, name: "seal files on the client", up: sealFiles},
+	{version: 10, name: "resumable uploads", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS blob_uploads (
+		id text primary key,
+		user_id int not null references users(id),
+		metadata bytea not null,
+		nonce bytea not null,
+		size bigint not null,
+		chunk_size int not null,
+		expires_at timestamptz not null
+	);
+	CREATE TABLE IF NOT EXISTS upload_chunks (
+		upload_id text not null references blob_uploads(id) on delete cascade,
+		seq int not null,
+		"data" bytea not null,
+		primary key (upload_id, seq)
+	);`)},
+	{version: 11, name: "trash", up: execSQL(`
+	ALTER TABLE auth_data ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+	ALTER TABLE text_data ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+	ALTER TABLE card_data ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+	ALTER TABLE blob_data ADD COLUMN IF NOT EXISTS deleted_at timestamptz;`)},
+	{version: 12, name: "version history", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS material_history (
+		id serial primary key,
+		material_type text not null,
+		item_id int not null,
+		user_id int not null references users(id),
+		"data" bytea not null,
+		nonce bytea not null,
+		replaced_at timestamptz not null default now()
+	);
+	CREATE INDEX IF NOT EXISTS material_history_item_idx ON material_history (material_type, item_id);`)},
+	{version: 13, name: "revisions", up: execSQL(`
+	ALTER TABLE auth_data ADD COLUMN IF NOT EXISTS revision int not null default 1;
+	ALTER TABLE text_data ADD COLUMN IF NOT EXISTS revision int not null default 1;
+	ALTER TABLE card_data ADD COLUMN IF NOT EXISTS revision int not null default 1;`)},
+	{version: 14, name: "delta sync", up: execSQL(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS change_seq bigint not null default 0,
+		ADD COLUMN IF NOT EXISTS purged_seq bigint not null default 0;
+	ALTER TABLE auth_data ADD COLUMN IF NOT EXISTS change_seq bigint not null default 0;
+	ALTER TABLE text_data ADD COLUMN IF NOT EXISTS change_seq bigint not null default 0;
+	ALTER TABLE card_data ADD COLUMN IF NOT EXISTS change_seq bigint not null default 0;
+	CREATE INDEX IF NOT EXISTS auth_data_change_seq_idx ON auth_data (user_id, change_seq);
+	CREATE INDEX IF NOT EXISTS text_data_change_seq_idx ON text_data (user_id, change_seq);
+	CREATE INDEX IF NOT EXISTS card_data_change_seq_idx ON card_data (user_id, change_seq);`)},
+	{version: 15, name: "idempotency keys", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		user_id int not null references users(id),
+		key text not null,
+		fingerprint text not null,
+		material_type text not null,
+		item_id int not null,
+		created_at timestamptz not null default now(),
+		primary key (user_id, key)
+	);`)},
+	{version: 16, name: "item timestamps", up: execSQL(`
+	ALTER TABLE auth_data ADD COLUMN IF NOT EXISTS created_at timestamptz not null default now(),
+		ADD COLUMN IF NOT EXISTS updated_at timestamptz not null default now();
+	ALTER TABLE text_data ADD COLUMN IF NOT EXISTS created_at timestamptz not null default now(),
+		ADD COLUMN IF NOT EXISTS updated_at timestamptz not null default now();
+	ALTER TABLE card_data ADD COLUMN IF NOT EXISTS created_at timestamptz not null default now(),
+		ADD COLUMN IF NOT EXISTS updated_at timestamptz not null default now();`)},
+	{version: 17, name: "paging indexes", up: execSQL(`
+	CREATE INDEX IF NOT EXISTS auth_data_created_at_idx ON auth_data (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS auth_data_updated_at_idx ON auth_data (user_id, updated_at, id);
+	CREATE INDEX IF NOT EXISTS text_data_created_at_idx ON text_data (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS text_data_updated_at_idx ON text_data (user_id, updated_at, id);
+	CREATE INDEX IF NOT EXISTS card_data_created_at_idx ON card_data (user_id, created_at, id);
+	CREATE INDEX IF NOT EXISTS card_data_updated_at_idx ON card_data (user_id, updated_at, id);`)},
+	{version: 18, name: "reserved ids", up: execSQL(`
+	CREATE TABLE IF NOT EXISTS reserved_ids (
+		user_id int not null references users(id),
+		material_type text not null,
+		item_id int not null,
+		created_at timestamptz not null default now(),
+		primary key (material_type, item_id)
+	);`)},
+	{version: 19, name: "key check", up: execSQL(`
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS key_check bytea,
+		ADD COLUMN IF NOT EXISTS key_check_nonce bytea;`)},
+	{version: 20, name: "sessions purge", up: execSQL(`
+	CREATE INDEX IF NOT EXISTS sessions_expired_at_idx ON sessions (expired_at);`)},
+	// digests are unique per user only, the user_id index is covered by the key
+	{version: 21, name: "recovery codes per user", up: execSQL(`
+	ALTER TABLE recovery_codes DROP CONSTRAINT IF EXISTS recovery_codes_pkey;
+	ALTER TABLE recovery_codes ADD PRIMARY KEY (user_id, code);
+	DROP INDEX IF EXISTS recovery_codes_user_id_idx;`)},
+	{version: 22, name: "uploads per user", up: execSQL(`
+	CREATE INDEX IF NOT EXISTS blob_uploads_user_id_idx ON blob_uploads (user_id);`)},
+}
+
+// execSQL makes a migration of plain statements. Columns and tables are added with IF NOT EXISTS,
+// so databases created by releases between the versions are upgraded too.
+func execSQL(query string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// migrate brings the schema to the last version. Applied versions are kept in schema_migrations,
+// a database without it was created before the migrations and is at version 0.
+func migrate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1);", schemaLockID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version int primary key,
+		applied_at timestamptz not null default now()
+	);`); err != nil {
+		return err
+	}
+
+	var (
+		fresh   bool
+		version int
+	)
+	if err := tx.QueryRow("SELECT to_regclass('users') IS NULL;").Scan(&fresh); err != nil {
+		return err
+	}
+	if err := tx.QueryRow("SELECT coalesce(max(version), 0) FROM schema_migrations;").Scan(&version); err != nil {
+		return err
+	}
+
+	if fresh {
+		if err := createTable(tx); err != nil {
+			return err
+		}
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+
+		if !fresh {
+			if err := m.up(tx); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+		}
+
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ($1);", m.version); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// columnType returns the type of the column, it's empty if there is no such column.
+func columnType(tx *sql.Tx, table, column string) (string, error) {
+	var dataType string
+	err := tx.QueryRow("SELECT data_type FROM information_schema.columns WHERE table_schema = current_schema() and table_name = $1 and column_name = $2;",
+		table, column).Scan(&dataType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return dataType, err
+}
+
+// plaintextMaterialsError stops the upgrade of a database with items stored before they were sealed on the client.
+// The server doesn't have the keys to seal them, see docs/upgrade.md for the export and re-import.
+type plaintextMaterialsError struct {
+	Table string
+	Count int
+}
+
+func (e *plaintextMaterialsError) Error() string {
+	return fmt.Sprintf("%s has %d items stored in plaintext, the server can't seal them: export them with the previous release "+
+		"and re-import them with the client after the upgrade, see docs/upgrade.md", e.Table, e.Count)
+}
+
+// replacePlaintextColumns replaces the plaintext columns of the table with the sealed ones.
+// It's done for empty tables only, nothing is done if the table is sealed already.
+func replacePlaintextColumns(tx *sql.Tx, table, marker string, columns []string, sealed string) error {
+	dataType, err := columnType(tx, table, marker)
+	if err != nil || dataType != "text" {
+		return err
+	}
+
+	var count int
+	if err := tx.QueryRow(fmt.Sprintf("SELECT count(*) FROM %s;", table)).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return &plaintextMaterialsError{Table: table, Count: count}
+	}
+
+	for _, column := range columns {
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s DROP COLUMN IF EXISTS "%s";`, table, column)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s %s;", table, sealed))
+	return err
+}
+
+const sealedColumns = `ADD COLUMN "data" bytea not null, ADD COLUMN nonce bytea not null`
+
+func sealMaterials(tx *sql.Tx) error {
+	if err := replacePlaintextColumns(tx, "auth_data", "login", []string{"login", "password", "metadata"}, sealedColumns); err != nil {
+		return err
+	}
+
+	if err := replacePlaintextColumns(tx, "text_data", "data", []string{"data", "metadata"}, sealedColumns); err != nil {
+		return err
+	}
+
+	return replacePlaintextColumns(tx, "card_data", "card_number", []string{"card_number", "exp_date", "cvv", "name", "surname", "metadata"},
+		sealedColumns)
+}
+
+// sealFiles replaces plaintext files with the sealed metadata, the sealed content is kept in chunks.
+func sealFiles(tx *sql.Tx) error {
+	err := replacePlaintextColumns(tx, "blob_data", "metadata", []string{"data", "metadata"},
+		"ADD COLUMN metadata bytea not null, ADD COLUMN nonce bytea not null")
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`ALTER TABLE blob_data ADD COLUMN IF NOT EXISTS size bigint not null default 0,
+		ADD COLUMN IF NOT EXISTS created_at timestamptz not null default now();
+	CREATE TABLE IF NOT EXISTS blob_chunks (
+		blob_id int not null references blob_data(id) on delete cascade,
+		seq int not null,
+		"data" bytea not null,
+		primary key (blob_id, seq)
+	);`)
+	return err
+}
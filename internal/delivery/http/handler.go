@@ -9,18 +9,21 @@ import (
 	v2 "gophkeeper/internal/delivery/http/v2"
 	"gophkeeper/internal/service"
 	"gophkeeper/pkg/auth"
+	"net"
 	"net/http"
 )
 
 type Handler struct {
-	services     *service.Services
-	tokenManager auth.TokenManager
+	services       *service.Services
+	tokenManager   auth.TokenManager
+	trustedProxies []*net.IPNet
 }
 
-func NewHandler(services *service.Services, tokenManager auth.TokenManager) *Handler {
+func NewHandler(services *service.Services, tokenManager auth.TokenManager, trustedProxies []*net.IPNet) *Handler {
 	return &Handler{
-		services:     services,
-		tokenManager: tokenManager,
+		services:       services,
+		tokenManager:   tokenManager,
+		trustedProxies: trustedProxies,
 	}
 }
 
@@ -54,6 +57,7 @@ func (h *Handler) initAPI(router chi.Router) {
 //**********************************************************************************************************************
 func (h *Handler) InitEcho() http.Handler {
 	e := echo.New()
+	e.IPExtractor = h.ipExtractor()
 	e.Use(middleware.Logger())
 	//e.Use(middleware.Recover())
 	//e.Use(middleware.Gzip())
@@ -63,11 +67,32 @@ func (h *Handler) InitEcho() http.Handler {
 		return c.String(http.StatusOK, "pong")
 	})
 
+	// public keys for the third party services to verify access tokens
+	if keySetProvider, ok := h.tokenManager.(auth.KeySetProvider); ok {
+		e.GET("/.well-known/jwks.json", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, keySetProvider.JWKS())
+		})
+	}
+
 	h.initAPIEcho(e)
 
 	return e
 }
 
+// ipExtractor takes the client ip from X-Forwarded-For set by the trusted proxies only. Otherwise clients
+// could pick any ip to get around sign in throttling or to show in the sessions list.
+func (h *Handler) ipExtractor() echo.IPExtractor {
+	if len(h.trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, ipRange := range h.trustedProxies {
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 func (h *Handler) initAPIEcho(e *echo.Echo) {
 	api := e.Group("/api")
 	handlerV2 := v2.NewHandler(h.services, h.tokenManager)
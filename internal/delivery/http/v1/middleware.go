@@ -29,13 +29,13 @@ func (h *Handler) checkUserIdentity(next http.Handler) http.Handler {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		} else { //cookie found
-			userID, err := h.tokenManager.Parse(tokenCookie.Value)
+			claims, err := h.tokenManager.Parse(tokenCookie.Value)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
 
-			userIDInt, err := strconv.Atoi(userID)
+			userIDInt, err := strconv.Atoi(claims.UserID)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
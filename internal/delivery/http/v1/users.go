@@ -96,7 +96,7 @@ func (h *Handler)userSignIn() http.HandlerFunc {
 		})
 
 		if err != nil {
-			if errors.Is(err, domain.ErrUserNotFound) {
+			if errors.Is(err, domain.ErrWrongLoginOrPassword) {
 				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
@@ -127,7 +127,10 @@ func (h *Handler)userRefresh() http.HandlerFunc {
 			return
 		}
 
-		res, err := h.services.Users.RefreshTokens(r.Context(), inp.Token)
+		res, err := h.services.Users.RefreshTokens(r.Context(), inp.Token, domain.SessionClient{
+			UserAgent: r.UserAgent(),
+			IP:        r.RemoteAddr,
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -0,0 +1,152 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// sealed metadata is small, the limit keeps a multipart part from being read into memory endlessly
+const maxBlobMetadataSize = 64 * 1024
+
+type blobMetadataInput struct {
+	Metadata []byte `json:"metadata"`
+	Nonce    []byte `json:"nonce"`
+}
+
+func blobID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "bad blob id")
+	}
+	return id, nil
+}
+
+func (h Handler) getAllBlobs(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	blobs, err := h.services.Materials.GetAllBlobs(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if len(blobs) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, blobs)
+}
+
+// uploadBlob takes a multipart stream: "metadata" part with blobMetadataInput json goes first,
+// then "file" part with the sealed content. The content is passed to the storage without buffering.
+func (h Handler) uploadBlob(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	part, err := reader.NextPart()
+	if err != nil || part.FormName() != "metadata" {
+		return echo.NewHTTPError(http.StatusBadRequest, "metadata part is expected first")
+	}
+
+	var inp blobMetadataInput
+	if err := json.NewDecoder(io.LimitReader(part, maxBlobMetadataSize)).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	part, err = reader.NextPart()
+	if err != nil || part.FormName() != "file" {
+		return echo.NewHTTPError(http.StatusBadRequest, "file part is expected after metadata")
+	}
+
+	blob, err := h.services.Materials.CreateBlob(c.Request().Context(), userID, domain.BlobData{
+		Metadata: inp.Metadata,
+		Nonce:    inp.Nonce,
+	}, part)
+	if err != nil {
+		if errors.Is(err, domain.ErrBlobTooLarge) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, blob)
+}
+
+// downloadBlob streams the sealed content, it's opened on the client side.
+func (h Handler) downloadBlob(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	id, err := blobID(c)
+	if err != nil {
+		return err
+	}
+
+	blob, err := h.services.Materials.GetBlob(c.Request().Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
+	c.Response().Header().Set(echo.HeaderContentLength, strconv.FormatInt(blob.Size, 10))
+	c.Response().WriteHeader(http.StatusOK)
+
+	// the status is already sent, so the failed download is recognized by the short body only
+	return h.services.Materials.ReadBlob(c.Request().Context(), userID, id, c.Response())
+}
+
+func (h Handler) updateBlobMetadata(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	id, err := blobID(c)
+	if err != nil {
+		return err
+	}
+
+	var inp blobMetadataInput
+	if err := json.NewDecoder(io.LimitReader(c.Request().Body, maxBlobMetadataSize)).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	err = h.services.Materials.UpdateBlobMetadata(c.Request().Context(), userID, domain.BlobData{
+		ID:       id,
+		Metadata: inp.Metadata,
+		Nonce:    inp.Nonce,
+	})
+	if err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h Handler) deleteBlob(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	id, err := blobID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.services.Materials.DeleteBlob(c.Request().Context(), userID, id); err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
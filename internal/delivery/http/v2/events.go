@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/auth"
+	"net/http"
+	"time"
+)
+
+// comments are sent in the silence, so proxies don't drop the connection, and the token is checked again
+const eventsHeartbeat = 25 * time.Second
+
+func (h *Handler) initEventsRoutes(gr *echo.Group) {
+	eventsGr := gr.Group("/events", h.checkUserIdentity)
+	eventsGr.GET("", h.streamEvents)
+}
+
+// streamEvents pushes the user's material events as server-sent events. The stream ends
+// when the access token expires or is revoked, the client reconnects with a fresh one.
+func (h Handler) streamEvents(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+	claims := c.Get(TokenClaimsCtxName.String()).(auth.Claims)
+	ctx := c.Request().Context()
+
+	events, unsubscribe := h.services.Events.Subscribe(userID)
+	defer unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	if err := writeEvent(w, domain.MaterialEvent{Kind: domain.EventReady}); err != nil {
+		return nil
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	expired := time.NewTimer(time.Until(claims.ExpiresAt))
+	defer expired.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-expired.C:
+			return nil
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := writeEvent(w, event); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			revoked, err := h.services.Users.IsTokenRevoked(ctx, claims.TokenID)
+			if err != nil || revoked {
+				return nil
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return nil
+			}
+			w.Flush()
+		}
+	}
+}
+
+func writeEvent(w *echo.Response, event domain.MaterialEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Kind, data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -21,4 +21,6 @@ func NewHandler(services *service.Services, tokenManager auth.TokenManager) *Han
 func (h *Handler) Init(g *echo.Group) {
 	h.initUserRoutes(g)
 	h.initMaterialsRoutes(g)
+	h.initSyncRoutes(g)
+	h.initEventsRoutes(g)
 }
\ No newline at end of file
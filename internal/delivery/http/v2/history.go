@@ -0,0 +1,66 @@
+package v2
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"net/http"
+	"strconv"
+)
+
+func (h Handler) getHistory(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	t, err := materialType(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad id")
+	}
+
+	versions, err := h.services.Materials.GetHistory(c.Request().Context(), userID, t, id)
+	if err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if len(versions) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, versions)
+}
+
+func (h Handler) restoreVersion(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	t, err := materialType(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad id")
+	}
+
+	versionID, err := strconv.Atoi(c.Param("version"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad version")
+	}
+
+	err = h.services.Materials.RestoreVersion(c.Request().Context(), userID, t, id, versionID)
+	if err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) || errors.Is(err, domain.ErrVersionNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,52 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"net/http"
+)
+
+type keyCheckInput struct {
+	Data  []byte `json:"data"`
+	Nonce []byte `json:"nonce"`
+}
+
+// the client opens the value to tell a wrong master password
+func (h Handler) getKeyCheck(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	keyCheck, err := h.services.Users.GetKeyCheck(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyCheckNotFound) {
+			return c.NoContent(http.StatusNoContent)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, keyCheckInput{Data: keyCheck.Data, Nonce: keyCheck.Nonce})
+}
+
+// the value is saved once by the first client unlocking the vault
+func (h Handler) setKeyCheck(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	var inp keyCheckInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(inp.Data) == 0 || len(inp.Nonce) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "data and nonce are required")
+	}
+
+	err := h.services.Users.SetKeyCheck(c.Request().Context(), userID, domain.SealedData{Data: inp.Data, Nonce: inp.Nonce})
+	if err != nil {
+		if errors.Is(err, domain.ErrKeyCheckAlreadyExists) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusCreated)
+}
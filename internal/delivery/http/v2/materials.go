@@ -3,36 +3,70 @@ package v2
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"gophkeeper/internal/domain"
 	"net/http"
-	"time"
+	"net/url"
+	"strconv"
+	"strings"
 )
 
+// All materials are sealed on the client side, so the handlers work with opaque payloads
+// and differ only in the material type taken from the route.
 func (h *Handler) initMaterialsRoutes(gr *echo.Group) {
 	materialsGr := gr.Group("/materials")
 
 	authGr := materialsGr.Group("", h.checkUserIdentity)
-	authGr.GET("/text", h.getAllTextData)
-	authGr.POST("/text", h.updateTextDataByID)
-	authGr.PUT("/text", h.createNewTextData)
-
-	authGr.GET("/card", h.getAllCardData)
-	authGr.POST("/card", h.updateCardDataByID)
-	authGr.PUT("/card", h.createNewCardData)
+	// files are streamed, so they have their own routes, which take priority over "/:type"
+	authGr.GET("/blob", h.getAllBlobs)
+	authGr.PUT("/blob", h.uploadBlob)
+	authGr.GET("/blob/:id", h.downloadBlob)
+	authGr.POST("/blob/:id", h.updateBlobMetadata)
+	authGr.DELETE("/blob/:id", h.deleteBlob)
+	h.initUploadsRoutes(authGr)
+
+	authGr.GET("/trash", h.getTrash)
+	authGr.POST("/trash/:id/restore", h.restoreFromTrash)
+
+	authGr.GET("/:type", h.getAllData)
+	authGr.POST("/:type", h.updateDataByID)
+	authGr.PUT("/:type", h.createNewData)
+	authGr.POST("/:type/ids", h.reserveID)
+	authGr.GET("/:type/:id", h.getDataByID)
+	authGr.DELETE("/:type/:id", h.deleteDataByID)
+	authGr.GET("/:type/:id/history", h.getHistory)
+	authGr.POST("/:type/:id/history/:version/restore", h.restoreVersion)
+}
 
-	authGr.GET("/cred", h.getAllCredData)
-	authGr.POST("/cred", h.updateCredDataByID)
-	authGr.PUT("/cred", h.createNewCredData)
+func materialType(c echo.Context) (domain.MaterialType, error) {
+	t := domain.MaterialType(c.Param("type"))
+	switch t {
+	case domain.MaterialText, domain.MaterialCard, domain.MaterialCred:
+		return t, nil
+	default:
+		return "", echo.NewHTTPError(http.StatusNotFound, domain.ErrUnknownMaterialType.Error())
+	}
 }
 
-//**********************************************************************************************************************
-// Text
-//**********************************************************************************************************************
-func (h Handler) getAllTextData(c echo.Context) error {
+// pageParams are the query params of a page, the whole list is returned without them.
+var pageParams = []string{"limit", "cursor", "sort", "order"}
+
+func (h Handler) getAllData(c echo.Context) error {
 	userID := c.Get(UserIDCtxName.String()).(int)
 
-	dataArray, err := h.services.Materials.GetAllTextData(c.Request().Context(), userID)
+	t, err := materialType(c)
+	if err != nil {
+		return err
+	}
+
+	for _, param := range pageParams {
+		if c.QueryParams().Has(param) {
+			return h.getDataPage(c, userID, t)
+		}
+	}
+
+	dataArray, err := h.services.Materials.GetAll(c.Request().Context(), userID, t)
 	if err != nil {
 		switch {
 		case errors.Is(err, domain.ErrDataNotFound):
@@ -45,173 +79,231 @@ func (h Handler) getAllTextData(c echo.Context) error {
 	return c.JSON(http.StatusOK, dataArray)
 }
 
-func (h Handler) updateTextDataByID(c echo.Context) error {
-	userID := c.Get(UserIDCtxName.String()).(int)
-
-	var inp domain.TextData
-	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+// getDataPage returns a page of items. The link to the next page is given in Link header, there is none on the last page.
+func (h Handler) getDataPage(c echo.Context, userID int, t domain.MaterialType) error {
+	opts := domain.ListOptions{
+		Sort:   domain.ListSort(c.QueryParam("sort")),
+		Cursor: c.QueryParam("cursor"),
 	}
 
-	err := h.services.Materials.UpdateTextDataByID(c.Request().Context(), userID, inp)
+	var verr domain.ValidationError
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			verr.Add("limit", "must be a number")
+		}
+		opts.Limit = n
+	}
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		verr.Add("order", "must be asc or desc")
+	}
+	if len(verr.Fields) > 0 {
+		return validationHTTPError(&verr)
+	}
 
+	page, err := h.services.Materials.GetPage(c.Request().Context(), userID, t, opts)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		var verr *domain.ValidationError
+		switch {
+		case errors.As(err, &verr):
+			return validationHTTPError(verr)
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if page.NextCursor != "" {
+		query := c.QueryParams()
+		query.Set("cursor", page.NextCursor)
+		next := url.URL{Path: c.Request().URL.Path, RawQuery: query.Encode()}
+		c.Response().Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
 	}
-	return c.NoContent(http.StatusOK)
-}
 
-type newTextDataInput struct {
-	Text     string `json:"text"`
-	Metadata string `json:"metadata"`
+	return c.JSON(http.StatusOK, page.Items)
 }
 
-func (h Handler) createNewTextData(c echo.Context) error {
+func (h Handler) getDataByID(c echo.Context) error {
 	userID := c.Get(UserIDCtxName.String()).(int)
 
-	var inp newTextDataInput
-	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	t, err := materialType(c)
+	if err != nil {
+		return err
 	}
 
-	err := h.services.Materials.CreateNewTextData(c.Request().Context(), userID, domain.TextData{
-		ID:       -1, //this field fill be ignored
-		Text:     inp.Text,
-		Metadata: inp.Metadata,
-	})
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad id")
+	}
 
+	data, err := h.services.Materials.GetByID(c.Request().Context(), userID, t, id)
 	if err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
-	return c.NoContent(http.StatusOK)
-}
 
-//**********************************************************************************************************************
-// Credit card
-//**********************************************************************************************************************
-type newCardDataInput struct {
-	CardNumber string    `json:"card_number"`
-	ExpDate    time.Time `json:"exp_data"`
-	CVV        string    `json:"cvv"`
-	Name       string    `json:"name"`
-	Surname    string    `json:"surname"`
-	Metadata   string    `json:"metadata"`
+	c.Response().Header().Set("ETag", revisionETag(data.Revision))
+	return c.JSON(http.StatusOK, data)
 }
 
-func (h Handler) getAllCardData(c echo.Context) error {
+func (h Handler) updateDataByID(c echo.Context) error {
 	userID := c.Get(UserIDCtxName.String()).(int)
 
-	dataArray, err := h.services.Materials.GetAllCardData(c.Request().Context(), userID)
+	t, err := materialType(c)
 	if err != nil {
+		return err
+	}
+
+	revision, err := ifMatchRevision(c)
+	if err != nil {
+		return err
+	}
+
+	var inp domain.SealedData
+	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	inp.Revision = revision
+
+	revision, err = h.services.Materials.UpdateByID(c.Request().Context(), userID, t, inp)
+	if err != nil {
+		var conflict *domain.RevisionConflictError
 		switch {
+		case errors.As(err, &conflict):
+			// the current version lets the client merge the changes or overwrite them knowingly
+			c.Response().Header().Set("ETag", revisionETag(conflict.Current.Revision))
+			return c.JSON(http.StatusConflict, conflict.Current)
 		case errors.Is(err, domain.ErrDataNotFound):
-			return c.NoContent(http.StatusNoContent)
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
 		default:
-			return echo.NewHTTPError(http.StatusInternalServerError)
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
 	}
 
-	return c.JSON(http.StatusOK, dataArray)
+	c.Response().Header().Set("ETag", revisionETag(revision))
+	return c.NoContent(http.StatusOK)
 }
 
-func (h Handler) updateCardDataByID(c echo.Context) error {
-	userID := c.Get(UserIDCtxName.String()).(int)
+func revisionETag(revision int) string {
+	return `"` + strconv.Itoa(revision) + `"`
+}
 
-	var inp domain.CardData
-	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+// ifMatchRevision takes the revision the update is based on from If-Match header.
+// "*" matches any revision, 0 is returned for it.
+func ifMatchRevision(c echo.Context) (int, error) {
+	header := strings.TrimSpace(c.Request().Header.Get("If-Match"))
+	if header == "" {
+		return 0, echo.NewHTTPError(http.StatusPreconditionRequired, "If-Match header with the revision is required")
 	}
 
-	err := h.services.Materials.UpdateCardDataByID(c.Request().Context(), userID, inp)
+	if header == "*" {
+		return 0, nil
+	}
 
-	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	revision, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(header, `"`), `"`))
+	if err != nil || revision <= 0 || len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "If-Match header must be a single revision ETag like \"3\"")
 	}
-	return c.NoContent(http.StatusOK)
+
+	return revision, nil
 }
 
-func (h Handler) createNewCardData(c echo.Context) error {
+const maxIdempotencyKeyLength = 255
+
+type reservedIDResponse struct {
+	ID int `json:"id"`
+}
+
+// reserveID gives an id for a new item, items are sealed with their id.
+func (h Handler) reserveID(c echo.Context) error {
 	userID := c.Get(UserIDCtxName.String()).(int)
 
-	var inp newCardDataInput
-	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	t, err := materialType(c)
+	if err != nil {
+		return err
 	}
 
-	err := h.services.Materials.CreateNewCardData(c.Request().Context(), userID, domain.CardData{
-		ID:         -1, //this field fill be ignored
-		CardNumber: inp.CardNumber,
-		ExpDate:    inp.ExpDate,
-		CVV:        inp.CVV,
-		Name:       inp.Name,
-		Surname:    inp.Surname,
-		Metadata:   inp.Metadata,
-	})
-
+	id, err := h.services.Materials.ReserveID(c.Request().Context(), userID, t)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
-	return c.NoContent(http.StatusOK)
+
+	return c.JSON(http.StatusCreated, reservedIDResponse{ID: id})
 }
 
-//**********************************************************************************************************************
-// Creds
-//**********************************************************************************************************************
-func (h Handler) getAllCredData(c echo.Context) error {
+type newDataInput struct {
+	ID    int    `json:"id"` // reserved by reserveID
+	Data  []byte `json:"data"`
+	Nonce []byte `json:"nonce"`
+}
+
+func (h Handler) createNewData(c echo.Context) error {
 	userID := c.Get(UserIDCtxName.String()).(int)
 
-	dataArray, err := h.services.Materials.GetAllCredData(c.Request().Context(), userID)
+	t, err := materialType(c)
 	if err != nil {
-		switch {
-		case errors.Is(err, domain.ErrDataNotFound):
-			return c.NoContent(http.StatusNoContent)
-		default:
-			return echo.NewHTTPError(http.StatusInternalServerError)
-		}
+		return err
 	}
 
-	return c.JSON(http.StatusOK, dataArray)
-}
-
-func (h Handler) updateCredDataByID(c echo.Context) error {
-	userID := c.Get(UserIDCtxName.String()).(int)
+	// a retry with the key doesn't create the item again
+	key := c.Request().Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Idempotency-Key header must be at most %d characters", maxIdempotencyKeyLength))
+	}
 
-	var inp domain.CredData
+	var inp newDataInput
 	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	err := h.services.Materials.UpdateCredDataByID(c.Request().Context(), userID, inp)
+	created, err := h.services.Materials.Create(c.Request().Context(), userID, t, domain.SealedData{
+		ID:    inp.ID,
+		Data:  inp.Data,
+		Nonce: inp.Nonce,
+	}, key)
 
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		switch {
+		case errors.Is(err, domain.ErrIdempotencyKeyReused):
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		case errors.Is(err, domain.ErrIDNotReserved):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case errors.Is(err, domain.ErrCreatedItemPurged):
+			return echo.NewHTTPError(http.StatusGone, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
 	}
-	return c.NoContent(http.StatusOK)
-}
 
-type newCredDataInput struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	Metadata string `json:"metadata"`
+	c.Response().Header().Set("ETag", revisionETag(created.Revision))
+	return c.JSON(http.StatusCreated, created)
 }
 
-func (h Handler) createNewCredData(c echo.Context) error {
+func (h Handler) deleteDataByID(c echo.Context) error {
 	userID := c.Get(UserIDCtxName.String()).(int)
 
-	var inp newCredDataInput
-	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	t, err := materialType(c)
+	if err != nil {
+		return err
 	}
 
-	err := h.services.Materials.CreateNewCredData(c.Request().Context(), userID, domain.CredData{
-		ID:       -1, //this field fill be ignored
-		Login:    inp.Login,
-		Password: inp.Password,
-		Metadata: inp.Metadata,
-	})
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad id")
+	}
 
+	err = h.services.Materials.DeleteByID(c.Request().Context(), userID, t, id)
 	if err != nil {
+		if errors.Is(err, domain.ErrDataNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+
 	return c.NoContent(http.StatusOK)
 }
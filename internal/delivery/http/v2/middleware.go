@@ -14,11 +14,12 @@ func (c CookieConst) String() string {
 }
 
 var (
-	UserIDCtxName CookieConst = "UserID"
+	UserIDCtxName      CookieConst = "UserID"
+	TokenClaimsCtxName CookieConst = "TokenClaims"
 )
 
 //**********************************************************************************************************************
-//checks that user is authorised and puts his id into context
+//checks that user is authorised and his token isn't revoked, puts his id and token claims into context
 func (h *Handler) checkUserIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		var token string
@@ -35,19 +36,26 @@ func (h *Handler) checkUserIdentity(next echo.HandlerFunc) echo.HandlerFunc {
 			token = tokenCookie.Value
 		}
 
-		//fmt.Println("TOKEN: "+ token)
-
-		userID, err := h.tokenManager.Parse(token)
+		claims, err := h.tokenManager.Parse(token)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusUnauthorized, "Please, provide valid credentials. "+err.Error())
 		}
 
-		userIDInt, err := strconv.Atoi(userID)
+		revoked, err := h.services.Users.IsTokenRevoked(c.Request().Context(), claims.TokenID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		if revoked {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Please, provide valid credentials. Token was revoked")
+		}
+
+		userIDInt, err := strconv.Atoi(claims.UserID)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
 
 		c.Set(UserIDCtxName.String(), userIDInt)
+		c.Set(TokenClaimsCtxName.String(), claims)
 
 		return next(c)
 	}
@@ -0,0 +1,44 @@
+package v2
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/auth"
+	"net/http"
+)
+
+func sessionClient(c echo.Context, deviceName string) domain.SessionClient {
+	return domain.SessionClient{
+		DeviceName: deviceName,
+		UserAgent:  c.Request().UserAgent(),
+		IP:         c.RealIP(),
+	}
+}
+
+//lists active sessions of the user
+func (h Handler) getSessions(c echo.Context) error {
+	claims := c.Get(TokenClaimsCtxName.String()).(auth.Claims)
+
+	sessions, err := h.services.Users.GetSessions(c.Request().Context(), claims)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, sessions)
+}
+
+//terminates any session of the user, e.g. on a lost device
+func (h Handler) terminateSession(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	err := h.services.Users.TerminateSession(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, domain.ErrSessionNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusOK)
+}
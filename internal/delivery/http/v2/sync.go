@@ -0,0 +1,33 @@
+package v2
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+	"strconv"
+)
+
+func (h *Handler) initSyncRoutes(gr *echo.Group) {
+	syncGr := gr.Group("/sync", h.checkUserIdentity)
+	syncGr.GET("", h.getChanges)
+}
+
+// getChanges returns text, card and cred items changed after the "since" cursor.
+// Without the cursor the whole vault is returned.
+func (h Handler) getChanges(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	var since int64
+	if s := c.QueryParam("since"); s != "" {
+		var err error
+		if since, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "bad cursor")
+		}
+	}
+
+	changes, err := h.services.Materials.GetChanges(c.Request().Context(), userID, since)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, changes)
+}
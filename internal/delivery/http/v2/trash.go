@@ -0,0 +1,41 @@
+package v2
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"net/http"
+)
+
+func (h Handler) getTrash(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	items, err := h.services.Materials.GetTrash(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	if len(items) == 0 {
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h Handler) restoreFromTrash(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	err := h.services.Materials.RestoreFromTrash(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInvalidInput):
+			return echo.NewHTTPError(http.StatusBadRequest, "bad trash item id")
+		case errors.Is(err, domain.ErrDataNotFound), errors.Is(err, domain.ErrUnknownMaterialType):
+			return echo.NewHTTPError(http.StatusNotFound, domain.ErrDataNotFound.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,130 @@
+package v2
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"gophkeeper/internal/service"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+type signInCodeInput struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+	DeviceName     string `json:"device_name" binding:"max=64"`
+}
+
+var signInCodeValidator = mustValidator[signInCodeInput]()
+
+//second step of sign in for users with the second factor enabled
+func (h Handler) userSignInWithCode(c echo.Context) error {
+	var inp signInCodeInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if verr := signInCodeValidator.validate(&inp); verr != nil {
+		return validationHTTPError(verr)
+	}
+
+	tokens, err := h.services.Users.SignInWithCode(c.Request().Context(), service.UserSignInCodeInput{
+		ChallengeToken: inp.ChallengeToken,
+		Code:           inp.Code,
+		Client:         sessionClient(c, inp.DeviceName),
+	})
+
+	if err != nil {
+		var throttled *domain.ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, domain.ErrChallengeNotFound) || errors.Is(err, domain.ErrBadSecondFactorCode):
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	h.setRefreshTokenCookie(c, tokens.RefreshToken)
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+//starts TOTP enrolment, the secret should be confirmed with the first code
+func (h Handler) enrollTOTP(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	enrollment, err := h.services.Users.EnrollTOTP(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrTOTPAlreadyEnabled) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+type confirmTOTPInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+var confirmTOTPValidator = mustValidator[confirmTOTPInput]()
+
+func (h Handler) confirmTOTP(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	var inp confirmTOTPInput
+	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if verr := confirmTOTPValidator.validate(&inp); verr != nil {
+		return validationHTTPError(verr)
+	}
+
+	codes, err := h.services.Users.ConfirmTOTP(c.Request().Context(), userID, inp.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrBadSecondFactorCode):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		case errors.Is(err, domain.ErrTOTPNotEnrolled), errors.Is(err, domain.ErrTOTPAlreadyEnabled):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, codes)
+}
+
+//replaces recovery codes, the old ones stop working
+func (h Handler) regenerateRecoveryCodes(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	codes, err := h.services.Users.RegenerateRecoveryCodes(c.Request().Context(), userID)
+	if err != nil {
+		if errors.Is(err, domain.ErrTOTPNotEnabled) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, codes)
+}
+
+func (h Handler) getRecoveryCodesStatus(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	status, err := h.services.Users.GetRecoveryCodesStatus(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
@@ -0,0 +1,150 @@
+package v2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"gophkeeper/internal/service"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Resumable uploads: the client creates an upload, puts numbered chunks (in any order and as many
+// times as needed), asks which chunks were received and finalizes the upload into a blob.
+func (h *Handler) initUploadsRoutes(gr *echo.Group) {
+	gr.POST("/blob/uploads", h.createUpload)
+	gr.GET("/blob/uploads/:id", h.getUpload)
+	gr.DELETE("/blob/uploads/:id", h.abortUpload)
+	gr.PUT("/blob/uploads/:id/chunks/:seq", h.putUploadChunk)
+	gr.POST("/blob/uploads/:id/finalize", h.finalizeUpload)
+}
+
+type createUploadInput struct {
+	Metadata  []byte `json:"metadata"`
+	Nonce     []byte `json:"nonce"`
+	Size      int64  `json:"size"`
+	ChunkSize int    `json:"chunk_size"`
+}
+
+func (h Handler) createUpload(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	var inp createUploadInput
+	if err := json.NewDecoder(io.LimitReader(c.Request().Body, maxBlobMetadataSize)).Decode(&inp); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	upload, err := h.services.Materials.CreateUpload(c.Request().Context(), userID, domain.BlobUpload{
+		Metadata:  inp.Metadata,
+		Nonce:     inp.Nonce,
+		Size:      inp.Size,
+		ChunkSize: inp.ChunkSize,
+	})
+	if err != nil {
+		var verr *domain.ValidationError
+		switch {
+		case errors.As(err, &verr):
+			return validationHTTPError(verr)
+		case errors.Is(err, domain.ErrBlobTooLarge):
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, domain.ErrTooManyUploads):
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusCreated, upload)
+}
+
+func (h Handler) getUpload(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	upload, err := h.services.Materials.GetUpload(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		return uploadHTTPError(err)
+	}
+
+	return c.JSON(http.StatusOK, upload)
+}
+
+// contentDigest returns sha-256 digest from RFC 9530 Content-Digest header: sha-256=:<base64>:
+func contentDigest(c echo.Context) ([]byte, error) {
+	for _, field := range strings.Split(c.Request().Header.Get("Content-Digest"), ",") {
+		alg, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || alg != "sha-256" {
+			continue
+		}
+
+		value = strings.TrimSuffix(strings.TrimPrefix(value, ":"), ":")
+		return base64.StdEncoding.DecodeString(value)
+	}
+	return nil, errors.New("Content-Digest header with sha-256 digest is required")
+}
+
+func (h Handler) putUploadChunk(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	seq, err := strconv.Atoi(c.Param("seq"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "bad chunk number")
+	}
+
+	digest, err := contentDigest(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request().Body, service.MaxUploadChunkLen+1))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if len(data) > service.MaxUploadChunkLen {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, domain.ErrBadChunk.Error())
+	}
+
+	err = h.services.Materials.PutUploadChunk(c.Request().Context(), userID, c.Param("id"), seq, data, digest)
+	if err != nil {
+		return uploadHTTPError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (h Handler) finalizeUpload(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	blob, err := h.services.Materials.FinalizeUpload(c.Request().Context(), userID, c.Param("id"))
+	if err != nil {
+		return uploadHTTPError(err)
+	}
+
+	return c.JSON(http.StatusCreated, blob)
+}
+
+func (h Handler) abortUpload(c echo.Context) error {
+	userID := c.Get(UserIDCtxName.String()).(int)
+
+	if err := h.services.Materials.AbortUpload(c.Request().Context(), userID, c.Param("id")); err != nil {
+		return uploadHTTPError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func uploadHTTPError(err error) error {
+	switch {
+	case errors.Is(err, domain.ErrUploadNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, domain.ErrBadChunk):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, domain.ErrUploadIncomplete):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"github.com/labstack/echo/v4"
 	"gophkeeper/internal/domain"
 	"gophkeeper/internal/service"
+	"gophkeeper/pkg/auth"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -18,22 +21,41 @@ func (h *Handler) initUserRoutes(gr *echo.Group) {
 	//TODO use echo jwt tokens
 	userGr.POST("/auth/sign-up", h.userSignUp)
 	userGr.POST("/auth/sign-in", h.userSignIn)
+	userGr.POST("/auth/sign-in/2fa", h.userSignInWithCode)
 	userGr.POST("/auth/refresh", h.userRefresh)
+
+	authGr := userGr.Group("", h.checkUserIdentity)
+	authGr.POST("/auth/logout", h.userLogout)
+	authGr.POST("/auth/logout-all", h.userLogoutAll)
+	authGr.GET("/sessions", h.getSessions)
+	authGr.DELETE("/sessions/:id", h.terminateSession)
+	authGr.POST("/2fa/totp/enroll", h.enrollTOTP)
+	authGr.POST("/2fa/totp/confirm", h.confirmTOTP)
+	authGr.GET("/2fa/recovery-codes", h.getRecoveryCodesStatus)
+	authGr.POST("/2fa/recovery-codes", h.regenerateRecoveryCodes)
+	authGr.GET("/key-check", h.getKeyCheck)
+	authGr.PUT("/key-check", h.setKeyCheck)
 }
 
-//TODO tags
-type signInInput struct {
-	Login    string `json:"login" binding:"required,max=64"`
-	Password string `json:"password" binding:"required,min=8,max=64"`
+type signUpInput struct {
+	Login      string `json:"login" binding:"required,max=64"`
+	Password   string `json:"password" binding:"required"` // the length is checked by the password policy
+	DeviceName string `json:"device_name" binding:"max=64"`
 }
 
+var signUpValidator = mustValidator[signUpInput]()
+
 //user registration
 func (h Handler) userSignUp(c echo.Context) error {
-	var inp signInInput
+	var inp signUpInput
 	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	if verr := signUpValidator.validate(&inp); verr != nil {
+		return validationHTTPError(verr)
+	}
+
 	//registration
 	err := h.services.Users.SignUp(c.Request().Context(), service.UserSignUpInput {
 		Login:    inp.Login,
@@ -41,80 +63,102 @@ func (h Handler) userSignUp(c echo.Context) error {
 	})
 
 	if err != nil {
-		if errors.Is(err, domain.ErrUserAlreadyExists) {
+		var verr *domain.ValidationError
+		switch {
+		case errors.As(err, &verr):
+			return validationHTTPError(verr)
+		case errors.Is(err, domain.ErrUserAlreadyExists):
 			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
 	//log in
 	tokens, err := h.services.Users.SignIn(c.Request().Context(), service.UserSignInInput {
 		Login:    inp.Login,
 		Password: inp.Password,
+		Client:   sessionClient(c, inp.DeviceName),
 	})
 
+	//the account is created anyway, the client signs in later
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		var throttled *domain.ThrottledError
+		if errors.As(err, &throttled) {
+			retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		return c.NoContent(http.StatusCreated)
 	}
 
-	c.SetCookie(&http.Cookie{
-		Name: "RefreshToken", 						  //TODO make constant
-		Value: tokens.RefreshToken,
-		Path:  "/api/user/auth/refresh", 				  //TODO construct it somehow automatically (routers may change)
-		Domain: "",									  //TODO set domen from configuration
-		Expires: time.Now().Add(40 * 24 * time.Hour), //TODO Expire time for RefreshToken from config
-	})
+	if tokens.ChallengeToken != "" {
+		return c.JSON(http.StatusAccepted, tokens)
+	}
+
+	h.setRefreshTokenCookie(c, tokens.RefreshToken)
 
 	return c.JSON(http.StatusOK, tokens)
 }
 
-type signUpInput struct {
-	Login    string `json:"login" binding:"required,max=64"`
-	Password string `json:"password" binding:"required,min=8,max=64"`
+// the password policy applies to new passwords only, so older ones are not checked against min length
+type signInInput struct {
+	Login      string `json:"login" binding:"required,max=64"`
+	Password   string `json:"password" binding:"required,max=64"`
+	DeviceName string `json:"device_name" binding:"max=64"`
 }
 
+var signInValidator = mustValidator[signInInput]()
+
 //user authorization
 func (h Handler) userSignIn(c echo.Context) error {
-	var inp signUpInput
+	var inp signInInput
 	if err := json.NewDecoder(c.Request().Body).Decode(&inp); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	if verr := signInValidator.validate(&inp); verr != nil {
+		return validationHTTPError(verr)
+	}
+
 	//log in
 	tokens, err := h.services.Users.SignIn(c.Request().Context(), service.UserSignInInput {
 		Login:    inp.Login,
 		Password: inp.Password,
+		Client:   sessionClient(c, inp.DeviceName),
 	})
 
 	if err != nil {
-		if errors.Is(err, domain.ErrUserNotFound) {
+		var throttled *domain.ThrottledError
+		switch {
+		case errors.As(err, &throttled):
+			retryAfter := int(math.Ceil(throttled.RetryAfter.Seconds()))
+			c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		case errors.Is(err, domain.ErrWrongLoginOrPassword):
 			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 		}
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
-	c.SetCookie(&http.Cookie{
-		Name: "RefreshToken", 						  //TODO make constant
-		Value: tokens.RefreshToken,
-		Path:  "/api/user/auth/refresh", 			  //TODO construct it somehow automatically (routers may change)
-		Domain: "",									  //TODO set domen from configuration
-		Expires: time.Now().Add(40 * 24 * time.Hour), //TODO Expire time for RefreshToken from config
-	})
+	//second factor is required, the client should continue with the challenge
+	if tokens.ChallengeToken != "" {
+		return c.JSON(http.StatusAccepted, tokens)
+	}
+
+	h.setRefreshTokenCookie(c, tokens.RefreshToken)
 
 	return c.JSON(http.StatusOK, tokens)
 }
 
-//TODO tags
 type refreshInput struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
 }
 
-//TODO logout
-//TODO add logout tokens to the black list until they are spoiled
+var refreshValidator = mustValidator[refreshInput]()
 
 func (h Handler) userRefresh(c echo.Context) error {
-	//TODO add old tokens to the black list until they are spoiled
-
 	// For browsers we can use cookie.
 	// Those apps, which don't support cookie, should provide refresh token in body
 	var inp refreshInput
@@ -131,21 +175,63 @@ func (h Handler) userRefresh(c echo.Context) error {
 		}
 	}
 
-	tokens, err := h.services.Users.RefreshTokens(c.Request().Context(), inp.RefreshToken)
+	if verr := refreshValidator.validate(&inp); verr != nil {
+		return validationHTTPError(verr)
+	}
+
+	tokens, err := h.services.Users.RefreshTokens(c.Request().Context(), inp.RefreshToken, sessionClient(c, ""))
 	if err != nil {
-		if errors.Is(err, domain.ErrUserNotFound) {
+		if errors.Is(err, domain.ErrUserNotFoundOrSessionWasExpired) || errors.Is(err, domain.ErrRefreshTokenReused) {
 			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
+	h.setRefreshTokenCookie(c, tokens.RefreshToken)
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+//ends current session
+func (h Handler) userLogout(c echo.Context) error {
+	claims := c.Get(TokenClaimsCtxName.String()).(auth.Claims)
+
+	if err := h.services.Users.Logout(c.Request().Context(), claims); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	h.clearRefreshTokenCookie(c)
+	return c.NoContent(http.StatusOK)
+}
+
+//ends all sessions of the user
+func (h Handler) userLogoutAll(c echo.Context) error {
+	claims := c.Get(TokenClaimsCtxName.String()).(auth.Claims)
+
+	if err := h.services.Users.LogoutAll(c.Request().Context(), claims); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	h.clearRefreshTokenCookie(c)
+	return c.NoContent(http.StatusOK)
+}
+
+func (h Handler) setRefreshTokenCookie(c echo.Context, refreshToken string) {
 	c.SetCookie(&http.Cookie{
 		Name: "RefreshToken", 						  //TODO make constant
-		Value: tokens.RefreshToken,
-		Path:  "/api/user/auth/refresh", 				  //TODO construct it somehow automatically (routers may change)
+		Value: refreshToken,
+		Path:  "/api/user/auth/refresh", 			  //TODO construct it somehow automatically (routers may change)
 		Domain: "",									  //TODO set domen from configuration
 		Expires: time.Now().Add(40 * 24 * time.Hour), //TODO Expire time for RefreshToken from config
 	})
+}
 
-	return c.JSON(http.StatusOK, tokens)
-}
\ No newline at end of file
+func (h Handler) clearRefreshTokenCookie(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:    "RefreshToken", //TODO make constant
+		Value:   "",
+		Path:    "/api/user/auth/refresh",
+		MaxAge:  -1,
+		Expires: time.Unix(0, 0),
+	})
+}
@@ -0,0 +1,126 @@
+package v2
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"gophkeeper/internal/domain"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// fieldRules are the parsed binding tag of a string field, min and max are -1 if they are not set.
+type fieldRules struct {
+	index    int
+	name     string
+	required bool
+	min      int
+	max      int
+}
+
+// validator checks string fields of T against their binding tags.
+// Supported rules are "required", "min=N" and "max=N", lengths are counted in characters.
+// The tags are parsed once, when the validator is made, so a bad tag can't fail a request.
+type validator[T any] struct {
+	fields []fieldRules
+}
+
+// mustValidator is newValidator for package level vars, a bad tag stops the server at start.
+func mustValidator[T any]() validator[T] {
+	v, err := newValidator[T]()
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func newValidator[T any]() (validator[T], error) {
+	var v validator[T]
+
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		return v, fmt.Errorf("%s is not a struct", t)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("binding")
+		if !ok {
+			continue
+		}
+		if field.Type.Kind() != reflect.String {
+			return v, fmt.Errorf("binding of %s.%s: only string fields are supported", t.Name(), field.Name)
+		}
+
+		rules := fieldRules{index: i, min: -1, max: -1}
+		rules.name = strings.Split(field.Tag.Get("json"), ",")[0]
+		if rules.name == "" {
+			rules.name = field.Name
+		}
+
+		for _, rule := range strings.Split(tag, ",") {
+			key, arg, _ := strings.Cut(rule, "=")
+			switch key {
+			case "required":
+				rules.required = true
+			case "min", "max":
+				n, err := strconv.Atoi(arg)
+				if err != nil || n < 0 {
+					return v, fmt.Errorf("bad binding rule %q of %s.%s", rule, t.Name(), field.Name)
+				}
+				if key == "min" {
+					rules.min = n
+				} else {
+					rules.max = n
+				}
+			default:
+				return v, fmt.Errorf("unknown binding rule %q of %s.%s", rule, t.Name(), field.Name)
+			}
+		}
+
+		v.fields = append(v.fields, rules)
+	}
+
+	return v, nil
+}
+
+// validate returns nil, if the input is valid.
+func (v validator[T]) validate(inp *T) *domain.ValidationError {
+	var verr domain.ValidationError
+
+	value := reflect.ValueOf(inp).Elem()
+	for _, rules := range v.fields {
+		s := value.Field(rules.index).String()
+		length := utf8.RuneCountInString(s)
+
+		if rules.required && strings.TrimSpace(s) == "" {
+			verr.Add(rules.name, "is required")
+		}
+		if rules.min >= 0 && length < rules.min && length > 0 {
+			verr.Add(rules.name, fmt.Sprintf("must be at least %d characters long", rules.min))
+		}
+		if rules.max >= 0 && length > rules.max {
+			verr.Add(rules.name, fmt.Sprintf("must be at most %d characters long", rules.max))
+		}
+	}
+
+	if len(verr.Fields) == 0 {
+		return nil
+	}
+	return &verr
+}
+
+type validationResponse struct {
+	Message string              `json:"message"`
+	Fields  []domain.FieldError `json:"fields"`
+}
+
+// validationHTTPError turns field errors into 400 response with structured body.
+func validationHTTPError(err *domain.ValidationError) *echo.HTTPError {
+	return echo.NewHTTPError(http.StatusBadRequest, validationResponse{
+		Message: domain.ErrInvalidInput.Error(),
+		Fields:  err.Fields,
+	})
+}
@@ -0,0 +1,107 @@
+package v2
+
+import (
+	"gophkeeper/internal/domain"
+	"reflect"
+	"testing"
+)
+
+type testInput struct {
+	Login    string `json:"login" binding:"required,max=5"`
+	Password string `json:"password,omitempty" binding:"min=3"`
+	Name     string `binding:"max=2"`
+	Comment  string
+}
+
+func TestValidate(t *testing.T) {
+	v, err := newValidator[testInput]()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		inp    testInput
+		fields []domain.FieldError
+	}{
+		{
+			name: "valid",
+			inp:  testInput{Login: "gophe", Password: "secret", Name: "go"},
+		},
+		{
+			name:   "required",
+			inp:    testInput{Login: "  "},
+			fields: []domain.FieldError{{Field: "login", Message: "is required"}},
+		},
+		{
+			name: "empty field is not checked against min",
+			inp:  testInput{Login: "go"},
+		},
+		{
+			name:   "min",
+			inp:    testInput{Login: "go", Password: "ab"},
+			fields: []domain.FieldError{{Field: "password", Message: "must be at least 3 characters long"}},
+		},
+		{
+			name: "max is counted in characters and field name falls back to the go name",
+			inp:  testInput{Login: "гоферы", Name: "ююю"},
+			fields: []domain.FieldError{
+				{Field: "login", Message: "must be at most 5 characters long"},
+				{Field: "Name", Message: "must be at most 2 characters long"},
+			},
+		},
+		{
+			name: "five characters of two bytes fit into max=5",
+			inp:  testInput{Login: "гофер"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verr := v.validate(&tt.inp)
+			if tt.fields == nil {
+				if verr != nil {
+					t.Fatalf("unexpected errors: %+v", verr.Fields)
+				}
+				return
+			}
+			if verr == nil {
+				t.Fatalf("expected errors: %+v", tt.fields)
+			}
+			if !reflect.DeepEqual(verr.Fields, tt.fields) {
+				t.Errorf("got %+v, want %+v", verr.Fields, tt.fields)
+			}
+		})
+	}
+}
+
+func TestNewValidatorBadTags(t *testing.T) {
+	type badNumber struct {
+		Login string `binding:"max=ten"`
+	}
+	type negative struct {
+		Login string `binding:"min=-1"`
+	}
+	type unknownRule struct {
+		Login string `binding:"requried"`
+	}
+	type notString struct {
+		Age int `binding:"required"`
+	}
+
+	if _, err := newValidator[badNumber](); err == nil {
+		t.Error("max=ten is accepted")
+	}
+	if _, err := newValidator[negative](); err == nil {
+		t.Error("min=-1 is accepted")
+	}
+	if _, err := newValidator[unknownRule](); err == nil {
+		t.Error("unknown rule is accepted")
+	}
+	if _, err := newValidator[notString](); err == nil {
+		t.Error("binding of int field is accepted")
+	}
+	if _, err := newValidator[string](); err == nil {
+		t.Error("not a struct is accepted")
+	}
+}
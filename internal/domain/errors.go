@@ -5,6 +5,7 @@ import "errors"
 //common
 var (
 	ErrInternalServerError 					= errors.New("internal server error")
+	ErrInvalidInput							= errors.New("invalid input")
 )
 
 //user
@@ -12,13 +13,38 @@ var (
 	ErrUserNotFound 						= errors.New("user doesn't exists")
 	ErrUserNotFoundOrSessionWasExpired 		= errors.New("user doesn't exists or session was expired")
 	ErrUserAlreadyExists       				= errors.New("user with such login already exists")
-	ErrUserBadPassword		 				= errors.New("bad password")
+	ErrWrongLoginOrPassword	 				= errors.New("wrong login or password")
 
 	ErrSessionNotFound			 			= errors.New("session was not found")
 	ErrSessionAlreadyExists			 		= errors.New("session is already exist")
+	ErrSecondFactorRequired					= errors.New("second factor code is required")
+	ErrChallengeNotFound					= errors.New("sign in challenge doesn't exist or was expired")
+	ErrBadSecondFactorCode					= errors.New("bad second factor code")
+	ErrTOTPNotEnrolled						= errors.New("totp enrolment wasn't started")
+	ErrTOTPAlreadyEnabled					= errors.New("totp is already enabled")
+	ErrTOTPNotEnabled						= errors.New("totp is not enabled")
+	ErrRecoveryCodeNotFound					= errors.New("recovery code doesn't exist or was already used")
+
+	ErrRefreshTokenReused					= errors.New("refresh token was already used, all sessions of its family are revoked")
+	ErrTooManySignInAttempts				= errors.New("too many sign in attempts")
+
+	ErrKeyCheckNotFound						= errors.New("key check value wasn't saved yet")
+	ErrKeyCheckAlreadyExists				= errors.New("key check value is already saved")
 )
 
 //materials
 var (
 	ErrDataNotFound			 				= errors.New("data was not found")
+	ErrUnknownMaterialType					= errors.New("unknown material type")
+	ErrDataCannotBeOpened					= errors.New("data can't be decrypted, check master password")
+	ErrBlobTooLarge							= errors.New("file is too large")
+	ErrUploadNotFound						= errors.New("upload doesn't exist or was expired")
+	ErrTooManyUploads						= errors.New("too many unfinished uploads, finish or abort some of them")
+	ErrBadChunk								= errors.New("chunk doesn't match the upload or its checksum")
+	ErrUploadIncomplete						= errors.New("not all chunks of the upload were received")
+	ErrVersionNotFound						= errors.New("version was not found")
+	ErrRevisionConflict						= errors.New("item was changed since it was read")
+	ErrIdempotencyKeyReused					= errors.New("idempotency key was already used for another request")
+	ErrCreatedItemPurged					= errors.New("item created with the idempotency key was deleted")
+	ErrIDNotReserved						= errors.New("id of the new item wasn't reserved or was already used")
 )
\ No newline at end of file
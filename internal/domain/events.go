@@ -0,0 +1,20 @@
+package domain
+
+// EventKind tells what happened to a vault item.
+type EventKind string
+
+const (
+	EventCreated  EventKind = "created"
+	EventUpdated  EventKind = "updated"
+	EventDeleted  EventKind = "deleted"
+	EventRestored EventKind = "restored" // from the trash or from the history
+	// EventReady is sent when the stream is (re)connected, changes made before it may be missed
+	EventReady EventKind = "ready"
+)
+
+// MaterialEvent notifies about a change of the user's items, the changes themselves are fetched by sync.
+type MaterialEvent struct {
+	Kind EventKind    `json:"kind"`
+	Type MaterialType `json:"type,omitempty"`
+	ID   int          `json:"id,omitempty"`
+}
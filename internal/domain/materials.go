@@ -2,10 +2,105 @@ package domain
 
 import "time"
 
+// MaterialType is a kind of vault item. It is used both in routes and to choose a storage table.
+type MaterialType string
+
+const (
+	MaterialText MaterialType = "text"
+	MaterialCard MaterialType = "card"
+	MaterialCred MaterialType = "cred"
+	MaterialBlob MaterialType = "blob"
+)
+
+func (t MaterialType) String() string {
+	return string(t)
+}
+
+// SealedData is a vault item encrypted on the client side.
+// The server never sees the plaintext and stores Data and Nonce as is.
+type SealedData struct {
+	ID        int       `json:"id"`
+	Data      []byte    `json:"data"`
+	Nonce     []byte    `json:"nonce"`
+	Revision  int       `json:"revision"` // incremented on every update, see RevisionConflictError
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RevisionConflictError is returned when the item was updated since the revision the update is based on.
+type RevisionConflictError struct {
+	Current SealedData
+}
+
+func (e *RevisionConflictError) Error() string {
+	return ErrRevisionConflict.Error()
+}
+
+func (e *RevisionConflictError) Unwrap() error {
+	return ErrRevisionConflict
+}
+
+// IdempotencyKey makes retries of a create return the item created by the first request instead of a new one.
+type IdempotencyKey struct {
+	Key         string
+	Fingerprint string // digest of the request, the key can't be reused for another one
+}
+
+// BlobData describes a sealed file. Its content is streamed separately as a sealed stream,
+// Metadata keeps the sealed file name and notes.
+type BlobData struct {
+	ID        int       `json:"id"`
+	Metadata  []byte    `json:"metadata"`
+	Nonce     []byte    `json:"nonce"`
+	Size      int64     `json:"size"` // size of the sealed content
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BlobUpload is a resumable upload of a sealed file. Chunks may be sent in any order and resent,
+// the file appears in the vault only after the upload is finalized.
+type BlobUpload struct {
+	ID        string    `json:"id"`
+	Metadata  []byte    `json:"metadata"`
+	Nonce     []byte    `json:"nonce"`
+	Size      int64     `json:"size"`       // size of the sealed content
+	ChunkSize int       `json:"chunk_size"` // every chunk but the last one has this size
+	Received  [][2]int  `json:"received"`   // ranges of received chunk numbers, both ends are included
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Chunks returns the number of chunks the content is split into.
+func (u BlobUpload) Chunks() int {
+	if u.Size == 0 {
+		return 1
+	}
+	return int((u.Size + int64(u.ChunkSize) - 1) / int64(u.ChunkSize))
+}
+
+// ChunkLen returns the expected size of the chunk.
+func (u BlobUpload) ChunkLen(seq int) int {
+	if seq == u.Chunks()-1 {
+		return int(u.Size - int64(seq)*int64(u.ChunkSize))
+	}
+	return u.ChunkSize
+}
+
+// BlobInfo is BlobData opened on the client side.
+type BlobInfo struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Metadata  string    `json:"metadata"`
+	FileID    string    `json:"file_id"` // random id the content is sealed with, so it can't be mixed with other files
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type TextData struct {
-	ID       int    `json:"id"`
-	Text     string `json:"text"`
-	Metadata string `json:"metadata"`
+	ID        int       `json:"id"`
+	Text      string    `json:"text"`
+	Metadata  string    `json:"metadata"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 type CardData struct {
@@ -16,11 +111,32 @@ type CardData struct {
 	Name       string    `json:"name"`
 	Surname    string    `json:"surname"`
 	Metadata   string    `json:"metadata"`
+	Revision   int       `json:"revision"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
 }
 
 type CredData struct {
-	ID       int    `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	Metadata string `json:"metadata"`
+	ID        int       `json:"id"`
+	Login     string    `json:"login"`
+	Password  string    `json:"password"`
+	Metadata  string    `json:"metadata"`
+	Revision  int       `json:"revision"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Version is a previous value of a vault item, it's saved on every update.
+type Version struct {
+	ID         int       `json:"id"`
+	Data       []byte    `json:"data"`
+	Nonce      []byte    `json:"nonce"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+// VersionInfo is Version opened on the client side.
+type VersionInfo struct {
+	ID         int
+	Title      string
+	ReplacedAt time.Time
 }
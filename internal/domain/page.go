@@ -0,0 +1,41 @@
+package domain
+
+import "time"
+
+// ListSort is a column items are ordered by. Items are sealed on the client side, so they can be sorted
+// and paged only by the columns the server sees. Their content, metadata included, is filtered on the client.
+type ListSort string
+
+const (
+	SortByID        ListSort = "id"
+	SortByCreatedAt ListSort = "created_at"
+	SortByUpdatedAt ListSort = "updated_at"
+)
+
+// ListOptions select a page of items. Cursor is taken from the previous page, it's valid with the same sort only.
+type ListOptions struct {
+	Limit  int
+	Sort   ListSort
+	Desc   bool
+	Cursor string
+}
+
+// Page is a part of a list, NextCursor is empty on the last page.
+type Page struct {
+	Items      []SealedData
+	NextCursor string
+}
+
+// PageKey is the position of the last item of a page, the next page starts after it.
+type PageKey struct {
+	At time.Time // created_at or updated_at of the item, it isn't used for SortByID
+	ID int
+}
+
+// PageQuery is ListOptions with the decoded cursor.
+type PageQuery struct {
+	Limit int
+	Sort  ListSort
+	Desc  bool
+	After *PageKey
+}
@@ -0,0 +1,10 @@
+package domain
+
+// SearchResult is an item found by the search of the opened vault. Items are sealed on the client side,
+// so the server can't index them and the search is done on the client.
+type SearchResult struct {
+	Type  MaterialType
+	ID    int
+	Title string
+	Rank  int // higher ranks go first
+}
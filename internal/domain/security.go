@@ -0,0 +1,19 @@
+package domain
+
+import "time"
+
+type SecurityEventKind string
+
+const (
+	// SecurityEventRefreshTokenReuse means that already rotated refresh token was presented,
+	// i.e. the token was most likely stolen. The whole token family is revoked then.
+	SecurityEventRefreshTokenReuse SecurityEventKind = "refresh_token_reuse"
+	// SecurityEventRecoveryCodeUsed means that the second factor was passed with a recovery code.
+	SecurityEventRecoveryCodeUsed SecurityEventKind = "recovery_code_used"
+)
+
+type SecurityEvent struct {
+	Kind      SecurityEventKind
+	Details   string
+	CreatedAt time.Time
+}
@@ -2,12 +2,40 @@ package domain
 
 import "time"
 
+// Session is a refresh token issued to user. Every rotation issues a new token in the same family,
+// the rotated ones are kept to detect their reuse. For the user the whole family is a single session.
 type Session struct {
 	RefreshToken string
-	ExpiresAt time.Time
+	FamilyID     string
+	UserID       int
+	ExpiresAt    time.Time
+	RotatedAt    *time.Time
+	Client       SessionClient
+
+	// access token issued together with the refresh token, it's revoked when the session is terminated
+	AccessTokenID        string
+	AccessTokenExpiresAt time.Time
+}
+
+// SessionClient describes the device the session is used from.
+type SessionClient struct {
+	DeviceName string `json:"device_name"`
+	UserAgent  string `json:"user_agent"`
+	IP         string `json:"ip"`
 }
 
+// SessionInfo is an active session as it is shown to the user.
+type SessionInfo struct {
+	ID string `json:"id"`
+	SessionClient
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// Tokens are issued on sign in. If the second factor is required, only ChallengeToken is set.
 type Tokens struct {
-	AccessToken  string		`json:"access_token"`
-	RefreshToken  string	`json:"refresh_token"`
-}
\ No newline at end of file
+	AccessToken  string		`json:"access_token,omitempty"`
+	RefreshToken  string	`json:"refresh_token,omitempty"`
+	ChallengeToken string	`json:"challenge_token,omitempty"`
+}
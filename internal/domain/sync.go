@@ -0,0 +1,25 @@
+package domain
+
+import "time"
+
+// SyncChanges are the changes of text, card and cred items made after the cursor the client has.
+// The cursor grows with every change of the user's items, so it's enough to keep the last one.
+type SyncChanges struct {
+	Cursor  int64       `json:"cursor"`
+	Reset   bool        `json:"reset"` // Items is the whole vault, the client must drop the items it has
+	Items   []SyncItem  `json:"items"`
+	Deleted []Tombstone `json:"deleted"`
+}
+
+// SyncItem is a created or updated item.
+type SyncItem struct {
+	Type MaterialType `json:"type"`
+	SealedData
+}
+
+// Tombstone tells the item was deleted, it's in the trash until it's purged.
+type Tombstone struct {
+	Type      MaterialType `json:"type"`
+	ID        int          `json:"id"`
+	DeletedAt time.Time    `json:"deleted_at"`
+}
@@ -0,0 +1,26 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// LoginAttempts counts failed sign in attempts made with one login or from one client ip.
+type LoginAttempts struct {
+	Failures     int
+	LastFailedAt time.Time
+}
+
+// ThrottledError is returned instead of checking credentials, while the login or the client ip
+// has to wait after the failed attempts.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManySignInAttempts.Error(), e.RetryAfter.Round(time.Second))
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManySignInAttempts
+}
@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashItem is a deleted vault item waiting to be restored or purged.
+// Every material type has its own id sequence, so the trash id includes the type, see TrashItemID.
+type TrashItem struct {
+	ID        string       `json:"id"`
+	Type      MaterialType `json:"type"`
+	ItemID    int          `json:"item_id"`
+	Data      []byte       `json:"data"` // sealed metadata for files
+	Nonce     []byte       `json:"nonce"`
+	DeletedAt time.Time    `json:"deleted_at"`
+}
+
+// TrashInfo is TrashItem opened on the client side.
+type TrashInfo struct {
+	ID        string
+	Type      MaterialType
+	ItemID    int
+	Title     string
+	DeletedAt time.Time
+}
+
+// TrashItemID makes a trash id like "card-12".
+func TrashItemID(t MaterialType, id int) string {
+	return fmt.Sprintf("%s-%d", t, id)
+}
+
+// ParseTrashItemID is the reverse of TrashItemID.
+func ParseTrashItemID(s string) (MaterialType, int, error) {
+	t, idStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return "", 0, ErrInvalidInput
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return "", 0, ErrInvalidInput
+	}
+
+	switch MaterialType(t) {
+	case MaterialText, MaterialCard, MaterialCred, MaterialBlob:
+		return MaterialType(t), id, nil
+	default:
+		return "", 0, ErrUnknownMaterialType
+	}
+}
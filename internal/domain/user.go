@@ -1,7 +1,40 @@
 package domain
 
+import "time"
+
 type User struct {
 	ID int
 	Login string
 	Password string
+
+	// TOTP second factor, the secret is pending until the first code is confirmed
+	TOTPSecret   string
+	TOTPEnabled  bool
+	TOTPLastStep int64
+}
+
+// TOTPEnrollment is shown to the user to set up an authenticator app.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// Challenge is issued after the password check, if the user has the second factor enabled.
+// It's exchanged for tokens together with the second factor code.
+type Challenge struct {
+	Token     string
+	UserID    int
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+// RecoveryCodes are shown once, when they are generated. The server keeps only their digests.
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// RecoveryCodesStatus tells how many unused recovery codes the user has left.
+type RecoveryCodesStatus struct {
+	Enabled   bool `json:"enabled"`
+	Remaining int  `json:"remaining"`
 }
@@ -0,0 +1,38 @@
+package domain
+
+import "strings"
+
+// FieldError describes why the value of one input field was rejected.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError collects all the field errors of an input, so the client can show them at once.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Add(field, message string) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: message})
+}
+
+// Err returns nil, if no field errors were added.
+func (e *ValidationError) Err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return ErrInvalidInput.Error() + ": " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidInput
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"gophkeeper/internal/domain"
+	"sync"
+)
+
+// a slow subscriber loses events above it, which is fine: any event makes the client sync all the changes
+const eventsBuffer = 16
+
+// EventHub delivers material events to the subscribers of the same user within the server instance.
+type EventHub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan domain.MaterialEvent]struct{}
+	closed      bool
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{
+		subscribers: make(map[int]map[chan domain.MaterialEvent]struct{}),
+	}
+}
+
+// Subscribe returns the channel of the user's events and the function to unsubscribe.
+// The channel is closed, when the hub is closed.
+func (h *EventHub) Subscribe(userID int) (<-chan domain.MaterialEvent, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan domain.MaterialEvent, eventsBuffer)
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan domain.MaterialEvent]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		if _, ok := h.subscribers[userID][ch]; !ok {
+			return
+		}
+		delete(h.subscribers[userID], ch)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+		close(ch)
+	}
+}
+
+// Publish sends the event to all the user's subscribers without waiting for them.
+func (h *EventHub) Publish(userID int, event domain.MaterialEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// Close ends all the subscriptions, so long-lived streams don't hold the server shutdown.
+func (h *EventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, chans := range h.subscribers {
+		for ch := range chans {
+			close(ch)
+		}
+	}
+	h.subscribers = make(map[int]map[chan domain.MaterialEvent]struct{})
+	h.closed = true
+}
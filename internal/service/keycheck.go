@@ -0,0 +1,17 @@
+package service
+
+import (
+	"context"
+	"gophkeeper/internal/domain"
+)
+
+// GetKeyCheck returns a known value sealed with the vault key. The client opens it to tell a wrong master password,
+// before anything is sealed with the key.
+func (s *UserService) GetKeyCheck(ctx context.Context, userID int) (domain.SealedData, error) {
+	return s.storage.GetKeyCheck(ctx, userID)
+}
+
+// SetKeyCheck saves the value sealed by the first client unlocking the vault, it isn't replaced later.
+func (s *UserService) SetKeyCheck(ctx context.Context, userID int, keyCheck domain.SealedData) error {
+	return s.storage.SetKeyCheck(ctx, userID, keyCheck)
+}
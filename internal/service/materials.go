@@ -2,54 +2,339 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"gophkeeper/internal/domain"
 	"gophkeeper/internal/storage"
+	"io"
+	"time"
 )
 
 type MaterialsService struct {
-	storage storage.Materials
+	storage     storage.Materials
+	maxBlobSize int64
+	events      *EventHub
 }
 
-func NewMaterialsService(s storage.Materials) *MaterialsService {
+func NewMaterialsService(s storage.Materials, maxBlobSize int64, events *EventHub) *MaterialsService {
 	return &MaterialsService{
-		storage: s,
+		storage:     s,
+		maxBlobSize: maxBlobSize,
+		events:      events,
 	}
 }
 
-func (s *MaterialsService) GetAllTextData(ctx context.Context, userID int) ([]domain.TextData, error) {
-	return s.storage.GetAllTextData(ctx, userID)
+// publish notifies the user's devices about the change, if it's done. The device which made the change
+// gets the event too, it just finds nothing new on sync.
+func (s *MaterialsService) publish(err error, userID int, kind domain.EventKind, t domain.MaterialType, id int) {
+	if err != nil {
+		return
+	}
+	s.events.Publish(userID, domain.MaterialEvent{Kind: kind, Type: t, ID: id})
+}
+
+func (s *MaterialsService) GetAll(ctx context.Context, userID int, t domain.MaterialType) ([]domain.SealedData, error) {
+	return s.storage.GetAll(ctx, userID, t)
+}
+
+const (
+	DefaultPageLimit = 50
+	MaxPageLimit     = 500
+)
+
+// pageCursor is kept in the cursor of the next page. The order is kept too, a cursor isn't valid with another one.
+type pageCursor struct {
+	Sort domain.ListSort `json:"s"`
+	Desc bool            `json:"d,omitempty"`
+	At   time.Time       `json:"at,omitempty"`
+	ID   int             `json:"id"`
+}
+
+func encodeCursor(c pageCursor) (string, error) {
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func decodeCursor(cursor string) (pageCursor, error) {
+	var c pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, err
+	}
+	return c, json.Unmarshal(raw, &c)
+}
+
+// GetPage returns a page of items in the given order, the cursor of the next page is set, if there are more items.
+func (s *MaterialsService) GetPage(ctx context.Context, userID int, t domain.MaterialType, opts domain.ListOptions) (domain.Page, error) {
+	var page domain.Page
+	if opts.Limit == 0 {
+		opts.Limit = DefaultPageLimit
+	}
+	if opts.Sort == "" {
+		opts.Sort = domain.SortByID
+	}
+
+	var verr domain.ValidationError
+	if opts.Limit < 1 || opts.Limit > MaxPageLimit {
+		verr.Add("limit", fmt.Sprintf("must be between 1 and %d", MaxPageLimit))
+	}
+	switch opts.Sort {
+	case domain.SortByID, domain.SortByCreatedAt, domain.SortByUpdatedAt:
+	default:
+		verr.Add("sort", fmt.Sprintf("must be one of %s, %s, %s", domain.SortByID, domain.SortByCreatedAt, domain.SortByUpdatedAt))
+	}
+
+	query := domain.PageQuery{Limit: opts.Limit + 1, Sort: opts.Sort, Desc: opts.Desc}
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		switch {
+		case err != nil:
+			verr.Add("cursor", "is malformed")
+		case c.Sort != opts.Sort || c.Desc != opts.Desc:
+			verr.Add("cursor", "was given for another order")
+		default:
+			query.After = &domain.PageKey{At: c.At, ID: c.ID}
+		}
+	}
+	if err := verr.Err(); err != nil {
+		return page, err
+	}
+
+	// one more item tells there is the next page
+	items, err := s.storage.GetPage(ctx, userID, t, query)
+	if err != nil {
+		return page, err
+	}
+	if len(items) <= opts.Limit {
+		page.Items = items
+		return page, nil
+	}
+
+	page.Items = items[:opts.Limit]
+	last := page.Items[len(page.Items)-1]
+	c := pageCursor{Sort: opts.Sort, Desc: opts.Desc, ID: last.ID}
+	switch opts.Sort {
+	case domain.SortByCreatedAt:
+		c.At = last.CreatedAt
+	case domain.SortByUpdatedAt:
+		c.At = last.UpdatedAt
+	}
+	page.NextCursor, err = encodeCursor(c)
+	return page, err
+}
+
+func (s *MaterialsService) GetByID(ctx context.Context, userID int, t domain.MaterialType, id int) (domain.SealedData, error) {
+	return s.storage.GetByID(ctx, userID, t, id)
+}
+
+// UpdateByID applies the update to data.Revision only and returns the new revision.
+func (s *MaterialsService) UpdateByID(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData) (int, error) {
+	revision, err := s.storage.UpdateByID(ctx, userID, t, data)
+	s.publish(err, userID, domain.EventUpdated, t, data.ID)
+	return revision, err
 }
 
-func (s *MaterialsService) UpdateTextDataByID(ctx context.Context, userID int, data domain.TextData) error {
-	return s.storage.UpdateTextDataByID(ctx, userID, data)
+// ReserveID returns an id for a new item, the client seals the item with it before Create.
+func (s *MaterialsService) ReserveID(ctx context.Context, userID int, t domain.MaterialType) (int, error) {
+	return s.storage.ReserveID(ctx, userID, t)
 }
 
-func (s *MaterialsService) CreateNewTextData(ctx context.Context, userID int, data domain.TextData) error {
-	return s.storage.CreateNewTextData(ctx, userID, data)
+// Create stores the item under data.ID, which must be reserved by ReserveID. Requests with the same idempotency key
+// return the item created by the first one, ErrIdempotencyKeyReused is returned if the key was used for another request.
+func (s *MaterialsService) Create(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData, idempotencyKey string) (domain.SealedData, error) {
+	var key domain.IdempotencyKey
+	if idempotencyKey != "" {
+		key = domain.IdempotencyKey{
+			Key:         idempotencyKey,
+			Fingerprint: fmt.Sprintf("%x", sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%x:%x", t, data.ID, data.Data, data.Nonce)))),
+		}
+	}
+
+	created, replayed, err := s.storage.Create(ctx, userID, t, data, key)
+	if !replayed {
+		s.publish(err, userID, domain.EventCreated, t, created.ID)
+	}
+	return created, err
 }
 
+func (s *MaterialsService) DeleteByID(ctx context.Context, userID int, t domain.MaterialType, id int) error {
+	err := s.storage.DeleteByID(ctx, userID, t, id)
+	s.publish(err, userID, domain.EventDeleted, t, id)
+	return err
+}
+
+func (s *MaterialsService) GetHistory(ctx context.Context, userID int, t domain.MaterialType, id int) ([]domain.Version, error) {
+	return s.storage.GetHistory(ctx, userID, t, id)
+}
+
+func (s *MaterialsService) RestoreVersion(ctx context.Context, userID int, t domain.MaterialType, id int, versionID int) error {
+	err := s.storage.RestoreVersion(ctx, userID, t, id, versionID)
+	s.publish(err, userID, domain.EventRestored, t, id)
+	return err
+}
+
+// GetChanges returns changes after the cursor, see domain.SyncChanges.
+func (s *MaterialsService) GetChanges(ctx context.Context, userID int, since int64) (domain.SyncChanges, error) {
+	return s.storage.GetChanges(ctx, userID, since)
+}
+
+//**********************************************************************************************************************
+// Blobs
 //**********************************************************************************************************************
-func (s *MaterialsService) GetAllCardData(ctx context.Context, userID int) ([]domain.CardData, error) {
-	return s.storage.GetAllCardData(ctx, userID)
+
+// CreateBlob stores a sealed file. ErrBlobTooLarge is returned, if content exceeds the size limit.
+func (s *MaterialsService) CreateBlob(ctx context.Context, userID int, blob domain.BlobData, content io.Reader) (domain.BlobData, error) {
+	blob, err := s.storage.CreateBlob(ctx, userID, blob, &limitedReader{r: content, n: s.maxBlobSize})
+	s.publish(err, userID, domain.EventCreated, domain.MaterialBlob, blob.ID)
+	return blob, err
+}
+
+func (s *MaterialsService) GetAllBlobs(ctx context.Context, userID int) ([]domain.BlobData, error) {
+	return s.storage.GetAllBlobs(ctx, userID)
+}
+
+func (s *MaterialsService) GetBlob(ctx context.Context, userID int, blobID int) (domain.BlobData, error) {
+	return s.storage.GetBlob(ctx, userID, blobID)
+}
+
+func (s *MaterialsService) ReadBlob(ctx context.Context, userID int, blobID int, w io.Writer) error {
+	return s.storage.ReadBlob(ctx, userID, blobID, w)
+}
+
+func (s *MaterialsService) UpdateBlobMetadata(ctx context.Context, userID int, blob domain.BlobData) error {
+	err := s.storage.UpdateBlobMetadata(ctx, userID, blob)
+	s.publish(err, userID, domain.EventUpdated, domain.MaterialBlob, blob.ID)
+	return err
 }
 
-func (s *MaterialsService) UpdateCardDataByID(ctx context.Context, userID int, data domain.CardData) error {
-	return s.storage.UpdateCardDataByID(ctx, userID, data)
+func (s *MaterialsService) DeleteBlob(ctx context.Context, userID int, blobID int) error {
+	err := s.storage.DeleteBlob(ctx, userID, blobID)
+	s.publish(err, userID, domain.EventDeleted, domain.MaterialBlob, blobID)
+	return err
 }
 
-func (s *MaterialsService) CreateNewCardData(ctx context.Context, userID int, data domain.CardData) error {
-	return s.storage.CreateNewCardData(ctx, userID, data)
+// limitedReader fails with ErrBlobTooLarge instead of silent truncation like io.LimitReader does.
+type limitedReader struct {
+	r io.Reader
+	n int64
 }
 
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, domain.ErrBlobTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, domain.ErrBlobTooLarge
+	}
+	return n, err
+}
+
+//**********************************************************************************************************************
+// Resumable uploads
 //**********************************************************************************************************************
-func (s *MaterialsService) GetAllCredData(ctx context.Context, userID int) ([]domain.CredData, error) {
-	return s.storage.GetAllCredData(ctx, userID)
+
+const (
+	uploadTTL         = 24 * time.Hour
+	minUploadChunkLen = 64 * 1024
+	// MaxUploadChunkLen limits the body of one chunk request
+	MaxUploadChunkLen = 8 * 1024 * 1024
+	// maxOpenUploads limits unfinished uploads of a user, each of them may keep up to maxBlobSize of chunks
+	maxOpenUploads = 8
+)
+
+// CreateUpload starts a resumable upload. The client chooses the chunk size within the limits.
+func (s *MaterialsService) CreateUpload(ctx context.Context, userID int, upload domain.BlobUpload) (domain.BlobUpload, error) {
+	var verr domain.ValidationError
+	if upload.Size < 0 {
+		verr.Add("size", "must not be negative")
+	}
+	if upload.ChunkSize < minUploadChunkLen || upload.ChunkSize > MaxUploadChunkLen {
+		verr.Add("chunk_size", fmt.Sprintf("must be between %d and %d", minUploadChunkLen, MaxUploadChunkLen))
+	}
+	if err := verr.Err(); err != nil {
+		return upload, err
+	}
+
+	if upload.Size > s.maxBlobSize {
+		return upload, domain.ErrBlobTooLarge
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return upload, err
+	}
+	upload.ID = id
+	upload.ExpiresAt = time.Now().Add(uploadTTL)
+	upload.Received = make([][2]int, 0)
+
+	if err := s.storage.CreateUpload(ctx, userID, upload, maxOpenUploads); err != nil {
+		return upload, err
+	}
+
+	return upload, nil
+}
+
+func (s *MaterialsService) GetUpload(ctx context.Context, userID int, uploadID string) (domain.BlobUpload, error) {
+	return s.storage.GetUpload(ctx, userID, uploadID)
 }
 
-func (s *MaterialsService) UpdateCredDataByID(ctx context.Context, userID int, data domain.CredData) error {
-	return s.storage.UpdateCredDataByID(ctx, userID, data)
+// PutUploadChunk checks the chunk against its sha256 digest and the upload layout before storing it.
+func (s *MaterialsService) PutUploadChunk(ctx context.Context, userID int, uploadID string, seq int, data []byte, digest []byte) error {
+	upload, err := s.storage.GetUpload(ctx, userID, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if seq < 0 || seq >= upload.Chunks() || len(data) != upload.ChunkLen(seq) {
+		return domain.ErrBadChunk
+	}
+
+	sum := sha256.Sum256(data)
+	if subtle.ConstantTimeCompare(sum[:], digest) != 1 {
+		return domain.ErrBadChunk
+	}
+
+	return s.storage.PutUploadChunk(ctx, userID, uploadID, seq, data)
 }
 
-func (s *MaterialsService) CreateNewCredData(ctx context.Context, userID int, data domain.CredData) error {
-	return s.storage.CreateNewCredData(ctx, userID, data)
+func (s *MaterialsService) FinalizeUpload(ctx context.Context, userID int, uploadID string) (domain.BlobData, error) {
+	blob, err := s.storage.FinalizeUpload(ctx, userID, uploadID)
+	s.publish(err, userID, domain.EventCreated, domain.MaterialBlob, blob.ID)
+	return blob, err
+}
+
+func (s *MaterialsService) AbortUpload(ctx context.Context, userID int, uploadID string) error {
+	return s.storage.DeleteUpload(ctx, userID, uploadID)
+}
+
+//**********************************************************************************************************************
+// Trash
+//**********************************************************************************************************************
+
+func (s *MaterialsService) GetTrash(ctx context.Context, userID int) ([]domain.TrashItem, error) {
+	return s.storage.GetTrash(ctx, userID)
+}
+
+// RestoreFromTrash takes the trash id made by domain.TrashItemID.
+func (s *MaterialsService) RestoreFromTrash(ctx context.Context, userID int, trashID string) error {
+	t, id, err := domain.ParseTrashItemID(trashID)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.RestoreFromTrash(ctx, userID, t, id)
+	s.publish(err, userID, domain.EventRestored, t, id)
+	return err
 }
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+var loginRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@-]{2,63}$`)
+
+// PasswordPolicy is applied to new passwords. Existing ones are accepted on sign in as they are.
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	// MinClasses is the number of character classes (lower, upper, digits, others) a password should contain
+	MinClasses int
+	denyList   map[string]struct{}
+}
+
+// NewPasswordPolicy creates a policy with the built-in list of common passwords.
+// Passwords from denyListFile (one per line) are rejected too, if the file is set.
+func NewPasswordPolicy(minLength, minClasses int, denyListFile string) (*PasswordPolicy, error) {
+	p := &PasswordPolicy{
+		MinLength:  minLength,
+		MaxLength:  64,
+		MinClasses: minClasses,
+		denyList:   make(map[string]struct{}),
+	}
+
+	p.addToDenyList(bufio.NewScanner(strings.NewReader(commonPasswords)))
+
+	if denyListFile != "" {
+		f, err := os.Open(denyListFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		p.addToDenyList(scanner)
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+func (p *PasswordPolicy) addToDenyList(scanner *bufio.Scanner) {
+	for scanner.Scan() {
+		if password := strings.TrimSpace(scanner.Text()); password != "" {
+			p.denyList[strings.ToLower(password)] = struct{}{}
+		}
+	}
+}
+
+// Validate checks the login format and the password against the policy.
+func (p *PasswordPolicy) Validate(login, password string) error {
+	var verr domain.ValidationError
+
+	if !loginRe.MatchString(login) {
+		verr.Add("login", "must be 3-64 characters long, contain only latin letters, digits and . _ @ - and start with a letter or a digit")
+	}
+
+	length := utf8.RuneCountInString(password)
+	switch {
+	case length < p.MinLength:
+		verr.Add("password", fmt.Sprintf("must be at least %d characters long", p.MinLength))
+	case length > p.MaxLength:
+		verr.Add("password", fmt.Sprintf("must be at most %d characters long", p.MaxLength))
+	}
+
+	if classes := characterClasses(password); classes < p.MinClasses {
+		verr.Add("password", fmt.Sprintf("must contain at least %d of: lowercase letters, uppercase letters, digits, other characters", p.MinClasses))
+	}
+
+	if _, ok := p.denyList[strings.ToLower(password)]; ok {
+		verr.Add("password", "is too common")
+	}
+
+	if password != "" && strings.EqualFold(password, login) {
+		verr.Add("password", "must differ from the login")
+	}
+
+	return verr.Err()
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -6,6 +6,7 @@ import (
 	"gophkeeper/internal/storage"
 	"gophkeeper/pkg/auth"
 	"gophkeeper/pkg/hash"
+	"io"
 	"time"
 )
 
@@ -17,31 +18,77 @@ type UserSignUpInput struct {
 type UserSignInInput struct {
 	Login    string
 	Password string
+	Client   domain.SessionClient
+}
+
+type UserSignInCodeInput struct {
+	ChallengeToken string
+	Code           string
+	Client         domain.SessionClient
 }
 
 type Users interface {
 	SignUp(ctx context.Context, input UserSignUpInput) error
 	SignIn(ctx context.Context, input UserSignInInput) (domain.Tokens, error)
-	RefreshTokens(ctx context.Context, token string) (domain.Tokens, error)
+	SignInWithCode(ctx context.Context, input UserSignInCodeInput) (domain.Tokens, error)
+	RefreshTokens(ctx context.Context, token string, client domain.SessionClient) (domain.Tokens, error)
+	Logout(ctx context.Context, claims auth.Claims) error
+	LogoutAll(ctx context.Context, claims auth.Claims) error
+	GetSessions(ctx context.Context, claims auth.Claims) ([]domain.SessionInfo, error)
+	TerminateSession(ctx context.Context, userID int, sessionID string) error
+	IsTokenRevoked(ctx context.Context, tokenID string) (bool, error)
+
+	EnrollTOTP(ctx context.Context, userID int) (domain.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, userID int, code string) (domain.RecoveryCodes, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID int) (domain.RecoveryCodes, error)
+	GetRecoveryCodesStatus(ctx context.Context, userID int) (domain.RecoveryCodesStatus, error)
+
+	GetKeyCheck(ctx context.Context, userID int) (domain.SealedData, error)
+	SetKeyCheck(ctx context.Context, userID int, keyCheck domain.SealedData) error
 }
 
 //**********************************************************************************************************************
 type Materials interface {
-	GetAllTextData(ctx context.Context, userID int) ([]domain.TextData, error)
-	UpdateTextDataByID(ctx context.Context, userID int, data domain.TextData) error
-	CreateNewTextData(ctx context.Context, userID int, data domain.TextData) error
+	GetAll(ctx context.Context, userID int, t domain.MaterialType) ([]domain.SealedData, error)
+	GetPage(ctx context.Context, userID int, t domain.MaterialType, opts domain.ListOptions) (domain.Page, error)
+	GetByID(ctx context.Context, userID int, t domain.MaterialType, id int) (domain.SealedData, error)
+	UpdateByID(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData) (int, error)
+	ReserveID(ctx context.Context, userID int, t domain.MaterialType) (int, error)
+	Create(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData, idempotencyKey string) (domain.SealedData, error)
+	DeleteByID(ctx context.Context, userID int, t domain.MaterialType, id int) error
+
+	GetHistory(ctx context.Context, userID int, t domain.MaterialType, id int) ([]domain.Version, error)
+	RestoreVersion(ctx context.Context, userID int, t domain.MaterialType, id int, versionID int) error
 
-	GetAllCardData(ctx context.Context, userID int) ([]domain.CardData, error)
-	UpdateCardDataByID(ctx context.Context, userID int, data domain.CardData) error
-	CreateNewCardData(ctx context.Context, id int, data domain.CardData) error
+	GetChanges(ctx context.Context, userID int, since int64) (domain.SyncChanges, error)
 
-	GetAllCredData(ctx context.Context, userID int) ([]domain.CredData, error)
-	CreateNewCredData(ctx context.Context, id int, data domain.CredData) error
-	UpdateCredDataByID(ctx context.Context, userID int, data domain.CredData) error
+	CreateBlob(ctx context.Context, userID int, blob domain.BlobData, content io.Reader) (domain.BlobData, error)
+	GetAllBlobs(ctx context.Context, userID int) ([]domain.BlobData, error)
+	GetBlob(ctx context.Context, userID int, blobID int) (domain.BlobData, error)
+	ReadBlob(ctx context.Context, userID int, blobID int, w io.Writer) error
+	UpdateBlobMetadata(ctx context.Context, userID int, blob domain.BlobData) error
+	DeleteBlob(ctx context.Context, userID int, blobID int) error
+
+	CreateUpload(ctx context.Context, userID int, upload domain.BlobUpload) (domain.BlobUpload, error)
+	GetUpload(ctx context.Context, userID int, uploadID string) (domain.BlobUpload, error)
+	PutUploadChunk(ctx context.Context, userID int, uploadID string, seq int, data []byte, digest []byte) error
+	FinalizeUpload(ctx context.Context, userID int, uploadID string) (domain.BlobData, error)
+	AbortUpload(ctx context.Context, userID int, uploadID string) error
+
+	GetTrash(ctx context.Context, userID int) ([]domain.TrashItem, error)
+	RestoreFromTrash(ctx context.Context, userID int, trashID string) error
+}
+
+//**********************************************************************************************************************
+type Events interface {
+	Subscribe(userID int) (<-chan domain.MaterialEvent, func())
+	Close()
 }
 
 //**********************************************************************************************************************
 type Updater interface {
+	Start()
+	Stop()
 }
 
 //**********************************************************************************************************************
@@ -49,24 +96,34 @@ type Services struct {
 	Users     Users
 	Updater   Updater
 	Materials Materials
+	Events    Events
 }
 
 type Deps struct {
 	Storages        *storage.Storages
 	Hasher          hash.PasswordHasher
+	LegacyHasher    hash.PasswordHasher // hashes made by it are replaced with Hasher ones on sign in
 	TokenManager    auth.TokenManager
 	AccessTokenTTL  time.Duration
 	RefreshTokenTTL time.Duration
+	PasswordPolicy  *PasswordPolicy
+	MaxBlobSize     int64
+	TrashRetention  time.Duration
+	PurgeInterval   time.Duration
+	IdempotencyTTL  time.Duration
+	MaxVersions     int // versions kept per item
 }
 
 func NewServices(deps Deps) *Services {
-	users := NewUserService(deps.Hasher, deps.Storages.Users, deps.TokenManager, deps.AccessTokenTTL, deps.AccessTokenTTL)
-	updaterService := NewUpdaterService(deps.Storages.Users)
-	materials := NewMaterialsService(deps.Storages.Materials)
+	users := NewUserService(deps.Hasher, deps.LegacyHasher, deps.Storages.Users, deps.TokenManager, deps.AccessTokenTTL, deps.RefreshTokenTTL, deps.Storages.RevokedTokens, NewLoginThrottler(deps.Storages.LoginAttempts), deps.PasswordPolicy)
+	updaterService := NewUpdaterService(deps.Storages.Materials, deps.Storages.Users, deps.TrashRetention, deps.IdempotencyTTL, deps.MaxVersions, deps.PurgeInterval)
+	events := NewEventHub()
+	materials := NewMaterialsService(deps.Storages.Materials, deps.MaxBlobSize, events)
 
 	return &Services{
 		Users:     users,
 		Updater:   updaterService,
 		Materials: materials,
+		Events:    events,
 	}
 }
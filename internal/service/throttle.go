@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"gophkeeper/internal/domain"
+	"gophkeeper/internal/storage"
+	"time"
+)
+
+// LoginThrottler slows down password and second factor code guessing. Failed attempts are counted per login
+// and per client ip: after a few free attempts every next one has to wait twice as long, then the key is locked out
+// for a while.
+type LoginThrottler struct {
+	attempts     storage.LoginAttempts
+	freeAttempts int
+	baseDelay    time.Duration
+	lockoutAfter int
+	lockout      time.Duration
+	window       time.Duration   // failures older than that are forgotten
+	delays       []time.Duration // the wait after the number of failures
+}
+
+func NewLoginThrottler(attempts storage.LoginAttempts) *LoginThrottler {
+	t := &LoginThrottler{
+		attempts:     attempts,
+		freeAttempts: 3,
+		baseDelay:    time.Second,
+		lockoutAfter: 10,
+		lockout:      15 * time.Minute,
+		window:       time.Hour,
+	}
+	for failures := 0; failures <= t.lockoutAfter; failures++ {
+		t.delays = append(t.delays, t.delay(failures))
+	}
+	return t
+}
+
+// Reserve counts the attempt as failed against all the keys before the credentials are checked, so parallel
+// attempts can't get past the limit. ThrottledError is returned, if any of the keys has to wait.
+// The keys of the client go first, so a throttled client doesn't make the owner of the login wait longer.
+func (t *LoginThrottler) Reserve(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		attempts, ok, err := t.attempts.Reserve(ctx, key, t.window, t.delays)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			retryAfter := time.Until(attempts.LastFailedAt.Add(t.delay(attempts.Failures)))
+			if retryAfter < time.Second {
+				retryAfter = time.Second
+			}
+			return &domain.ThrottledError{RetryAfter: retryAfter}
+		}
+	}
+	return nil
+}
+
+// Release uncounts the reserved attempt, the earlier failures are kept. It's used when a step of sign in has
+// succeeded, but the whole sign in hasn't yet.
+func (t *LoginThrottler) Release(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		if err := t.attempts.Release(ctx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Succeed forgets the failures of the login after the user has signed in. The failures of the client ip are kept,
+// only the reserved attempt is uncounted, otherwise guessing could go on after signing in to one's own account.
+func (t *LoginThrottler) Succeed(ctx context.Context, login string, client domain.SessionClient) error {
+	if err := t.attempts.Reset(ctx, loginThrottleKey(login)); err != nil {
+		return err
+	}
+
+	if client.IP != "" {
+		return t.attempts.Release(ctx, ipThrottleKey(client.IP))
+	}
+	return nil
+}
+
+// delay is the time to wait after the last failure.
+func (t *LoginThrottler) delay(failures int) time.Duration {
+	switch {
+	case failures < t.freeAttempts:
+		return 0
+	case failures >= t.lockoutAfter:
+		return t.lockout
+	}
+
+	delay := t.baseDelay << (failures - t.freeAttempts)
+	if delay > t.lockout {
+		return t.lockout
+	}
+	return delay
+}
+
+func loginThrottleKey(login string) string {
+	return "login:" + login
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// throttleKeys are the counters one sign in attempt is accounted in, see Reserve for the order.
+func throttleKeys(login string, client domain.SessionClient) []string {
+	var keys []string
+	if client.IP != "" {
+		keys = append(keys, ipThrottleKey(client.IP))
+	}
+	return append(keys, loginThrottleKey(login))
+}
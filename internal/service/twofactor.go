@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"gophkeeper/internal/domain"
+	"gophkeeper/pkg/totp"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer   = "GophKeeper"
+	challengeTTL = 5 * time.Minute
+
+	recoveryCodesCount = 10
+	recoveryCodeBytes  = 10 // 80 bits, 16 characters of base32, the digests can't be brute-forced
+)
+
+// EnrollTOTP generates a new TOTP secret. It's enabled only after the first code is confirmed.
+func (s *UserService) EnrollTOTP(ctx context.Context, userID int) (domain.TOTPEnrollment, error) {
+	user, err := s.storage.GetByID(ctx, userID)
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	if user.TOTPEnabled {
+		return domain.TOTPEnrollment{}, domain.ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	if err := s.storage.SetTOTPSecret(ctx, userID, secret); err != nil {
+		return domain.TOTPEnrollment{}, err
+	}
+
+	return domain.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, user.Login, secret),
+	}, nil
+}
+
+// ConfirmTOTP enables the second factor, if code matches the pending secret.
+// The first set of recovery codes is returned then.
+func (s *UserService) ConfirmTOTP(ctx context.Context, userID int, code string) (domain.RecoveryCodes, error) {
+	user, err := s.storage.GetByID(ctx, userID)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	if user.TOTPEnabled {
+		return domain.RecoveryCodes{}, domain.ErrTOTPAlreadyEnabled
+	}
+
+	if user.TOTPSecret == "" {
+		return domain.RecoveryCodes{}, domain.ErrTOTPNotEnrolled
+	}
+
+	if err := s.checkTOTP(ctx, user, code); err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	if err := s.storage.EnableTOTP(ctx, userID); err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	return s.newRecoveryCodes(ctx, userID)
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user, the old ones stop working.
+func (s *UserService) RegenerateRecoveryCodes(ctx context.Context, userID int) (domain.RecoveryCodes, error) {
+	user, err := s.storage.GetByID(ctx, userID)
+	if err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	if !user.TOTPEnabled {
+		return domain.RecoveryCodes{}, domain.ErrTOTPNotEnabled
+	}
+
+	return s.newRecoveryCodes(ctx, userID)
+}
+
+func (s *UserService) GetRecoveryCodesStatus(ctx context.Context, userID int) (domain.RecoveryCodesStatus, error) {
+	user, err := s.storage.GetByID(ctx, userID)
+	if err != nil {
+		return domain.RecoveryCodesStatus{}, err
+	}
+
+	if !user.TOTPEnabled {
+		return domain.RecoveryCodesStatus{}, nil
+	}
+
+	n, err := s.storage.CountRecoveryCodes(ctx, userID)
+	if err != nil {
+		return domain.RecoveryCodesStatus{}, err
+	}
+
+	return domain.RecoveryCodesStatus{Enabled: true, Remaining: n}, nil
+}
+
+// SignInWithCode completes two-step sign in: the challenge from SignIn is exchanged for tokens.
+func (s *UserService) SignInWithCode(ctx context.Context, input UserSignInCodeInput) (domain.Tokens, error) {
+	challenge, err := s.storage.UseChallenge(ctx, input.ChallengeToken)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	user, err := s.storage.GetByID(ctx, challenge.UserID)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	// bad codes are counted like bad passwords, a new challenge doesn't give new guesses
+	if err := s.throttler.Reserve(ctx, throttleKeys(user.Login, input.Client)...); err != nil {
+		return domain.Tokens{}, err
+	}
+
+	if err := s.checkSecondFactor(ctx, user, input.Code); err != nil {
+		return domain.Tokens{}, err
+	}
+
+	if err := s.storage.DeleteChallenge(ctx, input.ChallengeToken); err != nil {
+		return domain.Tokens{}, err
+	}
+
+	if err := s.throttler.Succeed(ctx, user.Login, input.Client); err != nil {
+		return domain.Tokens{}, err
+	}
+
+	return s.createSession(ctx, user.ID, "", "", input.Client)
+}
+
+// checkSecondFactor accepts either a TOTP code or one of the recovery codes.
+func (s *UserService) checkSecondFactor(ctx context.Context, user domain.User, code string) error {
+	if isTOTPCode(code) {
+		return s.checkTOTP(ctx, user, code)
+	}
+
+	err := s.storage.UseRecoveryCode(ctx, user.ID, normalizeRecoveryCode(code))
+	if err != nil {
+		if errors.Is(err, domain.ErrRecoveryCodeNotFound) {
+			return domain.ErrBadSecondFactorCode
+		}
+		return err
+	}
+
+	return s.storage.CreateSecurityEvent(ctx, user.ID, domain.SecurityEvent{
+		Kind:      domain.SecurityEventRecoveryCodeUsed,
+		CreatedAt: time.Now(),
+	})
+}
+
+// checkTOTP validates code and marks its step as used, so the code can't be replayed.
+func (s *UserService) checkTOTP(ctx context.Context, user domain.User, code string) error {
+	step, ok, err := totp.Validate(user.TOTPSecret, code, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !ok || step <= user.TOTPLastStep {
+		return domain.ErrBadSecondFactorCode
+	}
+
+	return s.storage.UseTOTPStep(ctx, user.ID, step)
+}
+
+func (s *UserService) newChallenge(ctx context.Context, userID int) (domain.Tokens, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	err = s.storage.CreateChallenge(ctx, domain.Challenge{
+		Token:     token,
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(challengeTTL),
+	})
+	if err != nil {
+		return domain.Tokens{}, err
+	}
+
+	return domain.Tokens{ChallengeToken: token}, nil
+}
+
+func (s *UserService) newRecoveryCodes(ctx context.Context, userID int) (domain.RecoveryCodes, error) {
+	codes := make([]string, recoveryCodesCount)
+	normalized := make([]string, recoveryCodesCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(b); err != nil {
+			return domain.RecoveryCodes{}, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		normalized[i] = code
+		codes[i] = code[:len(code)/2] + "-" + code[len(code)/2:]
+	}
+
+	if err := s.storage.ReplaceRecoveryCodes(ctx, userID, normalized); err != nil {
+		return domain.RecoveryCodes{}, err
+	}
+
+	return domain.RecoveryCodes{Codes: codes}, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes in any case and with or without dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != totp.Digits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"gophkeeper/internal/domain"
+	"gophkeeper/internal/storage"
+	"gophkeeper/pkg/totp"
+	"testing"
+	"time"
+)
+
+// totpStorage keeps the last used steps, other methods of storage.Users are not used by checkTOTP.
+type totpStorage struct {
+	storage.Users
+	steps map[int]int64
+}
+
+func (s *totpStorage) UseTOTPStep(_ context.Context, userID int, step int64) error {
+	s.steps[userID] = step
+	return nil
+}
+
+func TestCheckTOTP(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	current := totp.Step(time.Now())
+
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		lastStep int64
+		code     string
+		err      error
+		used     int64
+	}{
+		{name: "current step", lastStep: current - 5, code: code(current), used: current},
+		{name: "previous step within skew", lastStep: current - 5, code: code(current - 1), used: current - 1},
+		{name: "next step within skew", lastStep: current - 5, code: code(current + 1), used: current + 1},
+		{name: "step out of skew", lastStep: current - 5, code: code(current - 2), err: domain.ErrBadSecondFactorCode},
+		{name: "replay of the used step", lastStep: current, code: code(current), err: domain.ErrBadSecondFactorCode},
+		{name: "step older than the used one", lastStep: current, code: code(current - 1), err: domain.ErrBadSecondFactorCode},
+		{name: "step newer than the used one", lastStep: current - 1, code: code(current), used: current},
+		{name: "wrong code", lastStep: current - 5, code: "abcdef", err: domain.ErrBadSecondFactorCode},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &totpStorage{steps: map[int]int64{}}
+			s := &UserService{storage: st}
+			user := domain.User{ID: 1, TOTPSecret: secret, TOTPLastStep: tt.lastStep}
+
+			err := s.checkTOTP(context.Background(), user, tt.code)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+
+			used, ok := st.steps[user.ID]
+			if tt.err != nil {
+				if ok {
+					t.Errorf("step %d is used by the rejected code", used)
+				}
+				return
+			}
+			if used != tt.used {
+				t.Errorf("used step %d, want %d", used, tt.used)
+			}
+		})
+	}
+}
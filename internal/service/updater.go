@@ -3,20 +3,160 @@ package service
 import (
 	"context"
 	"gophkeeper/internal/storage"
+	"log"
 	"sync"
+	"time"
 )
 
+// UpdaterService does the periodic housekeeping in background: it purges the old trash, idempotency keys,
+// unused id reservations, expired sessions and old versions of items.
 type UpdaterService struct {
-	storage storage.Users
+	storage      storage.Materials
+	users        storage.Users
+	retention    time.Duration // how long deleted items stay in the trash
+	keyRetention time.Duration // how long idempotency keys and id reservations are kept
+	maxVersions  int           // how many versions of an item are kept
+	interval     time.Duration
 
-	ctx context.Context
+	ctx    context.Context
 	cancel context.CancelFunc
-	mu sync.Mutex
+	mu     sync.Mutex
+	done   chan struct{}
 }
 
-func NewUpdaterService(storage storage.Users) *UpdaterService {
+func NewUpdaterService(storage storage.Materials, users storage.Users, retention, keyRetention time.Duration, maxVersions int, interval time.Duration) *UpdaterService {
 	us := &UpdaterService{
-		storage: storage,
+		storage:      storage,
+		users:        users,
+		retention:    retention,
+		keyRetention: keyRetention,
+		maxVersions:  maxVersions,
+		interval:     interval,
 	}
 	return us
 }
+
+// Start runs the worker, it does nothing if the worker is already running.
+// The purge is off, if the interval isn't positive.
+func (s *UpdaterService) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel != nil || s.interval <= 0 {
+		return
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan struct{})
+	go s.run(s.ctx, s.done)
+}
+
+// Stop cancels the current purge and waits until the worker exits.
+func (s *UpdaterService) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancel == nil {
+		return
+	}
+
+	s.cancel()
+	<-s.done
+	s.cancel = nil
+}
+
+func (s *UpdaterService) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.purgeTrash(ctx)
+		s.purgeIdempotencyKeys(ctx)
+		s.purgeReservedIDs(ctx)
+		s.purgeSessions(ctx)
+		s.purgeHistory(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *UpdaterService) purgeTrash(ctx context.Context) {
+	n, err := s.storage.PurgeTrash(ctx, time.Now().Add(-s.retention))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("trash purge: %v", err)
+		}
+		return
+	}
+
+	if n > 0 {
+		log.Printf("trash purge: %d items removed", n)
+	}
+}
+
+func (s *UpdaterService) purgeIdempotencyKeys(ctx context.Context) {
+	n, err := s.storage.PurgeIdempotencyKeys(ctx, time.Now().Add(-s.keyRetention))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("idempotency keys purge: %v", err)
+		}
+		return
+	}
+
+	if n > 0 {
+		log.Printf("idempotency keys purge: %d keys removed", n)
+	}
+}
+
+func (s *UpdaterService) purgeReservedIDs(ctx context.Context) {
+	n, err := s.storage.PurgeReservedIDs(ctx, time.Now().Add(-s.keyRetention))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("reserved ids purge: %v", err)
+		}
+		return
+	}
+
+	if n > 0 {
+		log.Printf("reserved ids purge: %d ids removed", n)
+	}
+}
+
+func (s *UpdaterService) purgeSessions(ctx context.Context) {
+	n, err := s.users.PurgeSessions(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("sessions purge: %v", err)
+		}
+		return
+	}
+
+	if n > 0 {
+		log.Printf("sessions purge: %d tokens removed", n)
+	}
+}
+
+// purgeHistory keeps all versions, if maxVersions isn't positive.
+func (s *UpdaterService) purgeHistory(ctx context.Context) {
+	if s.maxVersions <= 0 {
+		return
+	}
+
+	n, err := s.storage.PurgeHistory(ctx, s.maxVersions)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("history purge: %v", err)
+		}
+		return
+	}
+
+	if n > 0 {
+		log.Printf("history purge: %d versions removed", n)
+	}
+}
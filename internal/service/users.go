@@ -2,34 +2,53 @@ package service
 
 import (
 	"context"
+	"crypto/rand"
 	"errors"
+	"fmt"
 	"gophkeeper/internal/domain"
 	"gophkeeper/internal/storage"
 	"gophkeeper/pkg/auth"
 	"gophkeeper/pkg/hash"
 	"strconv"
+	"sync"
 	"time"
 )
 
 type UserService struct {
 	hasher      	hash.PasswordHasher
+	legacyHasher	hash.PasswordHasher
 	storage 		storage.Users
 	tokenManager 	auth.TokenManager
 	accessTokenTTL	time.Duration
 	refreshTokenTTL	time.Duration
+	revokedTokens	storage.RevokedTokens
+	throttler		*LoginThrottler
+	policy			*PasswordPolicy
+
+	// hash checked for unknown logins, see verifyDummy
+	dummyHash		string
+	dummyOnce		sync.Once
 }
 
-func NewUserService(h hash.PasswordHasher, s storage.Users, tm auth.TokenManager, at time.Duration, rt time.Duration) *UserService {
+func NewUserService(h hash.PasswordHasher, lh hash.PasswordHasher, s storage.Users, tm auth.TokenManager, at time.Duration, rt time.Duration, rts storage.RevokedTokens, lt *LoginThrottler, pp *PasswordPolicy) *UserService {
 	return &UserService{
 		hasher: h,
+		legacyHasher: lh,
 		storage: s,
 		tokenManager: tm,
 		accessTokenTTL:	at,
 		refreshTokenTTL: rt,
+		revokedTokens: rts,
+		throttler: lt,
+		policy: pp,
 	}
 }
 
 func (s *UserService) SignUp(ctx context.Context, input UserSignUpInput) error {
+	if err := s.policy.Validate(input.Login, input.Password); err != nil {
+		return err
+	}
+
 	passwordHash, err := s.hasher.Hash(input.Password)
 	if err != nil {
 		return err
@@ -47,40 +66,136 @@ func (s *UserService) SignUp(ctx context.Context, input UserSignUpInput) error {
 }
 
 func (s *UserService) SignIn(ctx context.Context, input UserSignInInput) (domain.Tokens, error) {
-	passwordHash, err := s.hasher.Hash(input.Password)
-	if err != nil {
+	// the attempt stays counted as failed, unless the user signs in
+	keys := throttleKeys(input.Login, input.Client)
+	if err := s.throttler.Reserve(ctx, keys...); err != nil {
 		return domain.Tokens{}, err
 	}
 
-	user, err := s.storage.GetByCredentials(ctx, input.Login, passwordHash)
+	// unknown logins and wrong passwords take the same time and get the same error
+	user, err := s.storage.GetByLogin(ctx, input.Login)
+	switch {
+	case errors.Is(err, domain.ErrUserNotFound):
+		s.verifyDummy(input.Password)
+		err = domain.ErrWrongLoginOrPassword
+	case err == nil:
+		err = s.verifyPassword(ctx, user, input.Password)
+	}
 	if err != nil {
-		if errors.Is(err, domain.ErrUserNotFound) {
+		return domain.Tokens{}, err
+	}
+
+	if user.TOTPEnabled {
+		// failures are forgotten after the second factor only, so bad codes add up across challenges
+		if err := s.throttler.Release(ctx, keys...); err != nil {
 			return domain.Tokens{}, err
 		}
+		return s.newChallenge(ctx, user.ID)
+	}
 
+	if err := s.throttler.Succeed(ctx, user.Login, input.Client); err != nil {
 		return domain.Tokens{}, err
 	}
 
-	return s.createSession(ctx, user.ID, "")
+	return s.createSession(ctx, user.ID, "", "", input.Client)
+}
+
+// verifyPassword checks user's password. Legacy hashes are transparently replaced with the current hasher ones.
+func (s *UserService) verifyPassword(ctx context.Context, user domain.User, password string) error {
+	ok, err := s.hasher.Verify(password, user.Password)
+	if errors.Is(err, hash.ErrUnsupportedHash) && s.legacyHasher != nil {
+		ok, err = s.legacyHasher.Verify(password, user.Password)
+		if err == nil && ok {
+			passwordHash, err := s.hasher.Hash(password)
+			if err != nil {
+				return err
+			}
+
+			if err := s.storage.UpdatePassword(ctx, user.ID, passwordHash); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return domain.ErrWrongLoginOrPassword
+	}
+
+	return nil
+}
+
+// verifyDummy checks the password against a hash of the current hasher, so a sign in with an unknown login
+// takes as long as one with a wrong password.
+func (s *UserService) verifyDummy(password string) {
+	s.dummyOnce.Do(func() {
+		s.dummyHash, _ = s.hasher.Hash("password of the unknown user")
+	})
+	_, _ = s.hasher.Verify(password, s.dummyHash)
 }
 
-//TODO add old tokens to the black list until thea are spoiled
-func (s *UserService) RefreshTokens(ctx context.Context, token string) (domain.Tokens, error) {
-	user, err := s.storage.GetByRefreshToken(ctx, token)
+func (s *UserService) RefreshTokens(ctx context.Context, token string, client domain.SessionClient) (domain.Tokens, error) {
+	session, err := s.storage.GetSession(ctx, token)
 	if err != nil {
 		return domain.Tokens{}, err
 	}
 
-	return s.createSession(ctx, user.ID, token)
+	if session.RotatedAt != nil {
+		return domain.Tokens{}, s.revokeReusedFamily(ctx, session)
+	}
+
+	tokens, err := s.createSession(ctx, session.UserID, session.FamilyID, token, client)
+	if errors.Is(err, domain.ErrRefreshTokenReused) {
+		return domain.Tokens{}, s.revokeReusedFamily(ctx, session)
+	}
+
+	return tokens, err
+}
+
+// revokeReusedFamily is called when rotated refresh token is presented again. Either the legitimate user
+// or the attacker has a stolen token, so the whole family is revoked and both have to sign in again.
+func (s *UserService) revokeReusedFamily(ctx context.Context, session domain.Session) error {
+	sessions, err := s.storage.RevokeSessionFamily(ctx, session.UserID, session.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeAccessTokens(ctx, sessions); err != nil {
+		return err
+	}
+
+	err = s.storage.CreateSecurityEvent(ctx, session.UserID, domain.SecurityEvent{
+		Kind:      domain.SecurityEventRefreshTokenReuse,
+		Details:   "token family " + session.FamilyID + " revoked",
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return domain.ErrRefreshTokenReused
 }
 
-func (s *UserService) createSession(ctx context.Context, userID int, token string) (domain.Tokens, error) {
+// createSession issues new tokens. If token is empty, a new token family is started,
+// otherwise token is rotated within familyID.
+func (s *UserService) createSession(ctx context.Context, userID int, familyID string, token string, client domain.SessionClient) (domain.Tokens, error) {
 	var (
-		res domain.Tokens
-		err error
+		res    domain.Tokens
+		claims auth.Claims
+		err    error
 	)
 
-	res.AccessToken, err = s.tokenManager.NewJWT(strconv.Itoa(userID), s.accessTokenTTL)
+	if token == "" {
+		familyID, err = randomHex(16)
+		if err != nil {
+			return res, err
+		}
+	}
+
+	res.AccessToken, claims, err = s.tokenManager.NewJWT(strconv.Itoa(userID), familyID, s.accessTokenTTL)
 	if err != nil {
 		return res, err
 	}
@@ -91,15 +206,118 @@ func (s *UserService) createSession(ctx context.Context, userID int, token strin
 	}
 
 	session := domain.Session{
-		RefreshToken: res.RefreshToken,
-		ExpiresAt:    time.Now().Add(s.refreshTokenTTL),
+		RefreshToken:         res.RefreshToken,
+		FamilyID:             familyID,
+		ExpiresAt:            time.Now().Add(s.refreshTokenTTL),
+		Client:               client,
+		AccessTokenID:        claims.TokenID,
+		AccessTokenExpiresAt: claims.ExpiresAt,
 	}
 
 	if token == "" {
 		err = s.storage.SetSession(ctx, userID, session)
 	} else {
-		err = s.storage.UpdateSession(ctx, userID, session, token)
+		err = s.storage.RotateSession(ctx, userID, session, token)
 	}
 
 	return res, err
 }
+
+// Logout ends the session of the access token and revokes the token itself.
+func (s *UserService) Logout(ctx context.Context, claims auth.Claims) error {
+	userID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.storage.RevokeSessionFamily(ctx, userID, claims.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeAccessTokens(ctx, sessions); err != nil {
+		return err
+	}
+
+	return s.revokedTokens.Revoke(ctx, claims.TokenID, claims.ExpiresAt)
+}
+
+// LogoutAll ends all sessions of the user and revokes the access token.
+func (s *UserService) LogoutAll(ctx context.Context, claims auth.Claims) error {
+	userID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.storage.RevokeUserSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.revokeAccessTokens(ctx, sessions); err != nil {
+		return err
+	}
+
+	return s.revokedTokens.Revoke(ctx, claims.TokenID, claims.ExpiresAt)
+}
+
+// GetSessions returns active sessions of the user, the session of the access token is marked as current.
+func (s *UserService) GetSessions(ctx context.Context, claims auth.Claims) ([]domain.SessionInfo, error) {
+	userID, err := strconv.Atoi(claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := s.storage.GetUserSessions(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == claims.SessionID
+	}
+
+	return sessions, nil
+}
+
+// TerminateSession ends any session of the user, e.g. on a lost device.
+func (s *UserService) TerminateSession(ctx context.Context, userID int, sessionID string) error {
+	sessions, err := s.storage.RevokeSessionFamily(ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		return domain.ErrSessionNotFound
+	}
+
+	return s.revokeAccessTokens(ctx, sessions)
+}
+
+func (s *UserService) IsTokenRevoked(ctx context.Context, tokenID string) (bool, error) {
+	return s.revokedTokens.IsRevoked(ctx, tokenID)
+}
+
+// revokeAccessTokens revokes the access tokens of deleted sessions, which are not expired yet.
+func (s *UserService) revokeAccessTokens(ctx context.Context, sessions []domain.Session) error {
+	for _, session := range sessions {
+		if session.AccessTokenID == "" || session.AccessTokenExpiresAt.Before(time.Now()) {
+			continue
+		}
+
+		if err := s.revokedTokens.Revoke(ctx, session.AccessTokenID, session.AccessTokenExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
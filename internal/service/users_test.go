@@ -0,0 +1,81 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"gophkeeper/internal/domain"
+	"gophkeeper/internal/storage"
+	"gophkeeper/pkg/hash"
+	"strings"
+	"testing"
+)
+
+// passwordStorage keeps the updated password hashes, other methods of storage.Users are not used by verifyPassword.
+type passwordStorage struct {
+	storage.Users
+	passwords map[int]string
+}
+
+func (s *passwordStorage) UpdatePassword(_ context.Context, userID int, passwordHash string) error {
+	s.passwords[userID] = passwordHash
+	return nil
+}
+
+func TestVerifyPasswordUpgradesLegacyHashes(t *testing.T) {
+	legacy := hash.NewSHA1Hasher("salt")
+	legacyHash, err := legacy.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &passwordStorage{passwords: map[int]string{}}
+	s := &UserService{hasher: hash.NewArgon2Hasher(), legacyHasher: legacy, storage: st}
+	user := domain.User{ID: 1, Password: legacyHash}
+
+	if err := s.verifyPassword(context.Background(), user, "wrong"); !errors.Is(err, domain.ErrWrongLoginOrPassword) {
+		t.Fatalf("wrong password: got %v, want ErrWrongLoginOrPassword", err)
+	}
+	if _, ok := st.passwords[user.ID]; ok {
+		t.Fatal("hash is upgraded after a wrong password")
+	}
+
+	if err := s.verifyPassword(context.Background(), user, "secret"); err != nil {
+		t.Fatalf("right password: %v", err)
+	}
+
+	upgraded, ok := st.passwords[user.ID]
+	if !ok {
+		t.Fatal("legacy hash isn't upgraded")
+	}
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("hash is upgraded to %s", upgraded)
+	}
+
+	// the next sign in is checked by the current hasher, the legacy one isn't needed
+	user.Password = upgraded
+	s.legacyHasher = nil
+	if err := s.verifyPassword(context.Background(), user, "secret"); err != nil {
+		t.Errorf("upgraded hash: %v", err)
+	}
+	if err := s.verifyPassword(context.Background(), user, "wrong"); !errors.Is(err, domain.ErrWrongLoginOrPassword) {
+		t.Errorf("upgraded hash, wrong password: got %v, want ErrWrongLoginOrPassword", err)
+	}
+}
+
+func TestVerifyPasswordWithoutLegacyHasher(t *testing.T) {
+	legacyHash, err := hash.NewSHA1Hasher("salt").Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := &passwordStorage{passwords: map[int]string{}}
+	s := &UserService{hasher: hash.NewArgon2Hasher(), storage: st}
+
+	err = s.verifyPassword(context.Background(), domain.User{ID: 1, Password: legacyHash}, "secret")
+	if !errors.Is(err, hash.ErrUnsupportedHash) {
+		t.Errorf("got %v, want ErrUnsupportedHash", err)
+	}
+	if len(st.passwords) > 0 {
+		t.Error("hash is upgraded without the legacy hasher")
+	}
+}
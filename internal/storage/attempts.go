@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"gophkeeper/internal/domain"
+	"sync"
+	"time"
+)
+
+//**********************************************************************************************************************
+// PostgreSQL
+//**********************************************************************************************************************
+
+// LoginAttemptsStorage keeps failed sign in attempts in PostgreSQL, so they are shared by all server instances.
+type LoginAttemptsStorage struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptsStorage(db *sql.DB) *LoginAttemptsStorage {
+	return &LoginAttemptsStorage{
+		db: db,
+	}
+}
+
+func (r *LoginAttemptsStorage) Get(ctx context.Context, key string) (domain.LoginAttempts, error) {
+	var attempts domain.LoginAttempts
+
+	getStmt, err := r.db.PrepareContext(ctx, "SELECT failures,last_failed_at FROM login_attempts WHERE key = $1;")
+	if err != nil {
+		return attempts, &StatementPSQLError{Err: err}
+	}
+	defer getStmt.Close()
+
+	if err := getStmt.QueryRowContext(ctx, key).Scan(&attempts.Failures, &attempts.LastFailedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return attempts, nil
+		}
+		return attempts, &ExecutionPSQLError{Err: err}
+	}
+
+	return attempts, nil
+}
+
+// Reserve checks the delay and counts the attempt in one statement, so parallel attempts can't all pass the check.
+func (r *LoginAttemptsStorage) Reserve(ctx context.Context, key string, window time.Duration, delays []time.Duration) (domain.LoginAttempts, bool, error) {
+	var attempts domain.LoginAttempts
+
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE last_failed_at <= now() - $1 * interval '1 second';",
+		window.Seconds()); err != nil {
+		return attempts, false, &ExecutionPSQLError{Err: err}
+	}
+
+	seconds := make([]float64, 0, len(delays))
+	for _, delay := range delays {
+		seconds = append(seconds, delay.Seconds())
+	}
+
+	reserveStmt, err := r.db.PrepareContext(ctx, `INSERT INTO login_attempts (key, failures, last_failed_at) VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET failures = login_attempts.failures + 1, last_failed_at = now()
+		WHERE login_attempts.last_failed_at + ($2::float8[])[least(login_attempts.failures, $3) + 1] * interval '1 second' <= now()
+		RETURNING failures,last_failed_at;`)
+	if err != nil {
+		return attempts, false, &StatementPSQLError{Err: err}
+	}
+	defer reserveStmt.Close()
+
+	err = reserveStmt.QueryRowContext(ctx, key, pq.Array(seconds), len(seconds)-1).Scan(&attempts.Failures, &attempts.LastFailedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the key has to wait
+		attempts, err = r.Get(ctx, key)
+		return attempts, false, err
+	}
+	if err != nil {
+		return attempts, false, &ExecutionPSQLError{Err: err}
+	}
+
+	return attempts, true, nil
+}
+
+func (r *LoginAttemptsStorage) Release(ctx context.Context, key string) error {
+	releaseStmt, err := r.db.PrepareContext(ctx, "UPDATE login_attempts SET failures = failures - 1 WHERE key = $1 and failures > 0;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer releaseStmt.Close()
+
+	if _, err := releaseStmt.ExecContext(ctx, key); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+func (r *LoginAttemptsStorage) Reset(ctx context.Context, keys ...string) error {
+	resetStmt, err := r.db.PrepareContext(ctx, "DELETE FROM login_attempts WHERE key = $1;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer resetStmt.Close()
+
+	for _, key := range keys {
+		if _, err := resetStmt.ExecContext(ctx, key); err != nil {
+			return &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return nil
+}
+
+//**********************************************************************************************************************
+// In memory
+//**********************************************************************************************************************
+
+// LoginAttemptsMemStorage keeps failed sign in attempts in memory. It suits a single server instance only.
+type LoginAttemptsMemStorage struct {
+	mu       sync.Mutex
+	attempts map[string]domain.LoginAttempts
+}
+
+func NewLoginAttemptsMemStorage() *LoginAttemptsMemStorage {
+	return &LoginAttemptsMemStorage{
+		attempts: make(map[string]domain.LoginAttempts),
+	}
+}
+
+func (r *LoginAttemptsMemStorage) Get(_ context.Context, key string) (domain.LoginAttempts, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.attempts[key], nil
+}
+
+func (r *LoginAttemptsMemStorage) Reserve(_ context.Context, key string, window time.Duration, delays []time.Duration) (domain.LoginAttempts, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for k, a := range r.attempts {
+		if !a.LastFailedAt.Add(window).After(now) {
+			delete(r.attempts, k)
+		}
+	}
+
+	attempts, ok := r.attempts[key]
+	if ok {
+		delay := delays[len(delays)-1]
+		if attempts.Failures < len(delays) {
+			delay = delays[attempts.Failures]
+		}
+		if attempts.LastFailedAt.Add(delay).After(now) {
+			return attempts, false, nil
+		}
+	}
+
+	attempts.Failures++
+	attempts.LastFailedAt = now
+	r.attempts[key] = attempts
+
+	return attempts, true, nil
+}
+
+func (r *LoginAttemptsMemStorage) Release(_ context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if attempts, ok := r.attempts[key]; ok && attempts.Failures > 0 {
+		attempts.Failures--
+		r.attempts[key] = attempts
+	}
+	return nil
+}
+
+func (r *LoginAttemptsMemStorage) Reset(_ context.Context, keys ...string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range keys {
+		delete(r.attempts, key)
+	}
+	return nil
+}
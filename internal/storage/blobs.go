@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeeper/internal/domain"
+	"io"
+)
+
+// blobChunkSize is the size of one blob_chunks row. Files are written and read chunk by chunk,
+// so they never have to fit in memory.
+const blobChunkSize = 1024 * 1024
+
+// CreateBlob stores metadata and streams content into chunks. Nothing is stored, if reading content fails.
+func (r *MaterialsStorage) CreateBlob(ctx context.Context, userID int, blob domain.BlobData, content io.Reader) (domain.BlobData, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, "INSERT INTO blob_data (user_id, metadata, nonce) VALUES ($1, $2, $3) RETURNING id,created_at;",
+		userID, blob.Metadata, blob.Nonce).Scan(&blob.ID, &blob.CreatedAt)
+	if err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	size, err := writeBlobChunks(ctx, tx, blob.ID, 0, content)
+	if err != nil {
+		return blob, err
+	}
+	blob.Size = size
+
+	if _, err := tx.ExecContext(ctx, "UPDATE blob_data SET size = $1 WHERE id = $2;", blob.Size, blob.ID); err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	return blob, nil
+}
+
+// writeBlobChunks splits content into rows starting with seq and returns the number of written bytes.
+func writeBlobChunks(ctx context.Context, tx *sql.Tx, blobID int, seq int, content io.Reader) (int64, error) {
+	crChunkStmt, err := tx.PrepareContext(ctx, "INSERT INTO blob_chunks (blob_id, seq, data) VALUES ($1, $2, $3);")
+	if err != nil {
+		return 0, &StatementPSQLError{Err: err}
+	}
+	defer crChunkStmt.Close()
+
+	var size int64
+	buf := make([]byte, blobChunkSize)
+	for {
+		n, err := io.ReadFull(content, buf)
+		if n > 0 {
+			if _, err := crChunkStmt.ExecContext(ctx, blobID, seq, buf[:n]); err != nil {
+				return size, &ExecutionPSQLError{Err: err}
+			}
+			size += int64(n)
+			seq++
+		}
+
+		switch {
+		case err == nil:
+			continue
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return size, nil
+		default:
+			return size, err
+		}
+	}
+}
+
+func (r *MaterialsStorage) GetAllBlobs(ctx context.Context, userID int) ([]domain.BlobData, error) {
+	getBlobsStmt, err := r.db.PrepareContext(ctx, "SELECT id,metadata,nonce,size,created_at FROM blob_data WHERE user_id=$1 and deleted_at IS NULL;")
+	if err != nil {
+		return nil, &StatementPSQLError{Err: err}
+	}
+	defer getBlobsStmt.Close()
+
+	rows, err := getBlobsStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	blobs := make([]domain.BlobData, 0)
+	for rows.Next() {
+		var blob domain.BlobData
+		if err := rows.Scan(&blob.ID, &blob.Metadata, &blob.Nonce, &blob.Size, &blob.CreatedAt); err != nil {
+			return nil, &ExecutionPSQLError{Err: err}
+		}
+
+		blobs = append(blobs, blob)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+
+	return blobs, nil
+}
+
+func (r *MaterialsStorage) GetBlob(ctx context.Context, userID int, blobID int) (domain.BlobData, error) {
+	var blob domain.BlobData
+
+	getBlobStmt, err := r.db.PrepareContext(ctx, "SELECT id,metadata,nonce,size,created_at FROM blob_data WHERE user_id=$1 and id=$2 and deleted_at IS NULL;")
+	if err != nil {
+		return blob, &StatementPSQLError{Err: err}
+	}
+	defer getBlobStmt.Close()
+
+	err = getBlobStmt.QueryRowContext(ctx, userID, blobID).Scan(&blob.ID, &blob.Metadata, &blob.Nonce, &blob.Size, &blob.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return blob, &NotFoundError{Err: domain.ErrDataNotFound}
+		default:
+			return blob, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return blob, nil
+}
+
+// ReadBlob streams blob content into w chunk by chunk.
+func (r *MaterialsStorage) ReadBlob(ctx context.Context, userID int, blobID int, w io.Writer) error {
+	readBlobStmt, err := r.db.PrepareContext(ctx, `SELECT c.data FROM blob_chunks c JOIN blob_data b ON b.id = c.blob_id
+		WHERE b.user_id = $1 and b.id = $2 and b.deleted_at IS NULL ORDER BY c.seq;`)
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer readBlobStmt.Close()
+
+	rows, err := readBlobStmt.QueryContext(ctx, userID, blobID)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	var chunk []byte
+	for rows.Next() {
+		if err := rows.Scan(&chunk); err != nil {
+			return &ExecutionPSQLError{Err: err}
+		}
+
+		if _, err := w.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// UpdateBlobMetadata replaces sealed metadata, the content of a blob can't be changed.
+func (r *MaterialsStorage) UpdateBlobMetadata(ctx context.Context, userID int, blob domain.BlobData) error {
+	updateBlobStmt, err := r.db.PrepareContext(ctx, "UPDATE blob_data SET metadata = $1, nonce = $2 WHERE user_id = $3 and id = $4 and deleted_at IS NULL;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer updateBlobStmt.Close()
+
+	res, err := updateBlobStmt.ExecContext(ctx, blob.Metadata, blob.Nonce, userID, blob.ID)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrDataNotFound}
+	}
+
+	return nil
+}
+
+// DeleteBlob moves a blob to the trash, its chunks are kept until the blob is purged.
+func (r *MaterialsStorage) DeleteBlob(ctx context.Context, userID int, blobID int) error {
+	delBlobStmt, err := r.db.PrepareContext(ctx, "UPDATE blob_data SET deleted_at = now() WHERE user_id = $1 and id = $2 and deleted_at IS NULL;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer delBlobStmt.Close()
+
+	res, err := delBlobStmt.ExecContext(ctx, userID, blobID)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrDataNotFound}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeeper/internal/domain"
+)
+
+// maxChallengeAttempts limits guessing of the second factor code within one challenge.
+const maxChallengeAttempts = 5
+
+func (r *UserStorage) CreateChallenge(ctx context.Context, challenge domain.Challenge) error {
+	crChallengeStmt, err := r.db.PrepareContext(ctx, "INSERT INTO sign_in_challenges (token,user_id,expired_at) VALUES ($1, $2, $3);")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer crChallengeStmt.Close()
+
+	if _, err := crChallengeStmt.ExecContext(ctx, hashToken(challenge.Token), challenge.UserID, challenge.ExpiresAt); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// UseChallenge counts an attempt to pass the challenge. ErrChallengeNotFound is returned
+// if the challenge is expired or has no attempts left.
+func (r *UserStorage) UseChallenge(ctx context.Context, token string) (domain.Challenge, error) {
+	challenge := domain.Challenge{Token: token}
+
+	useChallengeStmt, err := r.db.PrepareContext(ctx, `UPDATE sign_in_challenges SET attempts = attempts + 1
+		WHERE token = $1 and expired_at > now() and attempts < $2 RETURNING user_id,expired_at,attempts;`)
+	if err != nil {
+		return challenge, &StatementPSQLError{Err: err}
+	}
+	defer useChallengeStmt.Close()
+
+	if err := useChallengeStmt.QueryRowContext(ctx, hashToken(token), maxChallengeAttempts).Scan(&challenge.UserID, &challenge.ExpiresAt, &challenge.Attempts); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return challenge, &NotFoundError{Err: domain.ErrChallengeNotFound}
+		default:
+			return challenge, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return challenge, nil
+}
+
+// DeleteChallenge removes passed challenge together with the expired ones.
+func (r *UserStorage) DeleteChallenge(ctx context.Context, token string) error {
+	delChallengeStmt, err := r.db.PrepareContext(ctx, "DELETE FROM sign_in_challenges WHERE token = $1 or expired_at <= now();")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer delChallengeStmt.Close()
+
+	if _, err := delChallengeStmt.ExecContext(ctx, hashToken(token)); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+)
+
+// saveVersion copies the current value of the item to the history and locks the item until tx ends.
+// NotFoundError is returned, if there is no such item or it's in the trash.
+func saveVersion(ctx context.Context, tx *sql.Tx, table string, userID int, t domain.MaterialType, id int) error {
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO material_history (material_type, item_id, user_id, data, nonce)
+		SELECT $1, id, user_id, data, nonce FROM %s WHERE user_id = $2 and id = $3 and deleted_at IS NULL FOR UPDATE;`, table),
+		t, userID, id)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrDataNotFound}
+	}
+
+	return nil
+}
+
+// GetHistory returns previous values of the item, recent ones go first.
+func (r *MaterialsStorage) GetHistory(ctx context.Context, userID int, t domain.MaterialType, id int) ([]domain.Version, error) {
+	table, err := materialTable(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = r.db.QueryRowContext(ctx, fmt.Sprintf("SELECT true FROM %s WHERE user_id = $1 and id = $2 and deleted_at IS NULL;", table),
+		userID, id).Scan(&exists)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, &NotFoundError{Err: domain.ErrDataNotFound}
+		default:
+			return nil, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	getHistoryStmt, err := r.db.PrepareContext(ctx, `SELECT id,data,nonce,replaced_at FROM material_history
+		WHERE user_id = $1 and material_type = $2 and item_id = $3 ORDER BY id DESC;`)
+	if err != nil {
+		return nil, &StatementPSQLError{Err: err}
+	}
+	defer getHistoryStmt.Close()
+
+	rows, err := getHistoryStmt.QueryContext(ctx, userID, t, id)
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	versions := make([]domain.Version, 0)
+	for rows.Next() {
+		var version domain.Version
+		if err := rows.Scan(&version.ID, &version.Data, &version.Nonce, &version.ReplacedAt); err != nil {
+			return nil, &ExecutionPSQLError{Err: err}
+		}
+
+		versions = append(versions, version)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+
+	return versions, nil
+}
+
+// RestoreVersion makes the version current. The replaced value goes to the history as on any update,
+// so the restore may be undone too.
+func (r *MaterialsStorage) RestoreVersion(ctx context.Context, userID int, t domain.MaterialType, id int, versionID int) error {
+	table, err := materialTable(t)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	seq, err := nextChangeSeq(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var version domain.Version
+	err = tx.QueryRowContext(ctx, `SELECT data,nonce FROM material_history
+		WHERE id = $1 and user_id = $2 and material_type = $3 and item_id = $4;`, versionID, userID, t, id).
+		Scan(&version.Data, &version.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return &NotFoundError{Err: domain.ErrVersionNotFound}
+		default:
+			return &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	if err := saveVersion(ctx, tx, table, userID, t, id); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET data = $1, nonce = $2, revision = revision + 1, change_seq = $3, updated_at = now() WHERE user_id = $4 and id = $5;", table),
+		version.Data, version.Nonce, seq, userID, id)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// PurgeHistory removes versions of every item except the keep most recent ones.
+func (r *MaterialsStorage) PurgeHistory(ctx context.Context, keep int) (int64, error) {
+	purgeHistoryStmt, err := r.db.PrepareContext(ctx, `DELETE FROM material_history WHERE id IN (
+		SELECT id FROM (
+			SELECT id, row_number() OVER (PARTITION BY material_type, item_id ORDER BY id DESC) AS n FROM material_history
+		) versions WHERE n > $1);`)
+	if err != nil {
+		return 0, &StatementPSQLError{Err: err}
+	}
+	defer purgeHistoryStmt.Close()
+
+	res, err := purgeHistoryStmt.ExecContext(ctx, keep)
+	if err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeeper/internal/domain"
+	"time"
+)
+
+// getIdempotentResult returns the id of the item created with the key, if there is one.
+func getIdempotentResult(ctx context.Context, tx *sql.Tx, userID int, t domain.MaterialType, key domain.IdempotencyKey) (int, bool, error) {
+	var (
+		fingerprint string
+		itemType    domain.MaterialType
+		itemID      int
+	)
+	err := tx.QueryRowContext(ctx, "SELECT fingerprint,material_type,item_id FROM idempotency_keys WHERE user_id = $1 and key = $2;",
+		userID, key.Key).Scan(&fingerprint, &itemType, &itemID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	if fingerprint != key.Fingerprint || itemType != t {
+		return 0, false, domain.ErrIdempotencyKeyReused
+	}
+
+	return itemID, true, nil
+}
+
+func saveIdempotencyKey(ctx context.Context, tx *sql.Tx, userID int, t domain.MaterialType, key domain.IdempotencyKey, itemID int) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO idempotency_keys (user_id, key, fingerprint, material_type, item_id) VALUES ($1, $2, $3, $4, $5);",
+		userID, key.Key, key.Fingerprint, t, itemID)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	return nil
+}
+
+// PurgeIdempotencyKeys removes keys saved before the time, requests with them create new items again.
+func (r *MaterialsStorage) PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	purgeKeysStmt, err := r.db.PrepareContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < $1;")
+	if err != nil {
+		return 0, &StatementPSQLError{Err: err}
+	}
+	defer purgeKeysStmt.Close()
+
+	res, err := purgeKeysStmt.ExecContext(ctx, before)
+	if err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return res.RowsAffected()
+}
+
+// ReserveID takes an id for a new item. Items are sealed with their id, so the client takes it before the item
+// is created, see Create.
+func (r *MaterialsStorage) ReserveID(ctx context.Context, userID int, t domain.MaterialType) (int, error) {
+	table, err := materialTable(t)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = r.db.QueryRowContext(ctx, "INSERT INTO reserved_ids (user_id, material_type, item_id) SELECT $1, $2, nextval(pg_get_serial_sequence($3, 'id')) RETURNING item_id;",
+		userID, t, table).Scan(&id)
+	if err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return id, nil
+}
+
+// takeReservedID removes the reservation, ErrIDNotReserved is returned if the user hasn't reserved the id.
+func takeReservedID(ctx context.Context, tx *sql.Tx, userID int, t domain.MaterialType, id int) error {
+	res, err := tx.ExecContext(ctx, "DELETE FROM reserved_ids WHERE user_id = $1 and material_type = $2 and item_id = $3;",
+		userID, t, id)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	if n == 0 {
+		return domain.ErrIDNotReserved
+	}
+	return nil
+}
+
+// PurgeReservedIDs removes reservations taken before the time and never used.
+func (r *MaterialsStorage) PurgeReservedIDs(ctx context.Context, before time.Time) (int64, error) {
+	purgeIDsStmt, err := r.db.PrepareContext(ctx, "DELETE FROM reserved_ids WHERE created_at < $1;")
+	if err != nil {
+		return 0, &StatementPSQLError{Err: err}
+	}
+	defer purgeIDsStmt.Close()
+
+	res, err := purgeIDsStmt.ExecContext(ctx, before)
+	if err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return res.RowsAffected()
+}
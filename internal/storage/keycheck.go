@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeeper/internal/domain"
+)
+
+// GetKeyCheck returns the key check value of the user, ErrKeyCheckNotFound is returned if it wasn't saved yet.
+func (r *UserStorage) GetKeyCheck(ctx context.Context, userID int) (domain.SealedData, error) {
+	var keyCheck domain.SealedData
+
+	getKeyCheckStmt, err := r.db.PrepareContext(ctx, "SELECT key_check,key_check_nonce FROM users WHERE id = $1 and key_check IS NOT NULL;")
+	if err != nil {
+		return keyCheck, &StatementPSQLError{Err: err}
+	}
+	defer getKeyCheckStmt.Close()
+
+	if err := getKeyCheckStmt.QueryRowContext(ctx, userID).Scan(&keyCheck.Data, &keyCheck.Nonce); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return keyCheck, &NotFoundError{Err: domain.ErrKeyCheckNotFound}
+		default:
+			return keyCheck, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return keyCheck, nil
+}
+
+// SetKeyCheck saves the key check value once, ErrKeyCheckAlreadyExists is returned if it's already saved.
+func (r *UserStorage) SetKeyCheck(ctx context.Context, userID int, keyCheck domain.SealedData) error {
+	err := r.updateUser(ctx, "UPDATE users SET key_check = $1, key_check_nonce = $2 WHERE id = $3 and key_check IS NULL;",
+		keyCheck.Data, keyCheck.Nonce, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return domain.ErrKeyCheckAlreadyExists
+	}
+	return err
+}
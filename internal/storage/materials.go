@@ -4,9 +4,25 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	"gophkeeper/internal/domain"
 )
 
+// every material type is kept in its own table with the same sealed layout
+var materialTables = map[domain.MaterialType]string{
+	domain.MaterialText: "text_data",
+	domain.MaterialCard: "card_data",
+	domain.MaterialCred: "auth_data",
+}
+
+func materialTable(t domain.MaterialType) (string, error) {
+	table, ok := materialTables[t]
+	if !ok {
+		return "", domain.ErrUnknownMaterialType
+	}
+	return table, nil
+}
+
 type MaterialsStorage struct {
 	db *sql.DB
 }
@@ -17,26 +33,28 @@ func NewMaterialsStorage(db *sql.DB) *MaterialsStorage {
 	}
 }
 
-//**********************************************************************************************************************
-// Text
-//**********************************************************************************************************************
-func (r *MaterialsStorage) GetAllTextData(ctx context.Context, userID int) ([]domain.TextData, error) {
-	getTextDataStmt, err := r.db.PrepareContext(ctx, "SELECT id,data,metadata FROM text_data WHERE user_id=$1;")
+func (r *MaterialsStorage) GetAll(ctx context.Context, userID int, t domain.MaterialType) ([]domain.SealedData, error) {
+	table, err := materialTable(t)
+	if err != nil {
+		return nil, err
+	}
+
+	getDataStmt, err := r.db.PrepareContext(ctx, fmt.Sprintf("SELECT id,data,nonce,revision,created_at,updated_at FROM %s WHERE user_id=$1 and deleted_at IS NULL;", table))
 	if err != nil {
 		return nil, &StatementPSQLError{Err: err}
 	}
-	defer getTextDataStmt.Close()
+	defer getDataStmt.Close()
 
-	rows, err := getTextDataStmt.QueryContext(ctx, userID)
+	rows, err := getDataStmt.QueryContext(ctx, userID)
 	if err != nil {
 		return nil, &ExecutionPSQLError{Err: err}
 	}
 	defer rows.Close()
 
-	allTextData := make([]domain.TextData, 0)
+	allData := make([]domain.SealedData, 0)
 	for rows.Next() {
-		var textData domain.TextData
-		err = rows.Scan(&textData.ID, &textData.Text, &textData.Metadata)
+		var data domain.SealedData
+		err = rows.Scan(&data.ID, &data.Data, &data.Nonce, &data.Revision, &data.CreatedAt, &data.UpdatedAt)
 		if err != nil {
 			switch {
 			case errors.Is(err, sql.ErrNoRows):
@@ -46,7 +64,7 @@ func (r *MaterialsStorage) GetAllTextData(ctx context.Context, userID int) ([]do
 			}
 		}
 
-		allTextData = append(allTextData, textData)
+		allData = append(allData, data)
 	}
 
 	err = rows.Err()
@@ -54,195 +72,268 @@ func (r *MaterialsStorage) GetAllTextData(ctx context.Context, userID int) ([]do
 		return nil, &ExecutionPSQLError{Err: err}
 	}
 
-	return allTextData, nil
+	return allData, nil
 }
 
-func (r *MaterialsStorage) UpdateTextDataByID(ctx context.Context, userID int, data domain.TextData) error {
-	updateTextDataStmt, err := r.db.PrepareContext(ctx, "UPDATE text_data SET data = $1, metadata = $2 WHERE user_id = $3 and id = $4;")
-	if err != nil {
-		return &StatementPSQLError{Err: err}
-	}
-	defer updateTextDataStmt.Close()
+// sortColumns are the columns items may be paged by, see domain.ListSort
+var sortColumns = map[domain.ListSort]string{
+	domain.SortByID:        "id",
+	domain.SortByCreatedAt: "created_at",
+	domain.SortByUpdatedAt: "updated_at",
+}
 
-	res, err := updateTextDataStmt.ExecContext(ctx, data.Text, data.Metadata, userID, data.ID)
+// GetPage returns up to query.Limit items following query.After in the sort order. Equal timestamps
+// are ordered by id, so every item gets to exactly one page.
+func (r *MaterialsStorage) GetPage(ctx context.Context, userID int, t domain.MaterialType, query domain.PageQuery) ([]domain.SealedData, error) {
+	table, err := materialTable(t)
 	if err != nil {
-		return &ExecutionPSQLError{Err: err}
+		return nil, err
 	}
 
-	n, err := res.RowsAffected()
-	if err != nil {
-		return err
+	column, ok := sortColumns[query.Sort]
+	if !ok {
+		return nil, domain.ErrInvalidInput
 	}
 
-	if n == 0 {
-		return &NotFoundError{Err: domain.ErrDataNotFound}
+	order, cmp := "ASC", ">"
+	if query.Desc {
+		order, cmp = "DESC", "<"
 	}
 
-	return nil
-}
-
-func (r *MaterialsStorage) CreateNewTextData(ctx context.Context, userID int, data domain.TextData) error {
-	crUserStmt, err := r.db.PrepareContext(ctx, "INSERT INTO text_data (user_id, data, metadata) VALUES ($1, $2, $3);")
-	if err != nil {
-		return &StatementPSQLError{Err: err}
+	where, orderBy, args := "user_id = $1 and deleted_at IS NULL", "id "+order, []interface{}{userID}
+	if query.Sort != domain.SortByID {
+		orderBy = fmt.Sprintf("%s %s, id %s", column, order, order)
 	}
-	defer crUserStmt.Close()
-
-	if _, err := crUserStmt.ExecContext(ctx, userID, data.Text, data.Metadata); err != nil {
-		return &ExecutionPSQLError{Err: err}
+	switch {
+	case query.After == nil:
+	case query.Sort == domain.SortByID:
+		where += fmt.Sprintf(" and id %s $2", cmp)
+		args = append(args, query.After.ID)
+	default:
+		where += fmt.Sprintf(" and (%s, id) %s ($2, $3)", column, cmp)
+		args = append(args, query.After.At, query.After.ID)
 	}
+	args = append(args, query.Limit)
 
-	return nil
-}
-
-//**********************************************************************************************************************
-// Credit card
-//**********************************************************************************************************************
-func (r *MaterialsStorage) GetAllCardData(ctx context.Context, userID int) ([]domain.CardData, error) {
-	getTextDataStmt, err := r.db.PrepareContext(ctx, "SELECT id,card_number,exp_date,cvv,name,surname,metadata FROM card_data WHERE user_id=$1;")
+	getPageStmt, err := r.db.PrepareContext(ctx, fmt.Sprintf("SELECT id,data,nonce,revision,created_at,updated_at FROM %s WHERE %s ORDER BY %s LIMIT $%d;",
+		table, where, orderBy, len(args)))
 	if err != nil {
 		return nil, &StatementPSQLError{Err: err}
 	}
-	defer getTextDataStmt.Close()
+	defer getPageStmt.Close()
 
-	rows, err := getTextDataStmt.QueryContext(ctx, userID)
+	rows, err := getPageStmt.QueryContext(ctx, args...)
 	if err != nil {
 		return nil, &ExecutionPSQLError{Err: err}
 	}
 	defer rows.Close()
 
-	allTextData := make([]domain.CardData, 0)
+	items := make([]domain.SealedData, 0, query.Limit)
 	for rows.Next() {
-		var textData domain.CardData
-		err = rows.Scan(&textData.ID, &textData.CardNumber, &textData.ExpDate, &textData.CVV, &textData.Name, &textData.Surname, &textData.Metadata)
-		if err != nil {
-			switch {
-			case errors.Is(err, sql.ErrNoRows):
-				return nil, &NotFoundError{Err: domain.ErrDataNotFound}
-			default:
-				return nil, &ExecutionPSQLError{Err: err}
-			}
+		var item domain.SealedData
+		if err := rows.Scan(&item.ID, &item.Data, &item.Nonce, &item.Revision, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, &ExecutionPSQLError{Err: err}
 		}
+		items = append(items, item)
+	}
 
-		allTextData = append(allTextData, textData)
+	if err := rows.Err(); err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
 	}
 
-	err = rows.Err()
+	return items, nil
+}
+
+func (r *MaterialsStorage) GetByID(ctx context.Context, userID int, t domain.MaterialType, id int) (domain.SealedData, error) {
+	item := domain.SealedData{ID: id}
+	table, err := materialTable(t)
 	if err != nil {
-		return nil, &ExecutionPSQLError{Err: err}
+		return item, err
 	}
 
-	return allTextData, nil
+	getItemStmt, err := r.db.PrepareContext(ctx, fmt.Sprintf("SELECT data,nonce,revision,created_at,updated_at FROM %s WHERE user_id = $1 and id = $2 and deleted_at IS NULL;", table))
+	if err != nil {
+		return item, &StatementPSQLError{Err: err}
+	}
+	defer getItemStmt.Close()
+
+	err = getItemStmt.QueryRowContext(ctx, userID, id).Scan(&item.Data, &item.Nonce, &item.Revision, &item.CreatedAt, &item.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return item, &NotFoundError{Err: domain.ErrDataNotFound}
+		default:
+			return item, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return item, nil
 }
 
-func (r *MaterialsStorage) UpdateCardDataByID(ctx context.Context, userID int, data domain.CardData) error {
-	updateTextDataStmt, err := r.db.PrepareContext(ctx, "UPDATE card_data SET card_number = $1, exp_date = $2, cvv = $3, name = $4, surname = $5, metadata = $6 WHERE user_id = $7 and id = $8;")
+// UpdateByID replaces the item and keeps the previous value in the history. The update is applied only
+// to data.Revision (any revision, if it's 0), otherwise RevisionConflictError with the current item is returned.
+// The new revision is returned on success.
+func (r *MaterialsStorage) UpdateByID(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData) (int, error) {
+	table, err := materialTable(t)
 	if err != nil {
-		return &StatementPSQLError{Err: err}
+		return 0, err
 	}
-	defer updateTextDataStmt.Close()
 
-	res, err := updateTextDataStmt.ExecContext(ctx, data.CardNumber, data.ExpDate, data.CVV, data.Name, data.Surname, data.Metadata, userID, data.ID)
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return &ExecutionPSQLError{Err: err}
+		return 0, &ExecutionPSQLError{Err: err}
 	}
+	defer tx.Rollback()
 
-	n, err := res.RowsAffected()
+	seq, err := nextChangeSeq(ctx, tx, userID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	if n == 0 {
-		return &NotFoundError{Err: domain.ErrDataNotFound}
+	current := domain.SealedData{ID: data.ID}
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("SELECT data,nonce,revision,created_at,updated_at FROM %s WHERE user_id = $1 and id = $2 and deleted_at IS NULL FOR UPDATE;", table),
+		userID, data.ID).Scan(&current.Data, &current.Nonce, &current.Revision, &current.CreatedAt, &current.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, &NotFoundError{Err: domain.ErrDataNotFound}
+		default:
+			return 0, &ExecutionPSQLError{Err: err}
+		}
 	}
 
-	return nil
-}
+	if data.Revision != 0 && data.Revision != current.Revision {
+		return 0, &domain.RevisionConflictError{Current: current}
+	}
+
+	if err := saveVersion(ctx, tx, table, userID, t, data.ID); err != nil {
+		return 0, err
+	}
 
-func (r *MaterialsStorage) CreateNewCardData(ctx context.Context, userID int, data domain.CardData) error {
-	crUserStmt, err := r.db.PrepareContext(ctx, "INSERT INTO card_data (user_id, card_number, exp_date, cvv, name, surname, metadata) VALUES ($1, $2, $3, $4, $5, $6, $7);")
+	var revision int
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("UPDATE %s SET data = $1, nonce = $2, revision = revision + 1, change_seq = $3, updated_at = now() WHERE user_id = $4 and id = $5 RETURNING revision;", table),
+		data.Data, data.Nonce, seq, userID, data.ID).Scan(&revision)
 	if err != nil {
-		return &StatementPSQLError{Err: err}
+		return 0, &ExecutionPSQLError{Err: err}
 	}
-	defer crUserStmt.Close()
 
-	if _, err := crUserStmt.ExecContext(ctx, userID, data.CardNumber, data.ExpDate, data.CVV, data.Name, data.Surname, data.Metadata); err != nil {
-		return &ExecutionPSQLError{Err: err}
+	if err := tx.Commit(); err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
 	}
 
-	return nil
+	return revision, nil
 }
 
-func (r *MaterialsStorage) GetAllCredData(ctx context.Context, userID int) ([]domain.CredData, error) {
+// Create stores the item and returns it as stored. If the key was already used, the item created
+// with it is returned and true tells the item is not new.
+func (r *MaterialsStorage) Create(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData, key domain.IdempotencyKey) (domain.SealedData, bool, error) {
+	table, err := materialTable(t)
+	if err != nil {
+		return data, false, err
+	}
 
-	getTextDataStmt, err := r.db.PrepareContext(ctx, "SELECT id,login, password, metadata FROM auth_data WHERE user_id=$1;")
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, &StatementPSQLError{Err: err}
+		return data, false, &ExecutionPSQLError{Err: err}
 	}
-	defer getTextDataStmt.Close()
+	defer tx.Rollback()
 
-	rows, err := getTextDataStmt.QueryContext(ctx, userID)
+	// the users row is locked from here, so retries with the same key wait for the first request
+	seq, err := nextChangeSeq(ctx, tx, userID)
 	if err != nil {
-		return nil, &ExecutionPSQLError{Err: err}
+		return data, false, err
 	}
-	defer rows.Close()
 
-	allTextData := make([]domain.CredData, 0)
-	for rows.Next() {
-		var textData domain.CredData
-		err = rows.Scan(&textData.ID, &textData.Login, &textData.Password, &textData.Metadata)
+	if key.Key != "" {
+		id, found, err := getIdempotentResult(ctx, tx, userID, t, key)
 		if err != nil {
-			switch {
-			case errors.Is(err, sql.ErrNoRows):
-				return nil, &NotFoundError{Err: domain.ErrDataNotFound}
-			default:
-				return nil, &ExecutionPSQLError{Err: err}
+			return data, false, err
+		}
+		if found {
+			created, err := getItem(ctx, tx, table, userID, id)
+			if errors.Is(err, domain.ErrDataNotFound) {
+				// the item was purged from the trash before the key expired
+				return created, true, domain.ErrCreatedItemPurged
 			}
+			return created, true, err
 		}
+	}
 
-		allTextData = append(allTextData, textData)
+	// the item is sealed with its id, so it's stored under the id reserved by the client
+	if err := takeReservedID(ctx, tx, userID, t, data.ID); err != nil {
+		return data, false, err
 	}
 
-	err = rows.Err()
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("INSERT INTO %s (id, user_id, data, nonce, change_seq) VALUES ($1, $2, $3, $4, $5) RETURNING revision,created_at,updated_at;", table),
+		data.ID, userID, data.Data, data.Nonce, seq).Scan(&data.Revision, &data.CreatedAt, &data.UpdatedAt)
 	if err != nil {
-		return nil, &ExecutionPSQLError{Err: err}
+		return data, false, &ExecutionPSQLError{Err: err}
+	}
+
+	if key.Key != "" {
+		if err := saveIdempotencyKey(ctx, tx, userID, t, key, data.ID); err != nil {
+			return data, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return data, false, &ExecutionPSQLError{Err: err}
 	}
 
-	return allTextData, nil
+	return data, false, nil
 }
 
-func (r *MaterialsStorage) UpdateCredDataByID(ctx context.Context, userID int, data domain.CredData) error {
-	updateTextDataStmt, err := r.db.PrepareContext(ctx, "UPDATE auth_data SET login = $1, password = $2, metadata = $3 WHERE user_id = $4 and id = $5;")
+// getItem returns the item even if it's in the trash.
+func getItem(ctx context.Context, tx *sql.Tx, table string, userID int, id int) (domain.SealedData, error) {
+	item := domain.SealedData{ID: id}
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT data,nonce,revision,created_at,updated_at FROM %s WHERE user_id = $1 and id = $2;", table),
+		userID, id).Scan(&item.Data, &item.Nonce, &item.Revision, &item.CreatedAt, &item.UpdatedAt)
 	if err != nil {
-		return &StatementPSQLError{Err: err}
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return item, &NotFoundError{Err: domain.ErrDataNotFound}
+		default:
+			return item, &ExecutionPSQLError{Err: err}
+		}
 	}
-	defer updateTextDataStmt.Close()
 
-	res, err := updateTextDataStmt.ExecContext(ctx, data.Login, data.Password, data.Metadata, userID, data.ID)
+	return item, nil
+}
+
+// DeleteByID moves the item to the trash, it's purged later by PurgeTrash.
+func (r *MaterialsStorage) DeleteByID(ctx context.Context, userID int, t domain.MaterialType, id int) error {
+	table, err := materialTable(t)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return &ExecutionPSQLError{Err: err}
 	}
+	defer tx.Rollback()
 
-	n, err := res.RowsAffected()
+	seq, err := nextChangeSeq(ctx, tx, userID)
 	if err != nil {
 		return err
 	}
 
-	if n == 0 {
-		return &NotFoundError{Err: domain.ErrDataNotFound}
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET deleted_at = now(), change_seq = $1 WHERE user_id = $2 and id = $3 and deleted_at IS NULL;", table),
+		seq, userID, id)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
 	}
 
-	return nil
-}
-
-func (r *MaterialsStorage) CreateNewCredData(ctx context.Context, userID int, data domain.CredData) error {
-	crUserStmt, err := r.db.PrepareContext(ctx, "INSERT INTO auth_data (user_id, login, password, metadata) VALUES ($1, $2, $3, $4);")
+	n, err := res.RowsAffected()
 	if err != nil {
-		return &StatementPSQLError{Err: err}
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrDataNotFound}
 	}
-	defer crUserStmt.Close()
 
-	if _, err := crUserStmt.ExecContext(ctx, userID, data.Login, data.Password, data.Metadata); err != nil {
+	if err := tx.Commit(); err != nil {
 		return &ExecutionPSQLError{Err: err}
 	}
 
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"context"
+	"gophkeeper/internal/domain"
+)
+
+// ReplaceRecoveryCodes drops the user's recovery codes and stores digests of the new ones.
+func (r *UserStorage) ReplaceRecoveryCodes(ctx context.Context, userID int, codes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1;", userID); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	crCodeStmt, err := tx.PrepareContext(ctx, "INSERT INTO recovery_codes (code,user_id) VALUES ($1, $2);")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer crCodeStmt.Close()
+
+	for _, code := range codes {
+		if _, err := crCodeStmt.ExecContext(ctx, hashToken(code), userID); err != nil {
+			return &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode deletes the code, so it can't be used twice.
+func (r *UserStorage) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	useCodeStmt, err := r.db.PrepareContext(ctx, "DELETE FROM recovery_codes WHERE code = $1 and user_id = $2;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer useCodeStmt.Close()
+
+	res, err := useCodeStmt.ExecContext(ctx, hashToken(code), userID)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrRecoveryCodeNotFound}
+	}
+
+	return nil
+}
+
+func (r *UserStorage) CountRecoveryCodes(ctx context.Context, userID int) (int, error) {
+	countStmt, err := r.db.PrepareContext(ctx, "SELECT count(*) FROM recovery_codes WHERE user_id = $1;")
+	if err != nil {
+		return 0, &StatementPSQLError{Err: err}
+	}
+	defer countStmt.Close()
+
+	var n int
+	if err := countStmt.QueryRowContext(ctx, userID).Scan(&n); err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return n, nil
+}
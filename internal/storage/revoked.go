@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+//**********************************************************************************************************************
+// PostgreSQL
+//**********************************************************************************************************************
+
+// RevokedTokensStorage keeps revoked access tokens in PostgreSQL, so the list is shared by all server instances.
+type RevokedTokensStorage struct {
+	db *sql.DB
+}
+
+func NewRevokedTokensStorage(db *sql.DB) *RevokedTokensStorage {
+	return &RevokedTokensStorage{
+		db: db,
+	}
+}
+
+func (r *RevokedTokensStorage) Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error {
+	// the tokens are rejected by the expiration time anyway, so there is no need to keep them longer
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM revoked_tokens WHERE expired_at <= now();"); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	revokeStmt, err := r.db.PrepareContext(ctx, "INSERT INTO revoked_tokens (token_id, expired_at) VALUES ($1, $2) ON CONFLICT (token_id) DO NOTHING;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer revokeStmt.Close()
+
+	if _, err := revokeStmt.ExecContext(ctx, tokenID, expiresAt); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+func (r *RevokedTokensStorage) IsRevoked(ctx context.Context, tokenID string) (bool, error) {
+	isRevokedStmt, err := r.db.PrepareContext(ctx, "SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE token_id = $1 and expired_at > now());")
+	if err != nil {
+		return false, &StatementPSQLError{Err: err}
+	}
+	defer isRevokedStmt.Close()
+
+	var revoked bool
+	if err := isRevokedStmt.QueryRowContext(ctx, tokenID).Scan(&revoked); err != nil {
+		return false, &ExecutionPSQLError{Err: err}
+	}
+
+	return revoked, nil
+}
+
+//**********************************************************************************************************************
+// In memory
+//**********************************************************************************************************************
+
+// RevokedTokensMemStorage keeps revoked access tokens in memory. It suits a single server instance only.
+type RevokedTokensMemStorage struct {
+	mu     sync.RWMutex
+	tokens map[string]time.Time
+}
+
+func NewRevokedTokensMemStorage() *RevokedTokensMemStorage {
+	return &RevokedTokensMemStorage{
+		tokens: make(map[string]time.Time),
+	}
+}
+
+func (r *RevokedTokensMemStorage) Revoke(_ context.Context, tokenID string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range r.tokens {
+		if !exp.After(now) {
+			delete(r.tokens, id)
+		}
+	}
+
+	r.tokens[tokenID] = expiresAt
+	return nil
+}
+
+func (r *RevokedTokensMemStorage) IsRevoked(_ context.Context, tokenID string) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	exp, ok := r.tokens[tokenID]
+	return ok && exp.After(time.Now()), nil
+}
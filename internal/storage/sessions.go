@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/lib/pq"
+	"gophkeeper/internal/domain"
+	"time"
+)
+
+// hashToken returns the digest of refresh token. Only digests are kept in the database,
+// so a leaked database doesn't hand out live sessions.
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// isConstraintViolation tells if err is a pq error of violated constraint, other errors (e.g. of canceled context) are not.
+func isConstraintViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code))
+}
+
+func (r *UserStorage) GetSession(ctx context.Context, refreshToken string) (domain.Session, error) {
+	session := domain.Session{RefreshToken: refreshToken}
+
+	getSessionStmt, err := r.db.PrepareContext(ctx, "SELECT user_id,family_id,expired_at,rotated_at FROM sessions WHERE refresh_token=$1 and expired_at > now();")
+	if err != nil {
+		return session, &StatementPSQLError{Err: err}
+	}
+	defer getSessionStmt.Close()
+
+	var rotatedAt sql.NullTime
+	if err := getSessionStmt.QueryRowContext(ctx, hashToken(refreshToken)).Scan(&session.UserID, &session.FamilyID, &session.ExpiresAt, &rotatedAt); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return session, &NotFoundError{Err: domain.ErrUserNotFoundOrSessionWasExpired}
+		default:
+			return session, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	if rotatedAt.Valid {
+		session.RotatedAt = &rotatedAt.Time
+	}
+
+	return session, nil
+}
+
+func (r *UserStorage)SetSession(ctx context.Context, userID int, session domain.Session) error  {
+	crUserStmt, err := r.db.PrepareContext(ctx, `INSERT INTO sessions
+		(refresh_token,family_id,user_id,expired_at,device_name,user_agent,ip,created_at,last_used_at,access_token_id,access_expired_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now(), now(), $8, $9);`)
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer crUserStmt.Close()
+
+	if _, err := crUserStmt.ExecContext(ctx, hashToken(session.RefreshToken), session.FamilyID, userID, session.ExpiresAt,
+		session.Client.DeviceName, session.Client.UserAgent, session.Client.IP,
+		session.AccessTokenID, session.AccessTokenExpiresAt); err != nil {
+		if isConstraintViolation(err) {
+			return &AlreadyExistsError{Err: domain.ErrSessionAlreadyExists}
+		}
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// RotateSession marks the old refresh token as rotated and issues the new one in the same family.
+// The device name and creation time are inherited from the old token.
+// If the old token was rotated already (e.g. by the concurrent request), ErrRefreshTokenReused is returned.
+func (r *UserStorage) RotateSession(ctx context.Context, userID int, session domain.Session, oldRefreshToken string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE sessions SET rotated_at = now() WHERE refresh_token = $1 and rotated_at IS NULL;", hashToken(oldRefreshToken))
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return domain.ErrRefreshTokenReused
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO sessions
+		(refresh_token,family_id,user_id,expired_at,device_name,user_agent,ip,created_at,last_used_at,access_token_id,access_expired_at)
+		SELECT $1, family_id, $2, $3, device_name, $4, $5, created_at, now(), $6, $7 FROM sessions WHERE refresh_token = $8;`,
+		hashToken(session.RefreshToken), userID, session.ExpiresAt, session.Client.UserAgent, session.Client.IP,
+		session.AccessTokenID, session.AccessTokenExpiresAt, hashToken(oldRefreshToken)); err != nil {
+		if isConstraintViolation(err) {
+			return &AlreadyExistsError{Err: domain.ErrSessionAlreadyExists}
+		}
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// GetUserSessions returns active sessions of the user, one per token family.
+func (r *UserStorage) GetUserSessions(ctx context.Context, userID int) ([]domain.SessionInfo, error) {
+	getSessionsStmt, err := r.db.PrepareContext(ctx, `SELECT family_id,device_name,user_agent,ip,created_at,last_used_at FROM sessions
+		WHERE user_id = $1 and rotated_at IS NULL and expired_at > now() ORDER BY last_used_at DESC;`)
+	if err != nil {
+		return nil, &StatementPSQLError{Err: err}
+	}
+	defer getSessionsStmt.Close()
+
+	rows, err := getSessionsStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	sessions := make([]domain.SessionInfo, 0)
+	for rows.Next() {
+		var session domain.SessionInfo
+		err = rows.Scan(&session.ID, &session.DeviceName, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt)
+		if err != nil {
+			return nil, &ExecutionPSQLError{Err: err}
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+
+	return sessions, nil
+}
+
+// RevokeSessionFamily deletes all tokens of the user's session and returns them,
+// so the access tokens issued with them can be revoked as well.
+func (r *UserStorage) RevokeSessionFamily(ctx context.Context, userID int, familyID string) ([]domain.Session, error) {
+	return r.deleteSessions(ctx, "DELETE FROM sessions WHERE user_id = $1 and family_id = $2 RETURNING family_id,access_token_id,access_expired_at;", userID, familyID)
+}
+
+// RevokeUserSessions deletes all tokens of the user and returns them.
+func (r *UserStorage) RevokeUserSessions(ctx context.Context, userID int) ([]domain.Session, error) {
+	return r.deleteSessions(ctx, "DELETE FROM sessions WHERE user_id = $1 RETURNING family_id,access_token_id,access_expired_at;", userID)
+}
+
+func (r *UserStorage) deleteSessions(ctx context.Context, query string, args ...interface{}) ([]domain.Session, error) {
+	revokeStmt, err := r.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, &StatementPSQLError{Err: err}
+	}
+	defer revokeStmt.Close()
+
+	rows, err := revokeStmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	sessions := make([]domain.Session, 0)
+	for rows.Next() {
+		var session domain.Session
+		if err = rows.Scan(&session.FamilyID, &session.AccessTokenID, &session.AccessTokenExpiresAt); err != nil {
+			return nil, &ExecutionPSQLError{Err: err}
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+
+	return sessions, nil
+}
+
+func (r *UserStorage) CreateSecurityEvent(ctx context.Context, userID int, event domain.SecurityEvent) error {
+	crEventStmt, err := r.db.PrepareContext(ctx, "INSERT INTO security_events (user_id,kind,details,created_at) VALUES ($1, $2, $3, $4);")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer crEventStmt.Close()
+
+	if _, err := crEventStmt.ExecContext(ctx, userID, event.Kind, event.Details, event.CreatedAt); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// PurgeSessions removes tokens expired before the time. Rotated tokens are kept until then,
+// so the reuse of a stolen one still revokes its family.
+func (r *UserStorage) PurgeSessions(ctx context.Context, before time.Time) (int64, error) {
+	purgeSessionsStmt, err := r.db.PrepareContext(ctx, "DELETE FROM sessions WHERE expired_at < $1;")
+	if err != nil {
+		return 0, &StatementPSQLError{Err: err}
+	}
+	defer purgeSessionsStmt.Close()
+
+	res, err := purgeSessionsStmt.ExecContext(ctx, before)
+	if err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return res.RowsAffected()
+}
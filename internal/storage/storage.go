@@ -4,43 +4,111 @@ import (
 	"context"
 	"database/sql"
 	"gophkeeper/internal/domain"
+	"io"
+	"time"
 )
 
 type Storages struct {
-	Users     Users
-	Materials Materials
+	Users         Users
+	Materials     Materials
+	RevokedTokens RevokedTokens
+	LoginAttempts LoginAttempts
 }
 
 func NewStorages(db *sql.DB) *Storages {
 	return &Storages{
-		Users:     NewUserStorage(db),
-		Materials: NewMaterialsStorage(db),
+		Users:         NewUserStorage(db),
+		Materials:     NewMaterialsStorage(db),
+		RevokedTokens: NewRevokedTokensStorage(db),
+		LoginAttempts: NewLoginAttemptsStorage(db),
 	}
 }
 
 type Users interface {
 	Create(ctx context.Context, user domain.User) error
-	GetByCredentials(ctx context.Context, login, password string) (domain.User, error)
-	GetByRefreshToken(ctx context.Context, refreshToken string) (domain.User, error)
+	GetByLogin(ctx context.Context, login string) (domain.User, error)
+	GetByID(ctx context.Context, userID int) (domain.User, error)
+	UpdatePassword(ctx context.Context, userID int, passwordHash string) error
 
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTOTP(ctx context.Context, userID int) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+
+	CreateChallenge(ctx context.Context, challenge domain.Challenge) error
+	UseChallenge(ctx context.Context, token string) (domain.Challenge, error)
+	DeleteChallenge(ctx context.Context, token string) error
+
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codes []string) error
+	UseRecoveryCode(ctx context.Context, userID int, code string) error
+	CountRecoveryCodes(ctx context.Context, userID int) (int, error)
+
+	GetKeyCheck(ctx context.Context, userID int) (domain.SealedData, error)
+	SetKeyCheck(ctx context.Context, userID int, keyCheck domain.SealedData) error
+
+	GetSession(ctx context.Context, refreshToken string) (domain.Session, error)
 	SetSession(ctx context.Context, userID int, session domain.Session) error
-	UpdateSession(ctx context.Context, userID int, session domain.Session, oldRefreshToken string) error
+	RotateSession(ctx context.Context, userID int, session domain.Session, oldRefreshToken string) error
+	GetUserSessions(ctx context.Context, userID int) ([]domain.SessionInfo, error)
+	RevokeSessionFamily(ctx context.Context, userID int, familyID string) ([]domain.Session, error)
+	RevokeUserSessions(ctx context.Context, userID int) ([]domain.Session, error)
+	PurgeSessions(ctx context.Context, before time.Time) (int64, error)
+
+	CreateSecurityEvent(ctx context.Context, userID int, event domain.SecurityEvent) error
 
 	Close() error
 }
 
 type Materials interface {
-	GetAllTextData(ctx context.Context, userID int) ([]domain.TextData, error)
-	UpdateTextDataByID(ctx context.Context, userID int, data domain.TextData) error
-	CreateNewTextData(ctx context.Context, userID int, data domain.TextData) error
+	GetAll(ctx context.Context, userID int, t domain.MaterialType) ([]domain.SealedData, error)
+	GetPage(ctx context.Context, userID int, t domain.MaterialType, query domain.PageQuery) ([]domain.SealedData, error)
+	GetByID(ctx context.Context, userID int, t domain.MaterialType, id int) (domain.SealedData, error)
+	UpdateByID(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData) (int, error)
+	ReserveID(ctx context.Context, userID int, t domain.MaterialType) (int, error)
+	Create(ctx context.Context, userID int, t domain.MaterialType, data domain.SealedData, key domain.IdempotencyKey) (domain.SealedData, bool, error)
+	DeleteByID(ctx context.Context, userID int, t domain.MaterialType, id int) error
+
+	GetHistory(ctx context.Context, userID int, t domain.MaterialType, id int) ([]domain.Version, error)
+	RestoreVersion(ctx context.Context, userID int, t domain.MaterialType, id int, versionID int) error
 
-	GetAllCardData(ctx context.Context, userID int) ([]domain.CardData, error)
-	UpdateCardDataByID(ctx context.Context, userID int, data domain.CardData) error
-	CreateNewCardData(ctx context.Context, userID int, data domain.CardData) error
+	GetChanges(ctx context.Context, userID int, since int64) (domain.SyncChanges, error)
 
-	GetAllCredData(ctx context.Context, userID int) ([]domain.CredData, error)
-	UpdateCredDataByID(ctx context.Context, userID int, data domain.CredData) error
-	CreateNewCredData(ctx context.Context, userID int, data domain.CredData) error
+	CreateBlob(ctx context.Context, userID int, blob domain.BlobData, content io.Reader) (domain.BlobData, error)
+	GetAllBlobs(ctx context.Context, userID int) ([]domain.BlobData, error)
+	GetBlob(ctx context.Context, userID int, blobID int) (domain.BlobData, error)
+	ReadBlob(ctx context.Context, userID int, blobID int, w io.Writer) error
+	UpdateBlobMetadata(ctx context.Context, userID int, blob domain.BlobData) error
+	DeleteBlob(ctx context.Context, userID int, blobID int) error
+
+	CreateUpload(ctx context.Context, userID int, upload domain.BlobUpload, maxOpen int) error
+	GetUpload(ctx context.Context, userID int, uploadID string) (domain.BlobUpload, error)
+	PutUploadChunk(ctx context.Context, userID int, uploadID string, seq int, data []byte) error
+	FinalizeUpload(ctx context.Context, userID int, uploadID string) (domain.BlobData, error)
+	DeleteUpload(ctx context.Context, userID int, uploadID string) error
+
+	GetTrash(ctx context.Context, userID int) ([]domain.TrashItem, error)
+	RestoreFromTrash(ctx context.Context, userID int, t domain.MaterialType, id int) error
+	PurgeTrash(ctx context.Context, before time.Time) (int64, error)
+	PurgeIdempotencyKeys(ctx context.Context, before time.Time) (int64, error)
+	PurgeReservedIDs(ctx context.Context, before time.Time) (int64, error)
+	PurgeHistory(ctx context.Context, keep int) (int64, error)
 
 	Close() error
 }
+
+// RevokedTokens is a list of access tokens revoked before their expiration.
+type RevokedTokens interface {
+	Revoke(ctx context.Context, tokenID string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, tokenID string) (bool, error)
+}
+
+// LoginAttempts counts failed sign in attempts by keys like a login or a client ip.
+type LoginAttempts interface {
+	Get(ctx context.Context, key string) (domain.LoginAttempts, error)
+	// Reserve counts the attempt as failed before it's made, unless the key has to wait delays[failures] after
+	// the last failure (the last delay is used for more failures). false is returned then and nothing is counted.
+	// Failures older than window are forgotten.
+	Reserve(ctx context.Context, key string, window time.Duration, delays []time.Duration) (domain.LoginAttempts, bool, error)
+	// Release uncounts the reserved attempt, which has succeeded.
+	Release(ctx context.Context, key string) error
+	Reset(ctx context.Context, keys ...string) error
+}
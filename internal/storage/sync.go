@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gophkeeper/internal/domain"
+)
+
+// nextChangeSeq increments the change counter of the user and returns it. The users row stays locked
+// until tx ends, so changes of the user are committed in the order of their numbers and a reader
+// never sees a number before the lower ones.
+func nextChangeSeq(ctx context.Context, tx *sql.Tx, userID int) (int64, error) {
+	var seq int64
+	err := tx.QueryRowContext(ctx, "UPDATE users SET change_seq = change_seq + 1 WHERE id = $1 RETURNING change_seq;", userID).Scan(&seq)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, &NotFoundError{Err: domain.ErrUserNotFound}
+		default:
+			return 0, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return seq, nil
+}
+
+// GetChanges returns items changed after the cursor. All the items are returned with Reset set,
+// if the cursor is 0, deletions after it are already purged or it's unknown to the server.
+func (r *MaterialsStorage) GetChanges(ctx context.Context, userID int, since int64) (domain.SyncChanges, error) {
+	changes := domain.SyncChanges{
+		Items:   make([]domain.SyncItem, 0),
+		Deleted: make([]domain.Tombstone, 0),
+	}
+
+	// all the tables are read from the same snapshot
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return changes, &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	var last, purged int64
+	err = tx.QueryRowContext(ctx, "SELECT change_seq, purged_seq FROM users WHERE id = $1;", userID).Scan(&last, &purged)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return changes, &NotFoundError{Err: domain.ErrUserNotFound}
+		default:
+			return changes, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	// the counter is read from the same snapshot, so every change up to it is seen
+	changes.Cursor = last
+	if since <= 0 || since < purged || since > last {
+		// items created before the counter appeared have 0
+		changes.Reset, since = true, -1
+	}
+
+	for t, table := range materialTables {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`SELECT id,data,nonce,revision,created_at,updated_at,deleted_at FROM %s
+			WHERE user_id = $1 and change_seq > $2;`, table), userID, since)
+		if err != nil {
+			return changes, &ExecutionPSQLError{Err: err}
+		}
+
+		for rows.Next() {
+			var (
+				item      = domain.SyncItem{Type: t}
+				deletedAt sql.NullTime
+			)
+			if err := rows.Scan(&item.ID, &item.Data, &item.Nonce, &item.Revision, &item.CreatedAt, &item.UpdatedAt, &deletedAt); err != nil {
+				rows.Close()
+				return changes, &ExecutionPSQLError{Err: err}
+			}
+
+			switch {
+			case !deletedAt.Valid:
+				changes.Items = append(changes.Items, item)
+			case !changes.Reset:
+				changes.Deleted = append(changes.Deleted, domain.Tombstone{Type: t, ID: item.ID, DeletedAt: deletedAt.Time})
+			}
+		}
+
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return changes, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	return changes, nil
+}
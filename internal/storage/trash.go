@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"gophkeeper/internal/domain"
+	"strings"
+	"time"
+)
+
+// trashTable returns the table of any material type including files.
+func trashTable(t domain.MaterialType) (string, error) {
+	if t == domain.MaterialBlob {
+		return "blob_data", nil
+	}
+	return materialTable(t)
+}
+
+// GetTrash returns the deleted items of all types, recently deleted go first.
+func (r *MaterialsStorage) GetTrash(ctx context.Context, userID int) ([]domain.TrashItem, error) {
+	selects := make([]string, 0, len(materialTables)+1)
+	for t, table := range materialTables {
+		selects = append(selects, fmt.Sprintf("SELECT '%s',id,data,nonce,deleted_at FROM %s WHERE user_id=$1 and deleted_at IS NOT NULL", t, table))
+	}
+	selects = append(selects, fmt.Sprintf("SELECT '%s',id,metadata,nonce,deleted_at FROM blob_data WHERE user_id=$1 and deleted_at IS NOT NULL", domain.MaterialBlob))
+
+	getTrashStmt, err := r.db.PrepareContext(ctx, strings.Join(selects, " UNION ALL ")+" ORDER BY deleted_at DESC;")
+	if err != nil {
+		return nil, &StatementPSQLError{Err: err}
+	}
+	defer getTrashStmt.Close()
+
+	rows, err := getTrashStmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	items := make([]domain.TrashItem, 0)
+	for rows.Next() {
+		var item domain.TrashItem
+		if err := rows.Scan(&item.Type, &item.ItemID, &item.Data, &item.Nonce, &item.DeletedAt); err != nil {
+			return nil, &ExecutionPSQLError{Err: err}
+		}
+
+		item.ID = domain.TrashItemID(item.Type, item.ItemID)
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, &ExecutionPSQLError{Err: err}
+	}
+
+	return items, nil
+}
+
+// RestoreFromTrash brings the deleted item back.
+func (r *MaterialsStorage) RestoreFromTrash(ctx context.Context, userID int, t domain.MaterialType, id int) error {
+	table, err := trashTable(t)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	// files aren't synced, so they have no change numbers
+	setSeq, args := "", []interface{}{userID, id}
+	if t != domain.MaterialBlob {
+		seq, err := nextChangeSeq(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+		setSeq, args = ", change_seq = $3", append(args, seq)
+	}
+
+	res, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET deleted_at = NULL%s WHERE user_id = $1 and id = $2 and deleted_at IS NOT NULL;", table, setSeq),
+		args...)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrDataNotFound}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// PurgeTrash removes items deleted before the time for good and returns their number.
+func (r *MaterialsStorage) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	var purged int64
+	purge := func(table string) error {
+		res, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE deleted_at < $1;", table), before)
+		if err != nil {
+			return &ExecutionPSQLError{Err: err}
+		}
+
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		purged += n
+		return nil
+	}
+
+	for t, table := range materialTables {
+		// history rows refer to items of different tables, so they can't be removed by a cascade
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM material_history WHERE material_type = $1
+			and item_id IN (SELECT id FROM %s WHERE deleted_at < $2);`, table), t, before)
+		if err != nil {
+			return 0, &ExecutionPSQLError{Err: err}
+		}
+
+		// clients which haven't seen the tombstones yet will have to sync from scratch
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`UPDATE users u SET purged_seq = greatest(u.purged_seq, p.seq)
+			FROM (SELECT user_id, max(change_seq) seq FROM %s WHERE deleted_at < $1 GROUP BY user_id) p WHERE u.id = p.user_id;`, table), before)
+		if err != nil {
+			return 0, &ExecutionPSQLError{Err: err}
+		}
+
+		if err := purge(table); err != nil {
+			return 0, err
+		}
+	}
+
+	// chunks of files are removed by the cascade
+	if err := purge("blob_data"); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, &ExecutionPSQLError{Err: err}
+	}
+
+	return purged, nil
+}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"gophkeeper/internal/domain"
+)
+
+// CreateUpload stores the upload, if the user has less than maxOpen unfinished ones.
+// Otherwise ErrTooManyUploads is returned.
+func (r *MaterialsStorage) CreateUpload(ctx context.Context, userID int, upload domain.BlobUpload, maxOpen int) error {
+	// abandoned uploads are not needed after they are expired
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM blob_uploads WHERE expires_at <= now();"); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	// concurrent creates of the user wait for each other, so the count can't be passed by
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE;", userID); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	var open int
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM blob_uploads WHERE user_id = $1 and expires_at > now();", userID).Scan(&open); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	if open >= maxOpen {
+		return domain.ErrTooManyUploads
+	}
+
+	crUploadStmt, err := tx.PrepareContext(ctx, `INSERT INTO blob_uploads (id, user_id, metadata, nonce, size, chunk_size, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7);`)
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer crUploadStmt.Close()
+
+	if _, err := crUploadStmt.ExecContext(ctx, upload.ID, userID, upload.Metadata, upload.Nonce,
+		upload.Size, upload.ChunkSize, upload.ExpiresAt); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	return nil
+}
+
+// GetUpload returns the upload together with the ranges of received chunks.
+func (r *MaterialsStorage) GetUpload(ctx context.Context, userID int, uploadID string) (domain.BlobUpload, error) {
+	upload := domain.BlobUpload{ID: uploadID}
+
+	getUploadStmt, err := r.db.PrepareContext(ctx, `SELECT metadata,nonce,size,chunk_size,expires_at FROM blob_uploads
+		WHERE id = $1 and user_id = $2 and expires_at > now();`)
+	if err != nil {
+		return upload, &StatementPSQLError{Err: err}
+	}
+	defer getUploadStmt.Close()
+
+	err = getUploadStmt.QueryRowContext(ctx, uploadID, userID).Scan(&upload.Metadata, &upload.Nonce, &upload.Size, &upload.ChunkSize, &upload.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return upload, &NotFoundError{Err: domain.ErrUploadNotFound}
+		default:
+			return upload, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	rows, err := r.db.QueryContext(ctx, "SELECT seq FROM upload_chunks WHERE upload_id = $1 ORDER BY seq;", uploadID)
+	if err != nil {
+		return upload, &ExecutionPSQLError{Err: err}
+	}
+	defer rows.Close()
+
+	upload.Received = make([][2]int, 0)
+	for rows.Next() {
+		var seq int
+		if err := rows.Scan(&seq); err != nil {
+			return upload, &ExecutionPSQLError{Err: err}
+		}
+
+		if last := len(upload.Received) - 1; last >= 0 && upload.Received[last][1] == seq-1 {
+			upload.Received[last][1] = seq
+		} else {
+			upload.Received = append(upload.Received, [2]int{seq, seq})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return upload, &ExecutionPSQLError{Err: err}
+	}
+
+	return upload, nil
+}
+
+// PutUploadChunk stores the chunk, a resent chunk replaces the previous one.
+func (r *MaterialsStorage) PutUploadChunk(ctx context.Context, userID int, uploadID string, seq int, data []byte) error {
+	putChunkStmt, err := r.db.PrepareContext(ctx, `INSERT INTO upload_chunks (upload_id, seq, data)
+		SELECT id, $3, $4 FROM blob_uploads WHERE id = $1 and user_id = $2 and expires_at > now()
+		ON CONFLICT (upload_id, seq) DO UPDATE SET data = excluded.data;`)
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer putChunkStmt.Close()
+
+	res, err := putChunkStmt.ExecContext(ctx, uploadID, userID, seq, data)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrUploadNotFound}
+	}
+
+	return nil
+}
+
+// FinalizeUpload moves received chunks to a new blob and removes the upload.
+// ErrUploadIncomplete is returned, if some chunks are missing.
+func (r *MaterialsStorage) FinalizeUpload(ctx context.Context, userID int, uploadID string) (domain.BlobData, error) {
+	var blob domain.BlobData
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+	defer tx.Rollback()
+
+	var upload domain.BlobUpload
+	err = tx.QueryRowContext(ctx, `SELECT metadata,nonce,size,chunk_size FROM blob_uploads
+		WHERE id = $1 and user_id = $2 and expires_at > now() FOR UPDATE;`, uploadID, userID).
+		Scan(&upload.Metadata, &upload.Nonce, &upload.Size, &upload.ChunkSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return blob, &NotFoundError{Err: domain.ErrUploadNotFound}
+		default:
+			return blob, &ExecutionPSQLError{Err: err}
+		}
+	}
+
+	var (
+		chunks   int
+		received int64
+	)
+	err = tx.QueryRowContext(ctx, "SELECT count(*), coalesce(sum(length(data)), 0) FROM upload_chunks WHERE upload_id = $1;", uploadID).
+		Scan(&chunks, &received)
+	if err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	// the sizes of chunks are checked when they are put, so the counters are enough here
+	if chunks != upload.Chunks() || received != upload.Size {
+		return blob, domain.ErrUploadIncomplete
+	}
+
+	blob.Metadata, blob.Nonce, blob.Size = upload.Metadata, upload.Nonce, upload.Size
+	err = tx.QueryRowContext(ctx, "INSERT INTO blob_data (user_id, metadata, nonce, size) VALUES ($1, $2, $3, $4) RETURNING id,created_at;",
+		userID, blob.Metadata, blob.Nonce, blob.Size).Scan(&blob.ID, &blob.CreatedAt)
+	if err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO blob_chunks (blob_id, seq, data) SELECT $1, seq, data FROM upload_chunks WHERE upload_id = $2;",
+		blob.ID, uploadID); err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM blob_uploads WHERE id = $1;", uploadID); err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return blob, &ExecutionPSQLError{Err: err}
+	}
+
+	return blob, nil
+}
+
+// DeleteUpload aborts the upload, received chunks are removed by the cascade.
+func (r *MaterialsStorage) DeleteUpload(ctx context.Context, userID int, uploadID string) error {
+	delUploadStmt, err := r.db.PrepareContext(ctx, "DELETE FROM blob_uploads WHERE id = $1 and user_id = $2;")
+	if err != nil {
+		return &StatementPSQLError{Err: err}
+	}
+	defer delUploadStmt.Close()
+
+	res, err := delUploadStmt.ExecContext(ctx, uploadID, userID)
+	if err != nil {
+		return &ExecutionPSQLError{Err: err}
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrUploadNotFound}
+	}
+
+	return nil
+}
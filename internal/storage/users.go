@@ -37,45 +37,27 @@ func (r *UserStorage) Create(ctx context.Context, user domain.User) error {
 	return nil
 }
 
-func (r *UserStorage) GetByCredentials(ctx context.Context, login, password string) (domain.User, error) {
-	user := domain.User{}
-
-	getUserStmt, err := r.db.PrepareContext(ctx, "SELECT id,password FROM users WHERE login=$1;")
-	if err != nil {
-		return user, &StatementPSQLError{Err: err}
-	}
-	defer getUserStmt.Close()
-
-	if err := getUserStmt.QueryRowContext(ctx, login).Scan(&user.ID, &user.Password); err != nil {
-		switch {
-		case errors.Is(err, sql.ErrNoRows):
-			return user, &NotFoundError{Err: domain.ErrUserNotFound}
-		default:
-			return user, &ExecutionPSQLError{Err: err}
-		}
-	}
+func (r *UserStorage) GetByLogin(ctx context.Context, login string) (domain.User, error) {
+	return r.getUser(ctx, "SELECT id,login,password,totp_secret,totp_enabled,totp_last_step FROM users WHERE login=$1;", login)
+}
 
-	if user.Password == password {
-		user.Login = login
-		return user, nil
-	} else {
-		return user, domain.ErrUserBadPassword
-	}
+func (r *UserStorage) GetByID(ctx context.Context, userID int) (domain.User, error) {
+	return r.getUser(ctx, "SELECT id,login,password,totp_secret,totp_enabled,totp_last_step FROM users WHERE id=$1;", userID)
 }
 
-func (r *UserStorage) GetByRefreshToken(ctx context.Context, refreshToken string) (domain.User, error) {
+func (r *UserStorage) getUser(ctx context.Context, query string, arg interface{}) (domain.User, error) {
 	user := domain.User{}
 
-	getUserStmt, err := r.db.PrepareContext(ctx, "SELECT id,login,password FROM users WHERE id = (SELECT user_id FROM sessions WHERE refresh_token=$1 and expired_at > now());")
+	getUserStmt, err := r.db.PrepareContext(ctx, query)
 	if err != nil {
 		return user, &StatementPSQLError{Err: err}
 	}
 	defer getUserStmt.Close()
 
-	if err := getUserStmt.QueryRowContext(ctx, refreshToken).Scan(&user.ID, &user.Login, &user.Password); err != nil {
+	if err := getUserStmt.QueryRowContext(ctx, arg).Scan(&user.ID, &user.Login, &user.Password, &user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep); err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return user, &NotFoundError{Err: domain.ErrUserNotFoundOrSessionWasExpired} //domain.ErrUserBadPassword
+			return user, &NotFoundError{Err: domain.ErrUserNotFound}
 		default:
 			return user, &ExecutionPSQLError{Err: err}
 		}
@@ -84,39 +66,70 @@ func (r *UserStorage) GetByRefreshToken(ctx context.Context, refreshToken string
 	return user, nil
 }
 
-func (r *UserStorage)SetSession(ctx context.Context, userID int, session domain.Session) error  {
-	crUserStmt, err := r.db.PrepareContext(ctx, "INSERT INTO sessions (refresh_token,user_id,expired_at) VALUES ($1, $2, $3);")
+func (r *UserStorage) UpdatePassword(ctx context.Context, userID int, passwordHash string) error {
+	updateUserStmt, err := r.db.PrepareContext(ctx, "UPDATE users SET password = $1 WHERE id = $2;")
 	if err != nil {
 		return &StatementPSQLError{Err: err}
 	}
-	defer crUserStmt.Close()
+	defer updateUserStmt.Close()
 
-	if _, err := crUserStmt.ExecContext(ctx, session.RefreshToken, userID, session.ExpiresAt); err != nil {
-		errCode := err.(*pq.Error).Code
-		if pgerrcode.IsIntegrityConstraintViolation(string(errCode)) {
-			return &AlreadyExistsError{Err: domain.ErrSessionAlreadyExists}
-		}
+	res, err := updateUserStmt.ExecContext(ctx, passwordHash, userID)
+	if err != nil {
 		return &ExecutionPSQLError{Err: err}
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrUserNotFound}
+	}
+
 	return nil
 }
 
-func (r *UserStorage)UpdateSession(ctx context.Context, userID int, session domain.Session, oldRefreshToken string) error {
-	crUserStmt, err := r.db.PrepareContext(ctx, "UPDATE sessions SET refresh_token = $1, user_id = $2, expired_at = $3 WHERE refresh_token = $4;")
+// SetTOTPSecret starts TOTP enrolment. The secret isn't used for sign in until it's enabled.
+func (r *UserStorage) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	return r.updateUser(ctx, "UPDATE users SET totp_secret = $1, totp_last_step = 0 WHERE id = $2 and not totp_enabled;", secret, userID)
+}
+
+func (r *UserStorage) EnableTOTP(ctx context.Context, userID int) error {
+	return r.updateUser(ctx, "UPDATE users SET totp_enabled = true WHERE id = $1 and totp_secret <> '';", userID)
+}
+
+// UseTOTPStep remembers the step of the accepted code. ErrBadSecondFactorCode is returned
+// if the step was already used, so every code is accepted only once.
+func (r *UserStorage) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	err := r.updateUser(ctx, "UPDATE users SET totp_last_step = $1 WHERE id = $2 and totp_last_step < $1;", step, userID)
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return domain.ErrBadSecondFactorCode
+	}
+	return err
+}
+
+func (r *UserStorage) updateUser(ctx context.Context, query string, args ...interface{}) error {
+	updateUserStmt, err := r.db.PrepareContext(ctx, query)
 	if err != nil {
 		return &StatementPSQLError{Err: err}
 	}
-	defer crUserStmt.Close()
+	defer updateUserStmt.Close()
 
-	if _, err := crUserStmt.ExecContext(ctx, session.RefreshToken, userID, session.ExpiresAt, oldRefreshToken); err != nil {
-		errCode := err.(*pq.Error).Code
-		if pgerrcode.IsIntegrityConstraintViolation(string(errCode)) {
-			return &AlreadyExistsError{Err: domain.ErrSessionAlreadyExists}
-		}
+	res, err := updateUserStmt.ExecContext(ctx, args...)
+	if err != nil {
 		return &ExecutionPSQLError{Err: err}
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return &NotFoundError{Err: domain.ErrUserNotFound}
+	}
+
 	return nil
 }
 
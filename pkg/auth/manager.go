@@ -1,9 +1,11 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"errors"
 	"fmt"
-	"math/rand"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -11,60 +13,166 @@ import (
 
 // TokenManager provides logic for JWT & Refresh tokens generation and parsing.
 type TokenManager interface {
-	NewJWT(userID string, ttl time.Duration) (string, error)
-	Parse(accessToken string) (string, error)
+	NewJWT(userID, sessionID string, ttl time.Duration) (string, Claims, error)
+	Parse(accessToken string) (Claims, error)
 	NewRefreshToken() (string, error)
 }
 
+// Claims are the access token claims used by the service.
+type Claims struct {
+	UserID    string
+	SessionID string
+	TokenID   string // unique id (jti), used to revoke the token
+	ExpiresAt time.Time
+}
+
+type tokenClaims struct {
+	jwt.StandardClaims
+	SessionID string `json:"sid,omitempty"`
+}
+
+func newTokenClaims(userID, sessionID string, ttl time.Duration) (*tokenClaims, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &tokenClaims{
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(ttl).Unix(),
+			Id:        fmt.Sprintf("%x", b),
+			Subject:   userID,
+		},
+		SessionID: sessionID,
+	}, nil
+}
+
+// Key is a signing key with its id. The id is put into the "kid" header of tokens.
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// ParseKey parses key in "kid=<kid>,secret=<secret>" format. The secret is taken as it is up to the end,
+// so it may contain any characters. Any other string is the secret itself, its kid is derived from it.
+func ParseKey(s string) (Key, error) {
+	var key Key
+	if rest, ok := cutPrefix(s, "kid="); ok {
+		kid, secret, found := strings.Cut(rest, ",secret=")
+		if !found || kid == "" {
+			return key, errors.New(`signing key must be "kid=<kid>,secret=<secret>" or just the secret`)
+		}
+		key.ID, key.Secret = kid, secret
+	} else {
+		key.Secret = s
+	}
+
+	if key.Secret == "" {
+		return key, errors.New("empty signing key")
+	}
+
+	if key.ID == "" {
+		sum := sha256.Sum256([]byte(key.Secret))
+		key.ID = fmt.Sprintf("%x", sum[:4])
+	}
+
+	return key, nil
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// Manager signs tokens with the active key and accepts tokens signed by any key of the keyring,
+// so keys may be rotated without logging everyone out.
 type Manager struct {
-	signingKey string
+	activeKey Key
+	keys      map[string]Key
 }
 
-func NewManager(signingKey string) (*Manager, error) {
-	if signingKey == "" {
+func NewManager(activeKey Key, retiredKeys ...Key) (*Manager, error) {
+	if activeKey.Secret == "" {
 		return nil, errors.New("empty signing key")
 	}
 
-	return &Manager{signingKey: signingKey}, nil
+	keys := make(map[string]Key, len(retiredKeys)+1)
+	for _, key := range append(retiredKeys, activeKey) {
+		if key.Secret == "" {
+			return nil, errors.New("empty signing key")
+		}
+		if _, ok := keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicated signing key id: %s", key.ID)
+		}
+		keys[key.ID] = key
+	}
+
+	return &Manager{activeKey: activeKey, keys: keys}, nil
 }
 
-func (m *Manager) NewJWT(userID string, ttl time.Duration) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(ttl).Unix(),
-		Subject:   userID,
-	})
+func (m *Manager) NewJWT(userID, sessionID string, ttl time.Duration) (string, Claims, error) {
+	claims, err := newTokenClaims(userID, sessionID, ttl)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = m.activeKey.ID
 
-	return token.SignedString([]byte(m.signingKey))
+	signed, err := token.SignedString([]byte(m.activeKey.Secret))
+	return signed, claims.toClaims(), err
 }
 
-func (m *Manager) Parse(accessToken string) (string, error) {
-	token, err := jwt.Parse(accessToken, func(token *jwt.Token) (i interface{}, err error) {
+func (m *Manager) Parse(accessToken string) (Claims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, func(token *jwt.Token) (i interface{}, err error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return []byte(m.signingKey), nil
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+
+		return []byte(key.Secret), nil
 	})
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", fmt.Errorf("error get user claims from token")
+	return claimsOf(token)
+}
+
+func (m *Manager) NewRefreshToken() (string, error) {
+	return newRefreshToken()
+}
+
+func claimsOf(token *jwt.Token) (Claims, error) {
+	claims, ok := token.Claims.(*tokenClaims)
+	if !ok || claims.Subject == "" {
+		return Claims{}, fmt.Errorf("error get user claims from token")
 	}
 
-	return claims["sub"].(string), nil
+	return claims.toClaims(), nil
 }
 
-//TODO
-func (m *Manager) NewRefreshToken() (string, error) {
-	b := make([]byte, 32)
+func (c *tokenClaims) toClaims() Claims {
+	return Claims{
+		UserID:    c.Subject,
+		SessionID: c.SessionID,
+		TokenID:   c.Id,
+		ExpiresAt: time.Unix(c.ExpiresAt, 0),
+	}
+}
 
-	s := rand.NewSource(time.Now().Unix())
-	r := rand.New(s)
+// newRefreshToken generates 256-bit random token.
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
 
-	if _, err := r.Read(b); err != nil {
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
 
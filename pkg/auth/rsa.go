@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// KeySetProvider is implemented by token managers, which tokens may be verified by third parties.
+type KeySetProvider interface {
+	JWKS() JWKS
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS is a set of public keys served on /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// RSAKey is a key pair with its id. The id is the JWK thumbprint (RFC 7638) of the public key.
+// Retired keys verify tokens only, so they may have no private key.
+type RSAKey struct {
+	ID         string
+	PrivateKey *rsa.PrivateKey
+	PublicKey  *rsa.PublicKey
+}
+
+// NewRSAKey wraps private key and computes its id.
+func NewRSAKey(privateKey *rsa.PrivateKey) RSAKey {
+	key := NewRSAPublicKey(&privateKey.PublicKey)
+	key.PrivateKey = privateKey
+	return key
+}
+
+// NewRSAPublicKey wraps public key of a retired key and computes its id.
+func NewRSAPublicKey(publicKey *rsa.PublicKey) RSAKey {
+	jwk := newJWK("", publicKey)
+	// members in lexicographic order as required by RFC 7638
+	thumbprint := sha256.Sum256([]byte(fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, jwk.E, jwk.N)))
+
+	return RSAKey{
+		ID:        base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+		PublicKey: publicKey,
+	}
+}
+
+// LoadRSAKey reads PEM encoded private key from file.
+func LoadRSAKey(path string) (RSAKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RSAKey{}, err
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+	if err != nil {
+		return RSAKey{}, err
+	}
+
+	return NewRSAKey(privateKey), nil
+}
+
+// LoadRSAPublicKey reads PEM encoded public key of a retired key from file. The private key of the pair
+// is accepted too, only its public part is kept.
+func LoadRSAPublicKey(path string) (RSAKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return RSAKey{}, err
+	}
+
+	publicKey, err := jwt.ParseRSAPublicKeyFromPEM(data)
+	if err != nil {
+		privateKey, privateErr := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if privateErr != nil {
+			return RSAKey{}, err
+		}
+		publicKey = &privateKey.PublicKey
+	}
+
+	return NewRSAPublicKey(publicKey), nil
+}
+
+func newJWK(kid string, publicKey *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		Kid: kid,
+		N:   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+	}
+}
+
+// RSAManager signs tokens with RS256. Tokens may be verified by anyone with the public keys,
+// which are published as JWKS. Retired keys are used only for verification.
+type RSAManager struct {
+	activeKey RSAKey
+	keys      map[string]*rsa.PublicKey
+	jwks      JWKS
+}
+
+func NewRSAManager(activeKey RSAKey, retiredKeys ...RSAKey) (*RSAManager, error) {
+	if activeKey.PrivateKey == nil {
+		return nil, errors.New("empty signing key")
+	}
+
+	m := &RSAManager{
+		activeKey: activeKey,
+		keys:      make(map[string]*rsa.PublicKey, len(retiredKeys)+1),
+	}
+
+	for _, key := range append([]RSAKey{activeKey}, retiredKeys...) {
+		publicKey := key.PublicKey
+		if publicKey == nil && key.PrivateKey != nil {
+			publicKey = &key.PrivateKey.PublicKey
+		}
+		if publicKey == nil {
+			return nil, errors.New("empty verification key")
+		}
+		if _, ok := m.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicated signing key id: %s", key.ID)
+		}
+		m.keys[key.ID] = publicKey
+		m.jwks.Keys = append(m.jwks.Keys, newJWK(key.ID, publicKey))
+	}
+
+	return m, nil
+}
+
+func (m *RSAManager) NewJWT(userID, sessionID string, ttl time.Duration) (string, Claims, error) {
+	claims, err := newTokenClaims(userID, sessionID, ttl)
+	if err != nil {
+		return "", Claims{}, err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.activeKey.ID
+
+	signed, err := token.SignedString(m.activeKey.PrivateKey)
+	return signed, claims.toClaims(), err
+}
+
+func (m *RSAManager) Parse(accessToken string) (Claims, error) {
+	token, err := jwt.ParseWithClaims(accessToken, &tokenClaims{}, func(token *jwt.Token) (i interface{}, err error) {
+		// the key type alone would let RS384 and RS512 in, tokens are signed with RS256 only
+		if token.Method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key: %q", kid)
+		}
+
+		return key, nil
+	})
+	if err != nil {
+		return Claims{}, err
+	}
+
+	return claimsOf(token)
+}
+
+func (m *RSAManager) NewRefreshToken() (string, error) {
+	return newRefreshToken()
+}
+
+func (m *RSAManager) JWKS() JWKS {
+	return m.jwks
+}
@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// the example key of RFC 7638 section 3.1
+const (
+	rfcKeyN = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	rfcKeyE = "AQAB"
+	// the thumbprint given in RFC 7638 section 3.1
+	rfcKeyThumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+)
+
+func rfcPublicKey(t *testing.T) *rsa.PublicKey {
+	t.Helper()
+	n, err := base64.RawURLEncoding.DecodeString(rfcKeyN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(rfcKeyE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+}
+
+func newTestRSAKey(t *testing.T) RSAKey {
+	t.Helper()
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewRSAKey(privateKey)
+}
+
+func TestRSAKeyThumbprint(t *testing.T) {
+	key := NewRSAPublicKey(rfcPublicKey(t))
+	if key.ID != rfcKeyThumbprint {
+		t.Errorf("kid = %s, want %s", key.ID, rfcKeyThumbprint)
+	}
+}
+
+func TestRSAManagerJWKS(t *testing.T) {
+	active := newTestRSAKey(t)
+	retired := NewRSAPublicKey(rfcPublicKey(t))
+
+	m, err := NewRSAManager(active, retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := m.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(jwks.Keys))
+	}
+
+	if jwks.Keys[0].Kid != active.ID {
+		t.Errorf("the first key is %s, want the active one %s", jwks.Keys[0].Kid, active.ID)
+	}
+
+	want := JWK{Kty: "RSA", Use: "sig", Alg: "RS256", Kid: rfcKeyThumbprint, N: rfcKeyN, E: rfcKeyE}
+	if jwks.Keys[1] != want {
+		t.Errorf("retired key is %+v, want %+v", jwks.Keys[1], want)
+	}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Kid != NewRSAPublicKey(publicKeyOf(t, jwk)).ID {
+			t.Errorf("kid %s isn't the thumbprint of the published key", jwk.Kid)
+		}
+	}
+}
+
+// publicKeyOf decodes the key as a third party verifying the tokens would do.
+func publicKeyOf(t *testing.T, jwk JWK) *rsa.PublicKey {
+	t.Helper()
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+}
+
+func TestRSAManagerParse(t *testing.T) {
+	active := newTestRSAKey(t)
+	retired := newTestRSAKey(t)
+
+	m, err := NewRSAManager(active, RSAKey{ID: retired.ID, PublicKey: retired.PublicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, claims, err := m.NewJWT("1", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := m.Parse(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != claims {
+		t.Errorf("parsed %+v, want %+v", parsed, claims)
+	}
+
+	// tokens signed before the rotation are accepted until they expire
+	oldManager, err := NewRSAManager(retired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, _, err := oldManager.NewJWT("1", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Parse(oldToken); err != nil {
+		t.Errorf("token of the retired key: %v", err)
+	}
+
+	unknown, err := NewRSAManager(newTestRSAKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknownToken, _, err := unknown.NewJWT("1", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Parse(unknownToken); err == nil {
+		t.Error("token of an unknown key is accepted")
+	}
+}
+
+// Tokens must be verified with the algorithm of the key, not the one chosen by the token.
+func TestRSAManagerRejectsOtherAlgorithms(t *testing.T) {
+	active := newTestRSAKey(t)
+
+	m, err := NewRSAManager(active)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := newTokenClaims("1", "session", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(method jwt.SigningMethod, key interface{}) string {
+		t.Helper()
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = active.ID
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	// the public key is known to everyone, it must not work as HMAC secret
+	publicDER, err := x509.MarshalPKIXPublicKey(active.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	tokens := map[string]string{
+		"HS256 with public key PEM": sign(jwt.SigningMethodHS256, publicPEM),
+		"HS256 with public key DER": sign(jwt.SigningMethodHS256, publicDER),
+		"none":                      sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType),
+		"RS512":                     sign(jwt.SigningMethodRS512, active.PrivateKey),
+		"PS256":                     sign(jwt.SigningMethodPS256, active.PrivateKey),
+	}
+
+	for name, token := range tokens {
+		if _, err := m.Parse(token); err == nil {
+			t.Errorf("%s token is accepted", name)
+		}
+	}
+}
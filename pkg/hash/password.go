@@ -1,16 +1,121 @@
 package hash
 
 import (
+	"crypto/rand"
 	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 )
 
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
 //PasswordHasher provides hashing logic to securely store passwords.
 type PasswordHasher interface {
 	Hash(password string) (string, error)
+	// Verify reports whether password matches hash. ErrUnsupportedHash is returned
+	// if hash was made by another hasher.
+	Verify(password, hash string) (bool, error)
+}
+
+//**********************************************************************************************************************
+// Argon2id
+//**********************************************************************************************************************
+
+const (
+	argon2Prefix = "$argon2id$"
+	// shorter salts and keys of hashes are not accepted
+	minSaltLen = 8
+	minKeyLen  = 16
+)
+
+// Argon2Hasher uses argon2id with a random salt per password. Parameters are encoded into the hash
+// in the PHC string format, so they may be changed without breaking existing hashes.
+type Argon2Hasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+	saltLen uint32
+	keyLen  uint32
+}
+
+func NewArgon2Hasher() *Argon2Hasher {
+	return &Argon2Hasher{
+		time:    3,
+		memory:  64 * 1024,
+		threads: 2,
+		saltLen: 16,
+		keyLen:  32,
+	}
 }
 
+// Hash creates argon2id hash of given password.
+func (h *Argon2Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, h.keyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2Prefix, argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks password against argon2id hash using the parameters stored in it.
+func (h *Argon2Hasher) Verify(password, hash string) (bool, error) {
+	if !strings.HasPrefix(hash, argon2Prefix) {
+		return false, ErrUnsupportedHash
+	}
+
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnsupportedHash
+	}
+
+	var (
+		memory, time uint32
+		threads      uint8
+	)
+	// argon2 panics on zero time or threads
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || time < 1 || threads < 1 {
+		return false, ErrUnsupportedHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < minSaltLen {
+		return false, ErrUnsupportedHash
+	}
+
+	// an empty key would match any password
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < minKeyLen {
+		return false, ErrUnsupportedHash
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+//**********************************************************************************************************************
+// SHA1 (legacy)
+//**********************************************************************************************************************
+
 // SHA1Hasher uses SHA1 to hash passwords with provided salt.
+// Deprecated: it is kept only to verify hashes created before Argon2Hasher.
 type SHA1Hasher struct {
 	salt string
 }
@@ -28,4 +133,18 @@ func (h *SHA1Hasher) Hash(password string) (string, error) {
 	}
 
 	return fmt.Sprintf("%x", hash.Sum([]byte(h.salt))), nil
-}
\ No newline at end of file
+}
+
+// Verify checks password against SHA1 hash.
+func (h *SHA1Hasher) Verify(password, hash string) (bool, error) {
+	if strings.HasPrefix(hash, "$") {
+		return false, ErrUnsupportedHash
+	}
+
+	otherHash, err := h.Hash(password)
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(otherHash)) == 1, nil
+}
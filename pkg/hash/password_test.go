@@ -0,0 +1,149 @@
+package hash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// a cheap hasher, the parameters are read from the hash on verify anyway
+func newTestArgon2Hasher() *Argon2Hasher {
+	return &Argon2Hasher{time: 1, memory: 64, threads: 1, saltLen: 16, keyLen: 32}
+}
+
+func TestArgon2Hasher(t *testing.T) {
+	h := newTestArgon2Hasher()
+
+	hash, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected hash format %s", hash)
+	}
+
+	other, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash == other {
+		t.Error("hashes of the same password are the same, the salt isn't random")
+	}
+
+	if ok, err := h.Verify("secret", hash); err != nil || !ok {
+		t.Errorf("right password: %v, %v", ok, err)
+	}
+	if ok, err := h.Verify("Secret", hash); err != nil || ok {
+		t.Errorf("wrong password: %v, %v", ok, err)
+	}
+
+	// parameters are taken from the hash, not from the hasher
+	if ok, err := NewArgon2Hasher().Verify("secret", hash); err != nil || !ok {
+		t.Errorf("hash of other parameters: %v, %v", ok, err)
+	}
+}
+
+// well formed salt and key, the malformed hashes are broken in other parts
+const (
+	testSalt = "c2FsdHNhbHRzYWx0c2FsdA"
+	testKey  = "8w5hq2w6zTRXbV+I4SsS5jD6sh9Lc+0uZ1EmAyzIvTo"
+)
+
+func TestArgon2HasherVerifyMalformed(t *testing.T) {
+	h := newTestArgon2Hasher()
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "sha1 hash", hash: "2a1bcf7ce7f1e3b7aa62ee6cb5b2e4c9f3c6b1a7"},
+		{name: "other algorithm", hash: "$argon2i$v=19$m=64,t=1,p=1$" + testSalt + "$" + testKey},
+		{name: "missing part", hash: "$argon2id$v=19$m=64,t=1,p=1$" + testSalt},
+		{name: "extra part", hash: "$argon2id$v=19$m=64,t=1,p=1$" + testSalt + "$" + testKey + "$x"},
+		{name: "other version", hash: "$argon2id$v=16$m=64,t=1,p=1$" + testSalt + "$" + testKey},
+		{name: "bad params", hash: "$argon2id$v=19$m=64;t=1;p=1$" + testSalt + "$" + testKey},
+		{name: "zero time", hash: "$argon2id$v=19$m=64,t=0,p=1$" + testSalt + "$" + testKey},
+		{name: "zero threads", hash: "$argon2id$v=19$m=64,t=1,p=0$" + testSalt + "$" + testKey},
+		{name: "too many threads", hash: "$argon2id$v=19$m=64,t=1,p=256$" + testSalt + "$" + testKey},
+		{name: "salt isn't base64", hash: "$argon2id$v=19$m=64,t=1,p=1$!!!$" + testKey},
+		{name: "key isn't base64", hash: "$argon2id$v=19$m=64,t=1,p=1$" + testSalt + "$!!!"},
+		{name: "empty key", hash: "$argon2id$v=19$m=64,t=1,p=1$" + testSalt + "$"},
+		{name: "short key", hash: "$argon2id$v=19$m=64,t=1,p=1$" + testSalt + "$AAAA"},
+		{name: "empty salt", hash: "$argon2id$v=19$m=64,t=1,p=1$$" + testKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := h.Verify("secret", tt.hash)
+			if !errors.Is(err, ErrUnsupportedHash) {
+				t.Errorf("got %v, want ErrUnsupportedHash", err)
+			}
+			if ok {
+				t.Error("malformed hash is matched")
+			}
+		})
+	}
+}
+
+func TestArgon2HasherVerifyTampered(t *testing.T) {
+	h := newTestArgon2Hasher()
+
+	hash, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(hash, "$")
+
+	tamper := func(i int, value string) string {
+		p := append([]string(nil), parts...)
+		p[i] = value
+		return strings.Join(p, "$")
+	}
+
+	salt := []byte(parts[4])
+	salt[0] ^= 1
+	key := []byte(parts[5])
+	key[0] ^= 1
+
+	for name, tampered := range map[string]string{
+		"time":   tamper(3, "m=64,t=2,p=1"),
+		"memory": tamper(3, "m=128,t=1,p=1"),
+		"salt":   tamper(4, string(salt)),
+		"key":    tamper(5, string(key)),
+	} {
+		ok, err := h.Verify("secret", tampered)
+		if err != nil && !errors.Is(err, ErrUnsupportedHash) {
+			t.Errorf("%s: unexpected error %v", name, err)
+		}
+		if ok {
+			t.Errorf("%s: tampered hash is matched", name)
+		}
+	}
+}
+
+func TestSHA1Hasher(t *testing.T) {
+	h := NewSHA1Hasher("salt")
+
+	hash, err := h.Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := h.Verify("secret", hash); err != nil || !ok {
+		t.Errorf("right password: %v, %v", ok, err)
+	}
+	if ok, err := h.Verify("Secret", hash); err != nil || ok {
+		t.Errorf("wrong password: %v, %v", ok, err)
+	}
+	if ok, err := NewSHA1Hasher("pepper").Verify("secret", hash); err != nil || ok {
+		t.Errorf("other salt: %v, %v", ok, err)
+	}
+
+	argonHash, err := newTestArgon2Hasher().Hash("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Verify("secret", argonHash); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("argon2 hash: got %v, want ErrUnsupportedHash", err)
+	}
+}
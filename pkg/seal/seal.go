@@ -0,0 +1,83 @@
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id parameters used to derive vault keys from master passwords.
+const (
+	keyTime    = 3
+	keyMemory  = 64 * 1024
+	keyThreads = 4
+	keyLen     = 32
+)
+
+var ErrOpen = errors.New("message authentication failed")
+
+// Sealer provides authenticated encryption of vault items on the client side.
+type Sealer interface {
+	Seal(plaintext, additionalData []byte) (ciphertext, nonce []byte, err error)
+	Open(ciphertext, nonce, additionalData []byte) ([]byte, error)
+	// Overhead is the number of bytes the nonce and the ciphertext add to the plaintext.
+	Overhead() int
+}
+
+// AESGCMSealer uses AES-256-GCM with a random nonce for every message.
+type AESGCMSealer struct {
+	aead cipher.AEAD
+}
+
+func NewAESGCMSealer(key []byte) (*AESGCMSealer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AESGCMSealer{aead: aead}, nil
+}
+
+// Seal encrypts plaintext and authenticates it together with additionalData.
+func (s *AESGCMSealer) Seal(plaintext, additionalData []byte) ([]byte, []byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	return s.aead.Seal(nil, nonce, plaintext, additionalData), nonce, nil
+}
+
+// Open decrypts ciphertext sealed with the same key and additionalData.
+func (s *AESGCMSealer) Open(ciphertext, nonce, additionalData []byte) ([]byte, error) {
+	if len(nonce) != s.aead.NonceSize() {
+		return nil, ErrOpen
+	}
+
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		return nil, ErrOpen
+	}
+
+	return plaintext, nil
+}
+
+func (s *AESGCMSealer) Overhead() int {
+	return s.aead.NonceSize() + s.aead.Overhead()
+}
+
+// DeriveKey derives a 256-bit key from the master password with argon2id.
+// The salt is bound to the login, so every device of the user gets the same key.
+func DeriveKey(masterPassword, login string) []byte {
+	salt := sha256.Sum256([]byte("gophkeeper:" + login))
+	return argon2.IDKey([]byte(masterPassword), salt[:], keyTime, keyMemory, keyThreads, keyLen)
+}
@@ -0,0 +1,121 @@
+package seal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func newTestSealer(t *testing.T, key []byte) *AESGCMSealer {
+	t.Helper()
+	s, err := NewAESGCMSealer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSealOpen(t *testing.T) {
+	s := newTestSealer(t, bytes.Repeat([]byte{1}, 32))
+
+	for _, plaintext := range [][]byte{nil, []byte("x"), bytes.Repeat([]byte("secret"), 1000)} {
+		ciphertext, nonce, err := s.Seal(plaintext, []byte("text/1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ciphertext)+len(nonce) != len(plaintext)+s.Overhead() {
+			t.Errorf("sealed %d bytes into %d, overhead is %d", len(plaintext), len(ciphertext)+len(nonce), s.Overhead())
+		}
+
+		opened, err := s.Open(ciphertext, nonce, []byte("text/1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("opened %q, want %q", opened, plaintext)
+		}
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	s := newTestSealer(t, bytes.Repeat([]byte{1}, 32))
+
+	ct1, nonce1, err := s.Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct2, nonce2, err := s.Seal([]byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(nonce1, nonce2) || bytes.Equal(ct1, ct2) {
+		t.Error("the same plaintext is sealed into the same ciphertext")
+	}
+}
+
+func TestOpenFails(t *testing.T) {
+	s := newTestSealer(t, bytes.Repeat([]byte{1}, 32))
+	other := newTestSealer(t, bytes.Repeat([]byte{2}, 32))
+
+	ciphertext, nonce, err := s.Seal([]byte("secret"), []byte("text/1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name       string
+		sealer     Sealer
+		ciphertext []byte
+		nonce      []byte
+		ad         []byte
+	}{
+		{name: "wrong key", sealer: other, ciphertext: ciphertext, nonce: nonce, ad: []byte("text/1")},
+		{name: "wrong additional data", sealer: s, ciphertext: ciphertext, nonce: nonce, ad: []byte("text/2")},
+		{name: "no additional data", sealer: s, ciphertext: ciphertext, nonce: nonce},
+		{name: "tampered ciphertext", sealer: s, ciphertext: tampered, nonce: nonce, ad: []byte("text/1")},
+		{name: "short nonce", sealer: s, ciphertext: ciphertext, nonce: nonce[:4], ad: []byte("text/1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.sealer.Open(tt.ciphertext, tt.nonce, tt.ad); !errors.Is(err, ErrOpen) {
+				t.Errorf("got %v, want ErrOpen", err)
+			}
+		})
+	}
+}
+
+// The vault key must not change for the same master password and login, otherwise users are locked out
+// of the items sealed before. Changes of the argon2 parameters need a migration of the vaults.
+func TestDeriveKeyVectors(t *testing.T) {
+	tests := []struct {
+		password string
+		login    string
+		key      string
+	}{
+		{
+			password: "correct horse battery staple",
+			login:    "gopher",
+			key:      "448dc3cb9c9ec0fd9c4286821c0b9dbb108a7ce2d09dcae0909c153766490046",
+		},
+		{
+			password: "",
+			login:    "",
+			key:      "1814f620a3a43cf6edeafdfae4682523712cb47cc17838f1c9f6e21270222b20",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := hex.EncodeToString(DeriveKey(tt.password, tt.login)); got != tt.key {
+			t.Errorf("DeriveKey(%q, %q) = %s, want %s", tt.password, tt.login, got, tt.key)
+		}
+	}
+
+	if bytes.Equal(DeriveKey("password", "alice"), DeriveKey("password", "bob")) {
+		t.Error("keys of different logins are the same")
+	}
+}
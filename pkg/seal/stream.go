@@ -0,0 +1,185 @@
+package seal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// StreamChunkSize is the size of plaintext sealed into one frame of a stream.
+const StreamChunkSize = 64 * 1024
+
+var ErrTruncated = errors.New("sealed stream is truncated")
+
+// Streams are sealed frame by frame, so files of any size are processed with constant memory.
+// Frame layout: uint32 ciphertext length, uint8 nonce length, nonce, ciphertext.
+// Every frame is authenticated with its index and a flag of the last frame,
+// so frames can't be reordered, dropped or cut off at the end. Frames of streams sealed with the same key
+// and additional data are interchangeable, so the additional data must be unique per stream, e.g. a random id.
+
+const frameHeaderSize = 5
+
+// StreamFrameSize is the size of every sealed frame of a stream but the last one.
+// Streams of the same plaintext have the same layout, so they may be split at frame boundaries
+// and their parts combined, even if they were sealed separately.
+func StreamFrameSize(s Sealer) int {
+	return frameHeaderSize + s.Overhead() + StreamChunkSize
+}
+
+// SealedStreamSize returns the size of the stream sealed from plaintextSize bytes.
+func SealedStreamSize(s Sealer, plaintextSize int64) int64 {
+	frames := (plaintextSize + StreamChunkSize - 1) / StreamChunkSize
+	if frames == 0 {
+		frames = 1 // the last frame is written even for empty plaintext
+	}
+	return frames*int64(frameHeaderSize+s.Overhead()) + plaintextSize
+}
+
+func frameAD(additionalData []byte, index uint64, last bool) []byte {
+	ad := make([]byte, len(additionalData)+9)
+	n := copy(ad, additionalData)
+	binary.BigEndian.PutUint64(ad[n:], index)
+	if last {
+		ad[n+8] = 1
+	}
+	return ad
+}
+
+type streamWriter struct {
+	s     Sealer
+	w     io.Writer
+	ad    []byte
+	buf   []byte
+	index uint64
+}
+
+// NewStreamWriter seals everything written to it into w. Close must be called to write the last frame.
+// additionalData identifies the stream, see the frame layout.
+func NewStreamWriter(s Sealer, w io.Writer, additionalData []byte) io.WriteCloser {
+	return &streamWriter{
+		s:   s,
+		w:   w,
+		ad:  additionalData,
+		buf: make([]byte, 0, StreamChunkSize),
+	}
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		// the frame is written only when there is more data, so the last one is always written by Close
+		if len(sw.buf) == StreamChunkSize {
+			if err := sw.writeFrame(false); err != nil {
+				return n, err
+			}
+		}
+
+		m := copy(sw.buf[len(sw.buf):cap(sw.buf)], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (sw *streamWriter) Close() error {
+	return sw.writeFrame(true)
+}
+
+func (sw *streamWriter) writeFrame(last bool) error {
+	ciphertext, nonce, err := sw.s.Seal(sw.buf, frameAD(sw.ad, sw.index, last))
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(ciphertext)))
+	header[4] = uint8(len(nonce))
+
+	for _, b := range [][]byte{header, nonce, ciphertext} {
+		if _, err := sw.w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	sw.buf = sw.buf[:0]
+	sw.index++
+	return nil
+}
+
+type streamReader struct {
+	s     Sealer
+	r     *bufio.Reader
+	ad    []byte
+	buf   []byte
+	index uint64
+	done  bool
+}
+
+// NewStreamReader opens a stream sealed by NewStreamWriter with the same key and additionalData.
+func NewStreamReader(s Sealer, r io.Reader, additionalData []byte) io.Reader {
+	return &streamReader{
+		s:  s,
+		r:  bufio.NewReader(r),
+		ad: additionalData,
+	}
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.readFrame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *streamReader) readFrame() error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(sr.r, header); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+
+	ctLen := binary.BigEndian.Uint32(header)
+	if ctLen > 2*StreamChunkSize {
+		return ErrOpen
+	}
+
+	frame := make([]byte, int(header[4])+int(ctLen))
+	if _, err := io.ReadFull(sr.r, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncated
+		}
+		return err
+	}
+	nonce, ciphertext := frame[:header[4]], frame[header[4]:]
+
+	// the frame is either the last one or not, so it's enough to try both flags
+	plaintext, err := sr.s.Open(ciphertext, nonce, frameAD(sr.ad, sr.index, false))
+	if err != nil {
+		plaintext, err = sr.s.Open(ciphertext, nonce, frameAD(sr.ad, sr.index, true))
+		if err != nil {
+			return err
+		}
+		sr.done = true
+
+		// nothing may follow the last frame
+		if _, err := sr.r.ReadByte(); !errors.Is(err, io.EOF) {
+			return ErrOpen
+		}
+	}
+
+	sr.buf = plaintext
+	sr.index++
+	return nil
+}
@@ -0,0 +1,75 @@
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func sealStream(t *testing.T, s Sealer, plaintext, ad []byte) []byte {
+	t.Helper()
+	var sealed bytes.Buffer
+	w := NewStreamWriter(s, &sealed, ad)
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return sealed.Bytes()
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	s := newTestSealer(t, bytes.Repeat([]byte{1}, 32))
+
+	for _, size := range []int{0, 1, StreamChunkSize - 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize + 17} {
+		plaintext := bytes.Repeat([]byte{7}, size)
+		sealed := sealStream(t, s, plaintext, []byte("file-id"))
+
+		if int64(len(sealed)) != SealedStreamSize(s, int64(size)) {
+			t.Errorf("%d bytes are sealed into %d, SealedStreamSize is %d", size, len(sealed), SealedStreamSize(s, int64(size)))
+		}
+
+		opened, err := io.ReadAll(NewStreamReader(s, bytes.NewReader(sealed), []byte("file-id")))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if !bytes.Equal(opened, plaintext) {
+			t.Errorf("%d bytes: opened stream differs", size)
+		}
+	}
+}
+
+func TestStreamOpenFails(t *testing.T) {
+	s := newTestSealer(t, bytes.Repeat([]byte{1}, 32))
+	plaintext := bytes.Repeat([]byte{7}, 2*StreamChunkSize+5)
+	sealed := sealStream(t, s, plaintext, []byte("file-id"))
+	frame := StreamFrameSize(s)
+
+	swapped := append(append(append([]byte(nil), sealed[frame:2*frame]...), sealed[:frame]...), sealed[2*frame:]...)
+
+	tests := []struct {
+		name   string
+		sealer Sealer
+		sealed []byte
+		ad     []byte
+		err    error
+	}{
+		{name: "wrong key", sealer: newTestSealer(t, bytes.Repeat([]byte{2}, 32)), sealed: sealed, ad: []byte("file-id"), err: ErrOpen},
+		{name: "wrong additional data", sealer: s, sealed: sealed, ad: []byte("other-id"), err: ErrOpen},
+		{name: "cut at frame boundary", sealer: s, sealed: sealed[:2*frame], ad: []byte("file-id"), err: ErrTruncated},
+		{name: "cut inside frame", sealer: s, sealed: sealed[:len(sealed)-1], ad: []byte("file-id"), err: ErrTruncated},
+		{name: "reordered frames", sealer: s, sealed: swapped, ad: []byte("file-id"), err: ErrOpen},
+		{name: "data after last frame", sealer: s, sealed: append(append([]byte(nil), sealed...), 0), ad: []byte("file-id"), err: ErrOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := io.ReadAll(NewStreamReader(tt.sealer, bytes.NewReader(tt.sealed), tt.ad))
+			if !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 parameters supported by all authenticator apps.
+const (
+	period    = 30
+	Digits    = 6
+	secretLen = 20
+	// codes of the adjacent steps are accepted as well to tolerate clock drift
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a random base32 encoded secret.
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// URI returns otpauth URI to be shown as a QR code or entered into an authenticator app.
+func URI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(period))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Step returns the time step number of t.
+func Step(t time.Time) int64 {
+	return t.Unix() / period
+}
+
+// Code returns the code of the given time step.
+func Code(secret string, step int64) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, value%1000000), nil
+}
+
+// Validate checks code at time t and returns the matched step. Callers should reject steps
+// that are not greater than the last used one, so a code can't be replayed.
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	current := Step(t)
+	for step := current - skew; step <= current+skew; step++ {
+		expected, err := Code(secret, step)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
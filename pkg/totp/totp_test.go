@@ -0,0 +1,112 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// base32 of "12345678901234567890", the SHA1 seed of RFC 6238 Appendix B
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 Appendix B gives 8 digit codes, the last 6 digits are the 6 digit codes.
+var rfcVectors = []struct {
+	unix int64
+	step int64
+	code string
+}{
+	{unix: 59, step: 0x1, code: "287082"},
+	{unix: 1111111109, step: 0x23523EC, code: "081804"},
+	{unix: 1111111111, step: 0x23523ED, code: "050471"},
+	{unix: 1234567890, step: 0x273EF07, code: "005924"},
+	{unix: 2000000000, step: 0x3F940AA, code: "279037"},
+	{unix: 20000000000, step: 0x27BC86AA, code: "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, tt := range rfcVectors {
+		if step := Step(time.Unix(tt.unix, 0)); step != tt.step {
+			t.Errorf("Step(%d) = %x, want %x", tt.unix, step, tt.step)
+		}
+
+		code, err := Code(rfcSecret, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+
+		// secrets are accepted in lower case too
+		if code, _ := Code(strings.ToLower(rfcSecret), tt.step); code != tt.code {
+			t.Errorf("Code of lower case secret at %d = %s, want %s", tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0) // step 0x23523ED, code 050471
+
+	tests := []struct {
+		name string
+		code string
+		at   time.Time
+		ok   bool
+		step int64
+	}{
+		{name: "current step", code: "050471", at: now, ok: true, step: 0x23523ED},
+		{name: "spaces around", code: " 050471 ", at: now, ok: true, step: 0x23523ED},
+		{name: "previous step", code: "050471", at: now.Add(period * time.Second), ok: true, step: 0x23523ED},
+		{name: "next step", code: "050471", at: now.Add(-period * time.Second), ok: true, step: 0x23523ED},
+		{name: "two steps later", code: "050471", at: now.Add(2 * period * time.Second), ok: false},
+		{name: "two steps earlier", code: "050471", at: now.Add(-2 * period * time.Second), ok: false},
+		{name: "wrong code", code: "050472", at: now, ok: false},
+		{name: "short code", code: "05047", at: now, ok: false},
+		{name: "8 digit code", code: "14050471", at: now, ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok, err := Validate(rfcSecret, tt.code, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && step != tt.step {
+				t.Errorf("step = %x, want %x", step, tt.step)
+			}
+		})
+	}
+}
+
+func TestValidateBadSecret(t *testing.T) {
+	if _, _, err := Validate("not base32!", "123456", time.Now()); err == nil {
+		t.Error("bad secret is accepted")
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != secretLen {
+		t.Errorf("secret has %d bytes, want %d", len(key), secretLen)
+	}
+
+	uri, err := url.Parse(URI("GophKeeper", "gopher", secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Query().Get("secret") != secret || uri.Query().Get("digits") != "6" || uri.Query().Get("period") != "30" {
+		t.Errorf("unexpected uri %s", uri)
+	}
+}